@@ -0,0 +1,304 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"localfs/util/fsutil"
+	"localfs/view"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const apiPrefix = "/api/v1"
+
+type apiFile struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Sha256   string    `json:"sha256,omitempty"`
+	Download string    `json:"download,omitempty"`
+}
+
+type apiListing struct {
+	Dir   string    `json:"dir"`
+	Files []apiFile `json:"files"`
+}
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+func apiRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+apiPrefix+"/files", allow(permRead, apiListHandler, apiErrorHandler))
+	mux.HandleFunc("POST "+apiPrefix+"/files", allow(permUpload, writable(apiUploadHandler, apiErrorHandler), apiErrorHandler))
+	mux.HandleFunc(apiPrefix+"/files", apiMethodNotAllowedHandler)
+	mux.HandleFunc("GET "+apiPrefix+"/files/{path...}", allow(permRead, apiMetadataHandler, apiErrorHandler))
+	mux.HandleFunc("DELETE "+apiPrefix+"/files/{path...}", allow(permModify, writable(apiDeleteHandler, apiErrorHandler), apiErrorHandler))
+	mux.HandleFunc(apiPrefix+"/files/{path...}", apiMethodNotAllowedHandler)
+	mux.HandleFunc("GET "+apiPrefix+"/download/{path...}", allow(permRead, apiDownloadHandler, apiErrorHandler))
+	mux.HandleFunc("GET "+apiPrefix+"/openapi.json", apiOpenAPIHandler)
+	mux.HandleFunc("/api/", apiNotFoundHandler)
+}
+
+// hashCache keeps the checksums of the files, uploads add the
+// checksum computed while writing.
+var hashCache = fsutil.NewHashCache()
+
+// newAPIFile returns the metadata of the file at the relative path, the
+// checksum of a file is computed with hash, and otherwise only given once
+// cached, so a listing does not read every file.
+func newAPIFile(rel, fpath string, info os.FileInfo, hash bool) (apiFile, error) {
+	f := apiFile{
+		Name:    info.Name(),
+		Path:    rel,
+		Type:    "file",
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+	if info.IsDir() {
+		f.Type = "dir"
+		f.Size = 0
+		return f, nil
+	}
+
+	f.Download = apiPrefix + "/download/" + view.EscapePath(rel)
+	if !hash {
+		f.Sha256, _ = hashCache.Get(fpath, info)
+		return f, nil
+	}
+	sum, err := hashCache.Sha256sum(fpath)
+	if err != nil {
+		return f, err
+	}
+	f.Sha256 = sum
+	return f, nil
+}
+
+func apiListHandler(w http.ResponseWriter, r *http.Request) {
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	fpath, err := storageDir(dir)
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+	// the checksums of the files are computed on request
+	hash := r.URL.Query().Get("hash") == "1"
+
+	entries, err := fsutil.Listing(fpath)
+	if err != nil {
+		apiErrorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	listing := apiListing{Dir: dir, Files: []apiFile{}}
+	for _, entry := range entries {
+		epath := filepath.Join(fpath, entry.Name)
+		info, err := os.Stat(epath)
+		if err != nil {
+			// removed after listing
+			continue
+		}
+		f, err := newAPIFile(path.Join(dir, entry.Name), epath, info, hash)
+		if err != nil {
+			apiErrorHandler(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listing.Files = append(listing.Files, f)
+	}
+
+	apiWriteJSON(w, listing, http.StatusOK)
+}
+
+func apiUploadHandler(w http.ResponseWriter, r *http.Request) {
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	fpath, err := storageDir(dir)
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		apiErrorHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// store every file part
+	listing := apiListing{Dir: dir, Files: []apiFile{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			apiErrorHandler(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		fname, _, hash, err := fsutil.SaveStream(fpath, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			apiStorageErrorHandler(w, err)
+			return
+		}
+
+		stored := filepath.Join(fpath, fname)
+		info, err := os.Stat(stored)
+		if err != nil {
+			apiStorageErrorHandler(w, err)
+			return
+		}
+		hashCache.Put(stored, info, hash)
+
+		f, err := newAPIFile(path.Join(dir, fname), stored, info, false)
+		if err != nil {
+			apiErrorHandler(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listing.Files = append(listing.Files, f)
+	}
+
+	if len(listing.Files) == 0 {
+		apiErrorHandler(w, http.ErrMissingFile.Error(), http.StatusBadRequest)
+		return
+	}
+	apiWriteJSON(w, listing, http.StatusCreated)
+}
+
+func apiMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	rel := cleanDir(r.PathValue("path"))
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], rel)
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+
+	info, err := os.Stat(fpath)
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+
+	f, err := newAPIFile(rel, fpath, info, true)
+	if err != nil {
+		apiErrorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiWriteJSON(w, f, http.StatusOK)
+}
+
+func apiDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	file, info, err := openStorageFile(r.PathValue("path"))
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+	defer file.Close()
+
+	if info.IsDir() {
+		apiErrorHandler(w, "path is a folder.", http.StatusBadRequest)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+func apiDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	rel := cleanDir(r.PathValue("path"))
+	if rel == "" {
+		apiErrorHandler(w, "the storage can not be deleted.", http.StatusBadRequest)
+		return
+	}
+
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], rel)
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+
+	err = fsutil.Remove(fpath)
+	if err != nil {
+		apiStorageErrorHandler(w, err)
+		return
+	}
+	hashCache.Remove(fpath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiOpenAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	io.WriteString(w, openAPIDocument)
+}
+
+func apiNotFoundHandler(w http.ResponseWriter, _ *http.Request) {
+	apiErrorHandler(w, "route not found.", http.StatusNotFound)
+}
+
+func apiMethodNotAllowedHandler(w http.ResponseWriter, _ *http.Request) {
+	apiErrorHandler(w, "method not allowed.", http.StatusMethodNotAllowed)
+}
+
+func apiWriteJSON(w http.ResponseWriter, v any, code int) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// apiErrorHandler is the JSON errorHandler of the API routes.
+func apiErrorHandler(w http.ResponseWriter, error string, code int) {
+	w.Header().Del("Content-Length")
+	apiWriteJSON(w, struct {
+		Error apiError `json:"error"`
+	}{
+		Error: apiError{
+			Code:    code,
+			Status:  http.StatusText(code),
+			Message: error,
+		},
+	}, code)
+}
+
+func apiStorageErrorHandler(w http.ResponseWriter, err error) {
+	code, message := storageError(err)
+	apiErrorHandler(w, message, code)
+}
+
+// openStorageFile opens the file or folder at the relative path.
+func openStorageFile(rel string) (*os.File, os.FileInfo, error) {
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], rel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
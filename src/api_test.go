@@ -0,0 +1,149 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	root := setupStorage(t)
+	os.Mkdir(filepath.Join(root, "photos"), 0700)
+
+	mux := http.NewServeMux()
+	apiRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// decode the response body into v and check the status
+	do := func(t *testing.T, r *http.Request, code int, v any) {
+		t.Helper()
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != code {
+			t.Errorf("\nExpected: %d\nActual: %d", code, res.StatusCode)
+		}
+		if v != nil {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Errorf("\nError: %s", err)
+			}
+		}
+	}
+
+	t.Run("Upload Into Folder", func(t *testing.T) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for _, name := range []string{"test_file", "test_file"} {
+			part, _ := mw.CreateFormFile("file", name)
+			io.WriteString(part, "1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		}
+		mw.Close()
+
+		r, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/files?dir=photos", &buf)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		var actual apiListing
+		do(t, r, http.StatusCreated, &actual)
+
+		expected := []string{"photos/test_file", "photos/test_file(1)"}
+		if len(actual.Files) != 2 ||
+			actual.Files[0].Path != expected[0] || actual.Files[1].Path != expected[1] ||
+			actual.Files[0].Sha256 != "2a3dfe6fbe56133c3254e7c3db3f70e3f706e8e9030ef82d416d77a18c904633" {
+			t.Errorf("\nExpected: %v\nActual: %+v", expected, actual.Files)
+		}
+	})
+
+	t.Run("List Metadata Download And Delete", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/files", nil)
+		var listing apiListing
+		do(t, r, http.StatusOK, &listing)
+		if len(listing.Files) != 1 || listing.Files[0].Type != "dir" {
+			t.Errorf("\nExpected: [photos]\nActual: %+v", listing.Files)
+		}
+
+		// uploads are hashed while written, other files on request
+		os.WriteFile(filepath.Join(root, "photos", "copied_file"), []byte("Fuiyoh!!"), 0644)
+		for _, hash := range []string{"0", "1"} {
+			r, _ = http.NewRequest(http.MethodGet, srv.URL+"/api/v1/files?dir=photos&hash="+hash, nil)
+			do(t, r, http.StatusOK, &listing)
+			for _, f := range listing.Files {
+				if (f.Sha256 == "") != (hash == "0" && f.Name == "copied_file") {
+					t.Errorf("\nTest Data: (hash=%s)\nExpected: checksum of %s %t\nActual: %q", hash, f.Name, f.Name != "copied_file" || hash == "1", f.Sha256)
+				}
+			}
+		}
+		os.Remove(filepath.Join(root, "photos", "copied_file"))
+
+		r, _ = http.NewRequest(http.MethodGet, srv.URL+"/api/v1/files/photos/test_file%281%29", nil)
+		var file apiFile
+		do(t, r, http.StatusOK, &file)
+		if file.Size != 36 || file.Sha256 == "" || file.Download != "/api/v1/download/photos/test_file%281%29" {
+			t.Errorf("\nExpected: 36 bytes\nActual: %+v", file)
+		}
+
+		res, err := http.Get(srv.URL + file.Download)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		inbyte, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(inbyte) != "1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+			t.Errorf("\nExpected: file content\nActual: %s", inbyte)
+		}
+
+		r, _ = http.NewRequest(http.MethodDelete, srv.URL+"/api/v1/files/photos/test_file%281%29", nil)
+		do(t, r, http.StatusNoContent, nil)
+		if _, err := os.Stat(filepath.Join(root, "photos", "test_file(1)")); err == nil {
+			t.Errorf("\nExpected file to be deleted, but found it.")
+		}
+	})
+
+	t.Run("JSON Error Bodies", func(t *testing.T) {
+		tcs := []struct {
+			method   string
+			target   string
+			expected int
+		}{
+			{http.MethodGet, "/api/v1/files/missing_file", http.StatusNotFound},
+			{http.MethodGet, "/api/v1/files?dir=..", http.StatusBadRequest},
+			{http.MethodDelete, "/api/v1/files/", http.StatusBadRequest},
+			{http.MethodPut, "/api/v1/files", http.StatusMethodNotAllowed},
+			{http.MethodGet, "/api/v1/unknown", http.StatusNotFound},
+		}
+		for _, tc := range tcs {
+			r, _ := http.NewRequest(tc.method, srv.URL+tc.target, nil)
+			var actual struct {
+				Error apiError `json:"error"`
+			}
+			do(t, r, tc.expected, &actual)
+			if actual.Error.Code != tc.expected || actual.Error.Message == "" {
+				t.Errorf("\nTest Data: (%s %s)\nExpected: %d\nActual: %+v",
+					tc.method, tc.target, tc.expected, actual)
+			}
+		}
+	})
+
+	t.Run("OpenAPI Document", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/openapi.json", nil)
+		var actual map[string]any
+		do(t, r, http.StatusOK, &actual)
+		if actual["openapi"] != "3.0.3" {
+			t.Errorf("\nExpected: 3.0.3\nActual: %v", actual["openapi"])
+		}
+	})
+}
@@ -4,6 +4,13 @@
 
 package main
 
+import (
+	"localfs/s3"
+	"localfs/util/netutil"
+	"slices"
+	"time"
+)
+
 const (
 	appBuild string = "0.1.1"
 )
@@ -12,9 +19,101 @@ const (
 	defaultHost    string = "0.0.0.0"
 	defaultPort    string = "5000"
 	defaultStorage string = ".localfs"
+	// announced by mDNS, reachable at <name>.local
+	defaultName string = "localfs"
+	// self-signed certificate, relative to the user config directory
+	defaultCertFile string = "localfs/tls/cert.pem"
+	defaultKeyFile  string = "localfs/tls/key.pem"
+	// SFTP server, the host key is relative to the user config directory
+	defaultSFTPPort    string = "2022"
+	defaultHostKeyFile string = "localfs/ssh/host_ed25519_key"
+)
+
+// passwordEnv sets the '--password' flag, which keeps it out of the process list
+const passwordEnv string = "LOCALFS_PASSWORD"
+
+const (
+	defaultSessionTTL      time.Duration = time.Hour
+	defaultSessionCapacity int           = 1024
 )
 
+// the one-shot subcommands exit after the count of transfers, or on timeout
+const (
+	defaultCount   int           = 1
+	defaultTimeout time.Duration = 10 * time.Minute
+	// time given to the requests in progress once the transfers are done
+	shutdownGrace time.Duration = 5 * time.Second
+)
+
+// config holds the command-line options.
+type config struct {
+	port            string
+	storage         string
+	readOnly        bool
+	serve           bool
+	sessionTTL      time.Duration
+	sessionCapacity int
+	auth            bool
+	password        string
+	roles           bool
+	tls             bool
+	tlsCert         string
+	tlsKey          string
+	name            string
+	mdns            bool
+	// addresses to listen on, and the interface name patterns
+	// of the QR codes and mDNS
+	hosts             []string
+	interfaces        []string
+	excludeInterfaces []string
+	// access keys of the S3-compatible API
+	s3Keys []s3.Key
+	// SFTP server and the authorized_keys file of its admin clients
+	sftp               bool
+	sftpPort           string
+	sftpAuthorizedKeys string
+	// one-shot subcommands, the files sent and the downloads or
+	// files received before exiting
+	send    bool
+	receive bool
+	files   []string
+	count   int
+	timeout time.Duration
+}
+
 const ckey_storage = "storage"
 const ckey_port = "port"
+const ckey_readonly = "readonly"
+const ckey_tls = "tls"
+const ckey_fingerprint = "fingerprint"
+const ckey_hostname = "hostname"
 
 var appCache = map[string]string{}
+
+// netFilter selects the network interfaces of the QR codes and mDNS.
+var netFilter = netutil.DefaultFilter
+
+// boundHosts are the addresses the server listens on,
+// none when it listens on every address.
+var boundHosts []string
+
+// filter returns the filter of the network interfaces of the options.
+func (cfg *config) filter() netutil.Filter {
+	return netutil.Filter{
+		Include: cfg.interfaces,
+		Exclude: slices.Concat(netutil.DefaultExclude, cfg.excludeInterfaces),
+	}
+}
+
+// isReadOnly reports whether the storage is shared read-only.
+func isReadOnly() bool {
+	return appCache[ckey_readonly] == "true"
+}
+
+// scheme returns the URL scheme the server is listening on.
+func scheme() string {
+	if appCache[ckey_tls] == "true" {
+		return "https"
+	}
+	return "http"
+}
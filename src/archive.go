@@ -0,0 +1,310 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"localfs/util/fsutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveManifest is the name of the checksums file appended to every
+// archive, in the format of sha256sum, so "sha256sum -c" verifies it.
+const archiveManifest = "SHA256SUMS"
+
+// archiveEntry is a file or folder of the archive.
+type archiveEntry struct {
+	// absolute path, empty for the manifest
+	path string
+	// slash-separated name inside the archive, folders end with a slash
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (e archiveEntry) isDir() bool {
+	return strings.HasSuffix(e.name, "/")
+}
+
+// archiveWriter writes the entries of an archive format.
+type archiveWriter interface {
+	// create writes the header of the entry and returns the writer
+	// of its content, folders have no content.
+	create(e archiveEntry) (io.Writer, error)
+	Close() error
+}
+
+// zipArchive stores the files uncompressed, as photos and videos do not
+// compress anyway, zip64 is used for entries and archives above 4 GiB.
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (a zipArchive) create(e archiveEntry) (io.Writer, error) {
+	fh := &zip.FileHeader{
+		Name:     e.name,
+		Method:   zip.Store,
+		Modified: e.modTime,
+	}
+	fh.SetMode(e.mode)
+	return a.CreateHeader(fh)
+}
+
+type tarGzArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (a tarGzArchive) create(e archiveEntry) (io.Writer, error) {
+	hdr := &tar.Header{
+		Name:     e.name,
+		Mode:     int64(e.mode.Perm()),
+		Size:     e.size,
+		ModTime:  e.modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if e.isDir() {
+		hdr.Size = 0
+		hdr.Typeflag = tar.TypeDir
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+func (a tarGzArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// archiveHandler streams the selected "name" files and folders of the
+// folder as a zip or tar.gz archive, the whole folder when none is
+// selected. Files are read from the storage while the archive is
+// written, no temporary file is staged.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dir := cleanDir(q.Get("dir"))
+	fpath, err := storageDir(dir)
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar.gz" {
+		errorHandler(w, "unsupported archive format, use 'zip' or 'tar.gz'.", http.StatusBadRequest)
+		return
+	}
+
+	// collect the entries first, so missing files are reported
+	// before the response is committed
+	entries, err := archiveEntries(fpath, q["name"])
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	h := w.Header()
+	var aw archiveWriter
+	if format == "zip" {
+		h.Set("Content-Type", "application/zip")
+		aw = zipArchive{zip.NewWriter(w)}
+	} else {
+		h.Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		aw = tarGzArchive{tw: tar.NewWriter(gz), gz: gz}
+	}
+	filename := archiveName(dir, q["name"]) + "." + format
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	h.Set("Cache-Control", "no-store")
+
+	if err = writeArchive(aw, entries); err != nil {
+		// the status is already sent, abort the response
+		// so the client does not keep a truncated archive
+		log.Printf("ERROR archive '%s': %s\n", filename, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// archiveEntries returns the entries of the names inside the folder,
+// folders are walked recursively. Hidden temporary files and symbolic
+// links inside the folders are skipped.
+func archiveEntries(fpath string, names []string) ([]archiveEntry, error) {
+	if len(names) == 0 {
+		listing, err := fsutil.Listing(fpath)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range listing {
+			names = append(names, e.Name)
+		}
+	}
+
+	entries := []archiveEntry{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = cleanDir(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		src, err := fsutil.SecureJoin(fpath, name)
+		if err != nil {
+			return nil, err
+		}
+		// the selected name may link within the storage,
+		// which is walked as its target
+		info, err := os.Lstat(src)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if src, err = filepath.EvalSymlinks(src); err != nil {
+				return nil, err
+			}
+		}
+
+		err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), fsutil.TempFilePrefix) && p != src {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			e := archiveEntry{
+				path:    p,
+				name:    path.Join(name, filepath.ToSlash(rel)),
+				size:    info.Size(),
+				mode:    info.Mode(),
+				modTime: info.ModTime(),
+			}
+			if d.IsDir() {
+				e.name += "/"
+			}
+			entries = append(entries, e)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// writeArchive writes the entries and the checksums manifest, which is
+// computed while the files are written.
+func writeArchive(aw archiveWriter, entries []archiveEntry) error {
+	sums := &bytes.Buffer{}
+	taken := map[string]bool{}
+	for _, e := range entries {
+		taken[e.name] = true
+		dst, err := aw.create(e)
+		if err != nil {
+			return err
+		}
+		if e.isDir() {
+			continue
+		}
+
+		hash, err := archiveFile(dst, e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sums, "%s  %s\n", hash, e.name)
+	}
+
+	manifest := archiveEntry{
+		name:    archiveManifest,
+		size:    int64(sums.Len()),
+		mode:    fs.FileMode(0644),
+		modTime: time.Now(),
+	}
+	for next := 1; taken[manifest.name]; next++ {
+		manifest.name = fmt.Sprintf("%s(%d)", archiveManifest, next)
+	}
+	dst, err := aw.create(manifest)
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(sums.Bytes()); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// archiveFile copies the file into the archive and returns its SHA-256,
+// exactly the size of the header is copied, a file shrinking meanwhile
+// fails the archive.
+func archiveFile(dst io.Writer, e archiveEntry) (string, error) {
+	file, err := os.Open(e.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.CopyN(io.MultiWriter(dst, h), file, e.size); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	// cache the checksum unless the file changed while read
+	if info, err := file.Stat(); err == nil && info.Size() == e.size && info.ModTime().Equal(e.modTime) {
+		hashCache.Put(e.path, info, hash)
+	}
+	return hash, nil
+}
+
+// archiveName returns the download name of the archive without extension,
+// the single selected name or else the folder name.
+func archiveName(dir string, names []string) string {
+	if len(names) == 1 {
+		if name := path.Base(cleanDir(names[0])); name != "." && name != "" {
+			return name
+		}
+	}
+	if dir != "" {
+		return path.Base(dir)
+	}
+	return "localfs"
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	root := setupStorage(t)
+	files := map[string]string{
+		"a.txt":              "Fuiyoh!!",
+		"photos/b.jpg":       "Haiyaa!!",
+		"photos/2024/c.jpg":  "Aiyaa!!",
+		"photos/.localfs-x1": "in progress",
+	}
+	for name, content := range files {
+		fpath := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+	}
+
+	sha256sum := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	// readZip and readTarGz return the file contents of the archive
+	readZip := func(body []byte) map[string]string {
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		contents := map[string]string{}
+		for _, f := range zr.File {
+			if f.Method != zip.Store {
+				t.Errorf("\nTest Data: (%s)\nExpected: store method\nActual: %d", f.Name, f.Method)
+			}
+			rc, _ := f.Open()
+			byt, _ := io.ReadAll(rc)
+			rc.Close()
+			contents[f.Name] = string(byt)
+		}
+		return contents
+	}
+	readTarGz := func(body []byte) map[string]string {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		tr := tar.NewReader(gz)
+		contents := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("\nError: %s", err)
+				t.FailNow()
+			}
+			byt, _ := io.ReadAll(tr)
+			contents[hdr.Name] = string(byt)
+		}
+		return contents
+	}
+
+	// initialize testcases
+	tcs := []struct {
+		title    string
+		query    string
+		read     func([]byte) map[string]string
+		filename string
+		expected []string
+	}{
+		{"Selected Files And Folders As Zip", "?name=a.txt&name=photos", readZip, "localfs.zip",
+			[]string{"a.txt", "photos/", "photos/2024/", "photos/2024/c.jpg", "photos/b.jpg"}},
+		{"Whole Folder As Tar Gz", "?dir=photos&format=tar.gz", readTarGz, "photos.tar.gz",
+			[]string{"2024/", "2024/c.jpg", "b.jpg"}},
+		{"Single Folder Is Named After It", "?dir=photos&name=2024", readZip, "2024.zip",
+			[]string{"2024/", "2024/c.jpg"}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.title, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			archiveHandler(w, httptest.NewRequest(http.MethodGet, "/archive"+tc.query, nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("\nExpected: %d\nActual: %d %s", http.StatusOK, w.Code, w.Body.String())
+				t.FailNow()
+			}
+			if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, tc.filename) {
+				t.Errorf("\nExpected: %s\nActual: %s", tc.filename, disposition)
+			}
+
+			contents := tc.read(w.Body.Bytes())
+			manifest, ok := contents[archiveManifest]
+			if !ok {
+				t.Errorf("\nExpected: %s in the archive", archiveManifest)
+				t.FailNow()
+			}
+			delete(contents, archiveManifest)
+
+			names := []string{}
+			for name, content := range contents {
+				names = append(names, name)
+				if strings.HasSuffix(name, "/") {
+					continue
+				}
+				// every file is listed with its checksum
+				line := sha256sum(content) + "  " + name + "\n"
+				if !strings.Contains(manifest, line) {
+					t.Errorf("\nTest Data: (%s)\nExpected: %q\nActual: %q", name, line, manifest)
+				}
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("\nExpected: %v\nActual: %v", tc.expected, names)
+			}
+		})
+	}
+
+	t.Run("Invalid Requests Are Rejected", func(t *testing.T) {
+		for query, expected := range map[string]int{
+			"?name=missing.txt":        http.StatusNotFound,
+			"?name=../a.txt":           http.StatusBadRequest,
+			"?name=photos/.localfs-x1": http.StatusBadRequest,
+			"?dir=missing":             http.StatusNotFound,
+			"?name=a.txt&format=rar":   http.StatusBadRequest,
+		} {
+			w := httptest.NewRecorder()
+			archiveHandler(w, httptest.NewRequest(http.MethodGet, "/archive"+query, nil))
+			if w.Code != expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", query, expected, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,410 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"localfs/view"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie   string        = "localfs_session"
+	sessionLifetime time.Duration = 24 * time.Hour
+	pinQueryParam   string        = "pin"
+	pinDigits       int           = 6
+)
+
+// login attempts allowed per client and window before its login is
+// rejected, which limits guessing the PIN, and of all the clients
+// together, which limits guessing it from many addresses
+const (
+	loginAttempts       int           = 10
+	loginGlobalAttempts int           = 60
+	loginWindow         time.Duration = time.Minute
+)
+
+// authenticator exchanges the pairing secret, a random PIN or a fixed
+// password, for a signed session cookie of the role of the secret.
+type authenticator struct {
+	// secret of the admin role
+	secret   string
+	password bool
+	// PINs of the uploader and reader roles, set with '--roles'
+	roles map[role]string
+	key   []byte
+
+	mu sync.Mutex
+	// failed attempts by the key of the client address
+	failures map[string][]failure
+}
+
+// failure is a failed login attempt, the secret is kept signed.
+type failure struct {
+	at     time.Time
+	secret string
+}
+
+// authn is nil when authentication is disabled,
+// it is initialized by server.initAuth.
+var authn *authenticator
+
+// newAuthenticator returns an authenticator of the password, or of a
+// random PIN when the password is empty. The uploader and reader roles
+// get a PIN each when roles are enabled.
+func newAuthenticator(password string, withRoles bool) (*authenticator, error) {
+	a := &authenticator{secret: password, password: password != "", failures: map[string][]failure{}}
+	if !a.password {
+		pin, err := a.newPIN()
+		if err != nil {
+			return nil, err
+		}
+		a.secret = pin
+	}
+	if withRoles {
+		a.roles = map[role]string{}
+		for _, ro := range []role{roleUploader, roleReader} {
+			pin, err := a.newPIN()
+			if err != nil {
+				return nil, err
+			}
+			a.roles[ro] = pin
+		}
+	}
+
+	// sessions are signed with a key of the server run
+	a.key = make([]byte, 32)
+	if _, err := rand.Read(a.key); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// newPIN returns a random PIN distinct from the secrets of the roles.
+func (a *authenticator) newPIN() (string, error) {
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			return "", err
+		}
+		pin := fmt.Sprintf("%0*d", pinDigits, n.Int64())
+		if _, taken := a.roleOf(pin); !taken {
+			return pin, nil
+		}
+	}
+}
+
+// secretOf returns the secret of the role, empty when
+// the role is not enabled.
+func (a *authenticator) secretOf(ro role) string {
+	if ro == roleAdmin {
+		return a.secret
+	}
+	return a.roles[ro]
+}
+
+// roleOf returns the role of the secret, compared in constant time.
+func (a *authenticator) roleOf(secret string) (role, bool) {
+	match := roleAdmin
+	found := 0
+	for _, ro := range roles {
+		s := a.secretOf(ro)
+		if s != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s)) == 1 {
+			match, found = ro, 1
+		}
+	}
+	return match, found == 1
+}
+
+// verify returns the role of the secret sent from the address, failed
+// attempts are counted per client and its verification is refused once
+// the attempts are exceeded, or once the failed attempts of all the
+// clients exceed the global budget. A wrong secret sent again, e.g. a stale
+// bearer token or a file manager retrying its password, is counted once,
+// so a client only locks itself out by guessing.
+func (a *authenticator) verify(addr, secret string) (ro role, ok bool, limited bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	total := 0
+	for key, failures := range a.failures {
+		recent := failures[:0]
+		for _, f := range failures {
+			if now.Sub(f.at) < loginWindow {
+				recent = append(recent, f)
+			}
+		}
+		if len(recent) == 0 {
+			delete(a.failures, key)
+			continue
+		}
+		a.failures[key] = recent
+		total += len(recent)
+	}
+	key := attemptKey(addr)
+	if len(a.failures[key]) >= loginAttempts || total >= loginGlobalAttempts {
+		return "", false, true
+	}
+
+	if ro, ok := a.roleOf(secret); ok {
+		return ro, true, false
+	}
+	signed := a.sign(secret)
+	for _, f := range a.failures[key] {
+		if f.secret == signed {
+			return "", false, false
+		}
+	}
+	a.failures[key] = append(a.failures[key], failure{at: now, secret: signed})
+	return "", false, false
+}
+
+// attemptKey returns the key of the login attempts of the address,
+// IPv6 clients are keyed by their /64 prefix, which a single host may
+// take many addresses of.
+func attemptKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return ip.String()
+}
+
+func (a *authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSession returns the signed session cookie value of the role,
+// "<role>:<expiry>.<mac>".
+func (a *authenticator) newSession(ro role) (string, time.Time) {
+	expires := time.Now().Add(sessionLifetime)
+	payload := string(ro) + ":" + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + a.sign(payload), expires
+}
+
+// validSession returns the role of the request when it carries an
+// unexpired session cookie signed by the server, or a secret as bearer
+// token.
+func (a *authenticator) validSession(r *http.Request) (role, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		ro, ok, _ := a.verify(r.RemoteAddr, token)
+		return ro, ok
+	}
+	// WebDAV clients send the secret as password, the user name is ignored
+	if _, password, ok := r.BasicAuth(); ok {
+		ro, ok, _ := a.verify(r.RemoteAddr, password)
+		return ro, ok
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	payload, mac, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(a.sign(payload))) {
+		return "", false
+	}
+	name, expiry, _ := strings.Cut(payload, ":")
+	ro, ok := parseRole(name)
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || time.Now().Unix() >= unix {
+		return "", false
+	}
+	return ro, true
+}
+
+func (a *authenticator) setSession(w http.ResponseWriter, r *http.Request, ro role) {
+	value, expires := a.newSession(ro)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// pairingURL returns the URL with the pairing secret of the role.
+func (a *authenticator) pairingURL(u url.URL, ro role) url.URL {
+	q := u.Query()
+	q.Set(pinQueryParam, a.secretOf(ro))
+	u.RawQuery = q.Encode()
+	return u
+}
+
+// authHandler requires a session for every route except the index and
+// login pages. A request with the pairing secret in the query, as encoded
+// in the QR code, is exchanged for a session cookie.
+func authHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authn == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/login":
+			loginPageHandler(w, r)
+			return
+		case "/logout":
+			logoutHandler(w, r)
+			return
+		case "/favicon.ico":
+			h.ServeHTTP(w, r)
+			return
+		case "/":
+			// the index page shows the pairing secret,
+			// only the host running the server may see it
+			if isLoopback(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if _, ok := authn.validSession(r); ok {
+				http.Redirect(w, r, "/upload", http.StatusSeeOther)
+				return
+			}
+		}
+
+		// share links carry their own token, and S3 requests their signature
+		if strings.HasPrefix(r.URL.Path, sharePrefix) ||
+			r.URL.Path+"/" == s3Prefix || strings.HasPrefix(r.URL.Path, s3Prefix) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// exchange the pairing secret and remove it from the URL
+		if secret := r.URL.Query().Get(pinQueryParam); secret != "" {
+			ro, ok, limited := authn.verify(r.RemoteAddr, secret)
+			if ok {
+				authn.setSession(w, r, ro)
+				u := *r.URL
+				q := u.Query()
+				q.Del(pinQueryParam)
+				u.RawQuery = q.Encode()
+				http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			if limited {
+				unauthorizedHandler(w, r, "too many attempts, try again later.", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if ro, ok := authn.validSession(r); ok {
+			h.ServeHTTP(w, withRole(r, ro))
+			return
+		}
+		unauthorizedHandler(w, r, "authentication required.", http.StatusUnauthorized)
+	})
+}
+
+// unauthorizedHandler replies in the format of the route, HTML pages
+// are redirected to the login page.
+func unauthorizedHandler(w http.ResponseWriter, r *http.Request, message string, code int) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		apiErrorHandler(w, message, code)
+	case strings.HasPrefix(r.URL.Path, "/files/"), strings.HasPrefix(r.URL.Path, tusPrefix):
+		http.Error(w, message, code)
+	case r.URL.Path+"/" == davPrefix, strings.HasPrefix(r.URL.Path, davPrefix):
+		// prompt for the PIN or password
+		if code == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="localFS", charset="UTF-8"`)
+		}
+		http.Error(w, message, code)
+	case code == http.StatusUnauthorized:
+		next := url.Values{"next": {r.URL.RequestURI()}}
+		http.Redirect(w, r, "/login?"+next.Encode(), http.StatusSeeOther)
+	default:
+		errorHandler(w, message, code)
+	}
+}
+
+func loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	// only redirect within the server
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/upload"
+	}
+
+	message := ""
+	code := http.StatusOK
+	if r.Method == http.MethodPost {
+		ro, ok, limited := authn.verify(r.RemoteAddr, r.PostFormValue("secret"))
+		if ok {
+			authn.setSession(w, r, ro)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		message = "incorrect PIN or password."
+		code = http.StatusUnauthorized
+		if limited {
+			message = "too many attempts, try again later."
+			code = http.StatusTooManyRequests
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+
+	t, err := template.New("loginPage").Parse(view.LoginPageTmpl)
+	if err != nil {
+		errorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(code)
+	t.Execute(w, view.LoginPageViewModel{
+		Password: authn.password,
+		Message:  message,
+		Next:     next,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// isLoopback reports whether the request comes from the host itself.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
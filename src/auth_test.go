@@ -0,0 +1,183 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setupAuth enables authentication with the password, or a random PIN
+// when the password is empty, and the PINs of the roles.
+func setupAuth(t *testing.T, password string) {
+	a, err := newAuthenticator(password, true)
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	authn = a
+	t.Cleanup(func() { authn = nil })
+}
+
+func TestAuth(t *testing.T) {
+	setupStorage(t)
+	setupAuth(t, "")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", routesHandler)
+	mux.HandleFunc("/upload", uploadPageHandler)
+	mux.Handle("/download/", fileHandler("/download/"))
+	apiRoutes(mux)
+	h := authHandler(mux)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// initialize testcases
+	tcs := []struct {
+		target   string
+		expected int
+		location string
+	}{
+		{"/upload", http.StatusSeeOther, "/login?next=%2Fupload"},
+		{"/download/test_file", http.StatusSeeOther, "/login?next=%2Fdownload%2Ftest_file"},
+		{"/api/v1/files", http.StatusUnauthorized, ""},
+		{"/", http.StatusSeeOther, "/login?next=%2F"},
+		{"/login", http.StatusOK, ""},
+	}
+
+	t.Run("Unauthenticated Requests Are Rejected", func(t *testing.T) {
+		for _, tc := range tcs {
+			w := serve(httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if w.Code != tc.expected || w.Header().Get("Location") != tc.location {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d %s\nActual: %d %s",
+					tc.target, tc.expected, tc.location, w.Code, w.Header().Get("Location"))
+			}
+		}
+	})
+
+	t.Run("Index Page Is Served To The Host Only", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "127.0.0.1:50000"
+		w := serve(r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), authn.secret) {
+			t.Errorf("\nExpected: %d with the PIN\nActual: %d", http.StatusOK, w.Code)
+		}
+	})
+
+	var cookie *http.Cookie
+	t.Run("Pairing PIN Is Exchanged For A Session", func(t *testing.T) {
+		w := serve(httptest.NewRequest(http.MethodGet, "/upload?dir=a&pin="+authn.secret, nil))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/upload?dir=a" {
+			t.Errorf("\nExpected: %d /upload?dir=a\nActual: %d %s",
+				http.StatusSeeOther, w.Code, w.Header().Get("Location"))
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookie {
+				cookie = c
+			}
+		}
+		if cookie == nil || !cookie.HttpOnly {
+			t.Errorf("\nExpected an HttpOnly session cookie, but got %v.", cookie)
+			t.FailNow()
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/upload", nil)
+		r.AddCookie(cookie)
+		if w := serve(r); w.Code != http.StatusOK {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("Forged Session Is Rejected", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/upload", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "99999999999.forged"})
+		if w := serve(r); w.Code != http.StatusSeeOther {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusSeeOther, w.Code)
+		}
+	})
+
+	t.Run("Bearer Token Is Accepted", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/files", nil)
+		r.Header.Set("Authorization", "Bearer "+authn.secret)
+		if w := serve(r); w.Code != http.StatusOK {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("Login Redirects Within The Server", func(t *testing.T) {
+		for _, next := range []string{"/upload?dir=a", "https://example.com", "//example.com"} {
+			form := url.Values{"secret": {authn.secret}, "next": {next}}
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := serve(r)
+
+			expected := next
+			if !strings.HasPrefix(next, "/upload") {
+				expected = "/upload"
+			}
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d %s\nActual: %d %s",
+					next, http.StatusSeeOther, expected, w.Code, w.Header().Get("Location"))
+			}
+		}
+	})
+
+	t.Run("Login Attempts Are Limited Per Client", func(t *testing.T) {
+		login := func(addr string) int {
+			form := url.Values{"secret": {authn.secret}}
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.RemoteAddr = addr
+			return serve(r).Code
+		}
+
+		// a stale secret sent again is not another guess
+		for range loginAttempts {
+			authn.verify("192.0.2.1:50000", "stale")
+		}
+		if code := login("192.0.2.1:50001"); code != http.StatusSeeOther {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusSeeOther, code)
+		}
+
+		for i := range loginAttempts {
+			authn.verify("[2001:db8::1]:50000", fmt.Sprintf("wrong_%d", i))
+		}
+		tcs := []struct {
+			addr string
+			code int
+		}{
+			{"[2001:db8::1]:50001", http.StatusTooManyRequests},
+			{"[2001:db8::2]:50000", http.StatusTooManyRequests},
+			{"[2001:db8:0:1::1]:50000", http.StatusSeeOther},
+			{"192.0.2.1:50000", http.StatusSeeOther},
+		}
+		for _, tc := range tcs {
+			if code := login(tc.addr); code != tc.code {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", tc.addr, tc.code, code)
+			}
+		}
+	})
+
+	t.Run("Login Attempts Are Limited Across Clients", func(t *testing.T) {
+		authn.failures = map[string][]failure{}
+		// a guess from each of many addresses
+		for i := range loginGlobalAttempts {
+			if _, _, limited := authn.verify(fmt.Sprintf("10.0.%d.%d:50000", i/256, i%256), "wrong"); limited {
+				t.Errorf("\nTest Data: (%d)\nExpected: not limited\nActual: limited", i)
+			}
+		}
+		if _, ok, limited := authn.verify("198.51.100.1:50000", authn.secret); ok || !limited {
+			t.Errorf("\nExpected: limited\nActual: ok=%t limited=%t", ok, limited)
+		}
+	})
+}
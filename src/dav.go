@@ -0,0 +1,232 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"localfs/util/fsutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is the mount point of the storage over WebDAV, e.g.
+//
+//	rclone copy photos :webdav:photos --webdav-url http://localhost:5000/dav/
+const davPrefix = "/dav/"
+
+// davPermissions are the permissions of the WebDAV methods, methods not
+// listed change the storage and require the admin role.
+var davPermissions = map[string]permission{
+	http.MethodGet:    permRead,
+	http.MethodHead:   permRead,
+	"PROPFIND":        permRead,
+	http.MethodPut:    permUpload,
+	"MKCOL":           permUpload,
+	"LOCK":            permUpload,
+	"UNLOCK":          permUpload,
+	"COPY":            permUpload,
+	"MOVE":            permModify,
+	"PROPPATCH":       permModify,
+	http.MethodDelete: permModify,
+}
+
+func davRoutes(mux *http.ServeMux) {
+	h := davHandler(&webdav.Handler{
+		Prefix:     strings.TrimSuffix(davPrefix, "/"),
+		FileSystem: davFS{},
+		LockSystem: webdav.NewMemLS(),
+	})
+	mux.HandleFunc(strings.TrimSuffix(davPrefix, "/"), h)
+	mux.HandleFunc(davPrefix, h)
+}
+
+// davHandler applies the permissions of the routes to the WebDAV methods,
+// as on the upload page, replacing a file requires the admin role.
+func davHandler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// clients discover the supported methods and the DAV class
+		if r.Method == http.MethodOptions {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		p, ok := davPermissions[r.Method]
+		if !ok {
+			p = permModify
+		}
+		switch r.Method {
+		case http.MethodPut:
+			name := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(davPrefix, "/"))
+			if _, err := (davFS{}).Stat(r.Context(), name); err == nil {
+				if r.Header.Get("If-None-Match") == "*" {
+					http.Error(w, "file or folder already exists.", http.StatusPreconditionFailed)
+					return
+				}
+				p = permModify
+			}
+			// an interrupted upload leaves the file unchanged
+			body := &davBody{ReadCloser: r.Body}
+			r.Body = body
+			r = r.WithContext(context.WithValue(r.Context(), davBodyKey{}, body))
+		case "COPY":
+			// the destination is overwritten unless told otherwise
+			if r.Header.Get("Overwrite") != "F" {
+				p = permModify
+			}
+		}
+
+		next := h.ServeHTTP
+		if p != permRead {
+			next = writable(next, http.Error)
+		}
+		allow(p, next, http.Error)(w, r)
+	}
+}
+
+type davBodyKey struct{}
+
+// davBody records the read error of an upload, e.g. when the client
+// disconnects, so the partial file is discarded.
+type davBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// davFS is the storage as a WebDAV file system. As the other routes,
+// names resolve inside the storage only, and hidden temporary files
+// are neither listed nor reachable.
+type davFS struct{}
+
+func (davFS) path(name string) (string, error) {
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], name)
+	if errors.Is(err, fsutil.ErrInvalidPath) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return fpath, err
+}
+
+// writablePath returns the path of the name, the storage itself
+// may not be removed or renamed.
+func (d davFS) writablePath(name string) (string, error) {
+	fpath, err := d.path(name)
+	if err == nil && fpath == appCache[ckey_storage] {
+		return "", &fs.PathError{Op: "write", Path: name, Err: fs.ErrPermission}
+	}
+	return fpath, err
+}
+
+func (d davFS) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	fpath, err := d.writablePath(name)
+	if err != nil {
+		return err
+	}
+	return fsutil.CreateDir(filepath.Dir(fpath), filepath.Base(fpath))
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fpath, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// uploads replace the file once complete, a role which may not
+	// replace files keeps a file created while uploading
+	if flag&os.O_TRUNC != 0 {
+		if info, err := os.Stat(fpath); err == nil && info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+		f, err := fsutil.CreateAtomic(filepath.Dir(fpath), filepath.Base(fpath))
+		if err != nil {
+			return nil, err
+		}
+		body, _ := ctx.Value(davBodyKey{}).(*davBody)
+		return &davUpload{AtomicFile: f, body: body, replace: contextRole(ctx).can(permModify)}, nil
+	}
+
+	f, err := os.OpenFile(fpath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return davFile{f}, nil
+}
+
+func (d davFS) RemoveAll(_ context.Context, name string) error {
+	fpath, err := d.writablePath(name)
+	if err != nil {
+		return err
+	}
+	return fsutil.Remove(fpath)
+}
+
+func (d davFS) Rename(_ context.Context, oldName, newName string) error {
+	src, err := d.writablePath(oldName)
+	if err != nil {
+		return err
+	}
+	dst, err := d.writablePath(newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+func (d davFS) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	fpath, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fpath)
+}
+
+// davFile hides the temporary files from the folder listing.
+type davFile struct {
+	*os.File
+}
+
+func (f davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), fsutil.TempFilePrefix) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// davUpload is the file of an upload, it is stored on Close unless
+// reading the request body failed, and replaces the stored file only
+// with replace.
+type davUpload struct {
+	*fsutil.AtomicFile
+	body    *davBody
+	replace bool
+}
+
+func (f *davUpload) Close() error {
+	if f.body != nil && f.body.err != nil {
+		f.Abort()
+		return f.body.err
+	}
+	if !f.replace {
+		return f.CommitNoReplace()
+	}
+	return f.Commit()
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"localfs/util/fsutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWebDAV(t *testing.T) {
+	root := setupStorage(t)
+	setupAuth(t, "")
+	os.Mkdir(filepath.Join(root, "photos"), 0755)
+	if err := os.WriteFile(filepath.Join(root, "photos", "test_file"), []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	os.WriteFile(filepath.Join(root, "photos", fsutil.TempFilePrefix+"hidden.part"), []byte("Haiyaa!!"), 0644)
+
+	mux := http.NewServeMux()
+	davRoutes(mux)
+	h := authHandler(mux)
+
+	// serve sends the request with the PIN of the role as
+	// password, as file managers do
+	serve := func(ro role, method, target string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, body)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		r.SetBasicAuth("localfs", authn.secretOf(ro))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Password Is Prompted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/dav/", nil))
+		if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+			t.Errorf("\nExpected: %d Basic\nActual: %d %s", http.StatusUnauthorized, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("Class 1 And 2", func(t *testing.T) {
+		w := serve(roleAdmin, http.MethodOptions, "/dav/", nil, nil)
+		if dav := w.Header().Get("DAV"); dav != "1, 2" {
+			t.Errorf("\nExpected: %s\nActual: %s", "1, 2", dav)
+		}
+	})
+
+	t.Run("Listing Hides Temporary Files", func(t *testing.T) {
+		w := serve(roleReader, "PROPFIND", "/dav/photos/", nil, http.Header{"Depth": {"1"}})
+		body := w.Body.String()
+		if w.Code != http.StatusMultiStatus || !strings.Contains(body, "/dav/photos/test_file") {
+			t.Errorf("\nExpected: %d test_file\nActual: %d %s", http.StatusMultiStatus, w.Code, body)
+		}
+		if strings.Contains(body, fsutil.TempFilePrefix) {
+			t.Errorf("\nExpected: hidden %s\nActual: %s", fsutil.TempFilePrefix, body)
+		}
+		if w := serve(roleAdmin, http.MethodGet, "/dav/photos/"+fsutil.TempFilePrefix+"hidden.part", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Upload And Download", func(t *testing.T) {
+		w := serve(roleUploader, http.MethodPut, "/dav/photos/upload_file", strings.NewReader("Haiyaa!!"), nil)
+		if w.Code != http.StatusCreated {
+			t.Errorf("\nExpected: %d\nActual: %d %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		w = serve(roleReader, http.MethodGet, "/dav/photos/upload_file", nil, nil)
+		if w.Code != http.StatusOK || w.Body.String() != "Haiyaa!!" {
+			t.Errorf("\nExpected: %d %s\nActual: %d %s", http.StatusOK, "Haiyaa!!", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Interrupted Upload Is Discarded", func(t *testing.T) {
+		body := io.MultiReader(strings.NewReader("Haiy"), iotest.ErrReader(errors.New("connection reset")))
+		if w := serve(roleAdmin, http.MethodPut, "/dav/photos/test_file", body, nil); w.Code == http.StatusCreated {
+			t.Errorf("\nExpected: failure\nActual: %d", w.Code)
+		}
+		if b, _ := os.ReadFile(filepath.Join(root, "photos", "test_file")); string(b) != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!", b)
+		}
+		entries, _ := os.ReadDir(filepath.Join(root, "photos"))
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".part") && entry.Name() != fsutil.TempFilePrefix+"hidden.part" {
+				t.Errorf("\nExpected: removed\nActual: %s", entry.Name())
+			}
+		}
+	})
+
+	t.Run("Upload Keeps Files Created Meanwhile", func(t *testing.T) {
+		ctx := withRole(httptest.NewRequest(http.MethodPut, "/", nil), roleUploader).Context()
+		f, err := davFS{}.OpenFile(ctx, "/photos/racing_file", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		f.Write([]byte("Haiyaa!!"))
+		os.WriteFile(filepath.Join(root, "photos", "racing_file"), []byte("Fuiyoh!!"), 0644)
+		if err := f.Close(); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("\nExpected: %s\nActual: %v", fs.ErrExist, err)
+		}
+		if b, _ := os.ReadFile(filepath.Join(root, "photos", "racing_file")); string(b) != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!", b)
+		}
+	})
+
+	t.Run("Permissions Of The Roles", func(t *testing.T) {
+		tcs := []struct {
+			ro     role
+			method string
+			target string
+			header http.Header
+			code   int
+		}{
+			// replacing a file deletes it
+			{roleUploader, http.MethodPut, "/dav/photos/test_file", nil, http.StatusForbidden},
+			{roleUploader, "PROPFIND", "/dav/photos/", http.Header{"Depth": {"1"}}, http.StatusForbidden},
+			{roleUploader, http.MethodGet, "/dav/photos/test_file", nil, http.StatusForbidden},
+			{roleUploader, http.MethodDelete, "/dav/photos/test_file", nil, http.StatusForbidden},
+			{roleUploader, "MKCOL", "/dav/uploader", nil, http.StatusCreated},
+			{roleReader, http.MethodPut, "/dav/photos/reader_file", nil, http.StatusForbidden},
+			{roleReader, "MKCOL", "/dav/reader", nil, http.StatusForbidden},
+			{roleReader, "MOVE", "/dav/photos/test_file", http.Header{"Destination": {"/dav/moved_file"}}, http.StatusForbidden},
+			{roleAdmin, http.MethodPut, "/dav/photos/test_file", http.Header{"If-None-Match": {"*"}}, http.StatusPreconditionFailed},
+			// hidden names and the storage itself are not writable
+			{roleAdmin, "MOVE", "/dav/photos/", http.Header{"Destination": {"/dav/" + fsutil.TempFilePrefix + "x"}}, http.StatusForbidden},
+			{roleAdmin, http.MethodDelete, "/dav/", nil, http.StatusMethodNotAllowed},
+		}
+
+		for _, tc := range tcs {
+			var body io.Reader
+			if tc.method == http.MethodPut {
+				body = strings.NewReader("Haiyaa!!")
+			}
+			w := serve(tc.ro, tc.method, tc.target, body, tc.header)
+			if w.Code != tc.code {
+				t.Errorf("\nTest Data: (%s %s %s)\nExpected: %d\nActual: %d", tc.ro, tc.method, tc.target, tc.code, w.Code)
+			}
+		}
+	})
+
+	t.Run("Read-Only Storage", func(t *testing.T) {
+		appCache[ckey_readonly] = "true"
+		t.Cleanup(func() { delete(appCache, ckey_readonly) })
+		if w := serve(roleAdmin, "MKCOL", "/dav/readonly", nil, nil); w.Code != http.StatusForbidden {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusForbidden, w.Code)
+		}
+		if w := serve(roleAdmin, http.MethodGet, "/dav/photos/test_file", nil, nil); w.Code != http.StatusOK {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusOK, w.Code)
+		}
+	})
+}
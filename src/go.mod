@@ -4,5 +4,13 @@ go 1.23.3
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/pkg/sftp v1.13.10
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.41.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/net v0.43.0
+	golang.org/x/sys v0.35.0 // indirect
 )
@@ -6,46 +6,76 @@ package main
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
+	"localfs/session"
 	"localfs/util/fsutil"
-	"localfs/util/netutil"
 	"localfs/view"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
-	"text/template"
 
-	"github.com/google/uuid"
 	"github.com/skip2/go-qrcode"
 )
 
 type fileInfo struct {
+	dir  string
 	name string
 	size int64
 	hash string
+	// failure of the file, name is then the submitted name
+	err string
 }
 
-var prgCache = map[string]fileInfo{}
+// relPath returns the slash-separated path relative to the storage.
+func (fi fileInfo) relPath() string {
+	return path.Join(fi.dir, fi.name)
+}
+
+// prgCache keeps the uploaded files info for the post/redirect/get
+// status page, it is initialized by server.initSessions.
+var prgCache *session.Store[[]fileInfo]
 
 func uploadPageHandler(w http.ResponseWriter, r *http.Request) {
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	fpath, err := storageDir(dir)
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
 	// page navigation bar
-	navBar := view.NavBar{
-		ActiveItem: "Upload",
-		NavItem: []view.NavItem{
-			{Name: "Home", Link: "/"},
-		},
+	navBar := view.Breadcrumbs(dir, view.NavItem{Name: "Home", Link: "/"})
+
+	// the uploader role is a drop box, which does not list the files
+	ro := requestRole(r)
+	entries := []fsutil.Entry{}
+	if ro.can(permRead) {
+		if entries, err = fsutil.Listing(fpath); err != nil {
+			errorHandler(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	path := appCache[ckey_storage]
-	files, err := fsutil.FilesListing(path)
-	if err != nil {
-		errorHandler(w, err.Error(), http.StatusInternalServerError)
-		return
+	files := []view.ListingItem{}
+	for _, entry := range entries {
+		files = append(files, view.ListingItem{
+			Name:  entry.Name,
+			Path:  path.Join(dir, entry.Name),
+			IsDir: entry.IsDir,
+		})
 	}
 
 	// set headers
@@ -53,8 +83,10 @@ func uploadPageHandler(w http.ResponseWriter, r *http.Request) {
 	h.Set("Content-Type", "text/html; charset=utf-8")
 
 	fmap := template.FuncMap{
-		"index":    view.ListingIndex,
-		"zebraCss": view.ListingZebraCss,
+		"index":        view.ListingIndex,
+		"zebraCss":     view.ListingZebraCss,
+		"downloadLink": view.DownloadLink,
+		"folderLink":   view.FolderLink,
 	}
 
 	t, err := template.New("uploadPage").Funcs(fmap).Parse(view.UploadPageTmpl)
@@ -64,84 +96,258 @@ func uploadPageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	t.Execute(w, view.UploadPageViewModel{
-		Build:  appBuild,
-		Files:  files,
-		NavBar: navBar,
+		Build:    appBuild,
+		Dir:      dir,
+		ReadOnly: isReadOnly() || !ro.can(permUpload),
+		DropBox:  !ro.can(permRead),
+		Share:    ro.can(permModify),
+		Files:    files,
+		NavBar:   navBar,
 	})
 }
 
-func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
-	file, fileHeader, err := r.FormFile("file")
+func uploadFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorHandler(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	fpath, err := storageDir(dir)
 	if err != nil {
-		if strings.Contains(err.Error(), "no space left on device") {
-			errorHandler(w, err.Error(), http.StatusInternalServerError)
-			return
+		storageErrorHandler(w, err)
+		return
+	}
+
+	err = fsutil.CreateDir(fpath, r.PostFormValue("name"))
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	http.Redirect(w, r, view.FolderLink(dir), http.StatusSeeOther)
+}
+
+func uploadDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	batchHandler(w, r, func(fpath, name string) error {
+		src, err := fsutil.SecureJoin(fpath, name)
+		if err != nil {
+			return err
 		}
+		return fsutil.Remove(src)
+	})
+}
+
+func uploadRenameHandler(w http.ResponseWriter, r *http.Request) {
+	newname := r.PostFormValue("newname")
+	batchHandler(w, r, func(fpath, name string) error {
+		_, err := fsutil.Rename(fpath, name, newname)
+		return err
+	})
+}
+
+func uploadMoveHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := storageDir(cleanDir(r.PostFormValue("target")))
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	batchHandler(w, r, func(fpath, name string) error {
+		src, err := fsutil.SecureJoin(fpath, name)
+		if err != nil {
+			return err
+		}
+		_, err = fsutil.Move(src, target)
+		return err
+	})
+}
+
+// batchHandler applies the operation to every selected "name" inside the
+// folder and redirects back to the folder. Failed names do not stop the
+// batch, they are reported together once all names are processed.
+// A name resolving to the folder itself, or the storage, is rejected.
+func batchHandler(w http.ResponseWriter, r *http.Request, operation func(fpath, name string) error) {
+	if r.Method != http.MethodPost {
+		errorHandler(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	fpath, err := storageDir(dir)
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
 		errorHandler(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	names := r.PostForm["name"]
+	if len(names) == 0 {
+		errorHandler(w, "no file selected.", http.StatusBadRequest)
+		return
+	}
 
-	path := appCache[ckey_storage]
-	fname := fsutil.ResolveFileConflict(path, fileHeader.Filename)
-	err = fsutil.WriteStreamToFile(path, fname, file)
+	code, messages := 0, []string{}
+	for _, name := range names {
+		err := fsutil.ErrInvalidPath
+		if cleanDir(name) != "" {
+			err = operation(fpath, name)
+		}
+		if err != nil {
+			status, message := storageError(err)
+			code = max(code, status)
+			messages = append(messages, fmt.Sprintf("'%s' %s", name, message))
+		}
+	}
+	if len(messages) > 0 {
+		errorHandler(w, strings.Join(messages, " "), code)
+		return
+	}
+
+	http.Redirect(w, r, view.FolderLink(dir), http.StatusSeeOther)
+}
+
+func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	// target folder
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	fpath, err := storageDir(dir)
 	if err != nil {
-		errorHandler(w, err.Error(), http.StatusInternalServerError)
+		storageErrorHandler(w, err)
 		return
 	}
 
-	uid := uuid.New().String()
-	// back to the beginning of the file
-	file.Seek(0, io.SeekStart)
-	hash, _ := fsutil.Sha256sum(file)
+	// read the multipart body as a stream, so the file part is written
+	// straight into the storage instead of a temporary file
+	reader, err := r.MultipartReader()
+	if err != nil {
+		errorHandler(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// store every file part, a failed file does not fail the others
+	files := []fileInfo{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(files) == 0 {
+				errorHandler(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			files = append(files, fileInfo{dir: dir, err: "upload interrupted: " + err.Error()})
+			break
+		}
+
+		// skip other form fields and empty file selection
+		name := partFileName(part)
+		if part.FormName() != "file" || name == "" {
+			part.Close()
+			continue
+		}
+
+		files = append(files, saveUpload(dir, fpath, name, part))
+		part.Close()
+	}
 
-	prgCache[uid] = fileInfo{
-		name: fname,
-		size: fileHeader.Size,
-		hash: hash,
+	if len(files) == 0 {
+		errorHandler(w, http.ErrMissingFile.Error(), http.StatusBadRequest)
+		return
 	}
 
+	uid := prgCache.Put(files)
 	http.Redirect(w, r, fmt.Sprintf("/upload/status?uid=%s", uid), http.StatusSeeOther)
 }
 
+// partFileName returns the file name of the part, unlike part.FileName
+// the relative path of folder uploads, e.g. "photos/2024/a.jpg", is kept.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// saveUpload stores the stream as the file name inside the folder, the
+// folders of a slash-separated relative name are created when missing.
+func saveUpload(dir, fpath, name string, stream io.Reader) fileInfo {
+	fi := fileInfo{dir: dir, name: name}
+
+	sub, fname := path.Split(name)
+	if sub != "" {
+		var err error
+		fpath, err = fsutil.CreateDirAll(fpath, sub)
+		if err != nil {
+			_, fi.err = storageError(err)
+			return fi
+		}
+		fi.dir = path.Join(dir, cleanDir(sub))
+	}
+
+	stored, size, hash, err := fsutil.SaveStream(fpath, fname, stream)
+	if err != nil {
+		_, fi.err = storageError(err)
+		return fi
+	}
+
+	if info, err := os.Stat(filepath.Join(fpath, stored)); err == nil {
+		hashCache.Put(filepath.Join(fpath, stored), info, hash)
+	}
+	fi.name, fi.size, fi.hash = stored, size, hash
+	return fi
+}
+
 func uploadStatusPageHandler(w http.ResponseWriter, r *http.Request) {
+	// get the files info of every uid, the resumable
+	// uploads of the upload page pass one uid per file
+	files := []fileInfo{}
+	for _, uid := range r.URL.Query()["uid"] {
+		if fis, ok := prgCache.Get(uid); ok {
+			files = append(files, fis...)
+		}
+	}
+	if len(files) == 0 {
+		errorHandler(w, "upload status not found or expired.", http.StatusNotFound)
+		return
+	}
+
 	// page navigation bar
 	navBar := view.NavBar{
 		ActiveItem: "Status",
 		NavItem: []view.NavItem{
 			{Name: "Home", Link: "/"},
-			{Name: "Upload", Link: "/upload"},
+			{Name: "Upload", Link: view.FolderLink(commonDir(files))},
 		},
 	}
 
-	// get uid from query param
-	uid := r.URL.Query().Get("uid")
-	// get file info from cache
-	fi, ok := prgCache[uid]
-	if !ok {
-		errorHandler(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
-	}
-	// clear cache
-	delete(prgCache, uid)
-
-	// do verification
-	path := filepath.Join(appCache[ckey_storage], fi.name)
-	file, err := os.Open(path)
-	if err != nil {
-		errorHandler(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer file.Close()
-
-	// get hash
-	hash, _ := fsutil.Sha256sum(file)
+	model := view.UploadStatusPageViewModel{NavBar: navBar}
+	for _, fi := range files {
+		item := view.UploadStatusItem{
+			Filename: fi.relPath(),
+			Size:     strconv.FormatInt(fi.size, 10),
+		}
+		if fi.err != "" {
+			// the name as submitted, which may not be clean
+			item.Filename = strings.TrimPrefix(fi.dir+"/"+fi.name, "/")
+			item.Error = true
+			item.Message = fi.err
+			model.Failed++
+			model.Files = append(model.Files, item)
+			continue
+		}
 
-	mismatch := false
-	message := ""
-	if fi.hash != hash {
-		mismatch = true
-		message = "hash mismatch detected."
+		// do verification
+		item.Sha256sum, item.Message = verifyUpload(fi)
+		item.Error = item.Message != ""
+		if item.Error {
+			model.Failed++
+		}
+		model.Files = append(model.Files, item)
 	}
 
 	// set headers
@@ -154,36 +360,93 @@ func uploadStatusPageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t.Execute(w, view.UploadStatusPageViewModel{
-		Error:     mismatch,
-		Message:   message,
-		Filename:  fi.name,
-		Size:      strconv.FormatInt(fi.size, 10),
-		Sha256sum: hash,
-		NavBar:    navBar,
-	})
+	t.Execute(w, model)
 }
 
-func indexPageHandler(w http.ResponseWriter, _ *http.Request) {
-	// get ip address
-	addrs, err := netutil.IPv4Address()
+// verifyUpload returns the checksum of the stored file,
+// and a message when it does not match the upload.
+func verifyUpload(fi fileInfo) (string, string) {
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], fi.relPath())
 	if err != nil {
-		// fallback to localhost
-		addrs = []string{"localhost"}
+		_, message := storageError(err)
+		return "", message
+	}
+	file, err := os.Open(fpath)
+	if err != nil {
+		_, message := storageError(err)
+		return "", message
+	}
+	defer file.Close()
+
+	// get hash
+	hash, _ := fsutil.Sha256sum(file)
+	if fi.hash != hash {
+		return hash, "hash mismatch detected."
+	}
+	return hash, ""
+}
+
+// commonDir returns the deepest folder containing every file.
+func commonDir(files []fileInfo) string {
+	common := strings.Split(files[0].dir, "/")
+	for _, fi := range files[1:] {
+		segments := strings.Split(fi.dir, "/")
+		n := 0
+		for n < len(common) && n < len(segments) && common[n] == segments[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	return strings.Join(common, "/")
+}
+
+func indexPageHandler(w http.ResponseWriter, r *http.Request) {
+	// the address of the QR codes, the user switches
+	// to the address of the network of the device
+	addrs := serverAddrs()
+	addr := addrs[0]
+	if a := r.URL.Query().Get("addr"); slices.Contains(addrs, a) {
+		addr = a
+	}
+	addrItems := []view.IndexAddressItem{}
+	for _, a := range addrs {
+		addrItems = append(addrItems, view.IndexAddressItem{
+			Address:  a,
+			Link:     "/?" + url.Values{"addr": {a}}.Encode(),
+			Selected: a == addr,
+		})
 	}
 
 	// parse qrcode content
-	host := net.JoinHostPort(addrs[0], appCache[ckey_port])
-	content := url.URL{Scheme: "http", Host: host, Path: "upload"}
-	// generate the QR code image as a byte slice (PNG format)
-	byt, err := qrcode.Encode(content.String(), qrcode.Medium, 320)
+	content := addrURL(addr, "upload")
+	pin := ""
+	if authn != nil && !authn.password {
+		// pair the scanning device
+		content = authn.pairingURL(content, roleAdmin)
+		pin = authn.secret
+	}
+	base64png, err := qrImage(content.String())
 	if err != nil {
 		errorHandler(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// convert the byte slice to a base64 string
-	base64png := base64.StdEncoding.EncodeToString(byt)
+	// a QR code of each role, pairing the device with the role
+	items := []view.IndexRoleItem{}
+	if authn != nil && authn.roles != nil {
+		for _, ro := range roles {
+			item := view.IndexRoleItem{Title: roleTitles[ro], Base64QRImage: base64png}
+			if ro != roleAdmin || !authn.password {
+				u := authn.pairingURL(addrURL(addr, "upload"), ro)
+				item.Pin = authn.secretOf(ro)
+				if item.Base64QRImage, err = qrImage(u.String()); err != nil {
+					errorHandler(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			items = append(items, item)
+		}
+	}
 
 	h := w.Header()
 	h.Set("Content-Type", "text/html; charset=utf-8")
@@ -196,13 +459,152 @@ func indexPageHandler(w http.ResponseWriter, _ *http.Request) {
 
 	t.Execute(w, view.IndexPageViewModel{
 		Base64QRImage: base64png,
-		Address:       addrs[0],
+		Address:       content.Hostname(),
+		Addresses:     addrItems,
+		Pin:           pin,
+		Roles:         items,
+		Fingerprint:   appCache[ckey_fingerprint],
+	})
+}
+
+// serverAddrs returns the network addresses of the host, in the order of
+// the QR codes. These are the addresses the server listens on, or the
+// addresses of the selected interfaces, followed by the mDNS name.
+// Link-local IPv6 addresses are left out, their zone is the interface
+// of the host, which the browser of a device can not use.
+func serverAddrs() []string {
+	addrs := []string{}
+	for _, host := range boundHosts {
+		ip := netip.MustParseAddr(host)
+		if ip.IsUnspecified() {
+			addrs = []string{}
+			break
+		}
+		if !ip.IsLoopback() {
+			addrs = append(addrs, host)
+		}
+	}
+	if len(addrs) == 0 {
+		// get ip address
+		addrs, _ = netFilter.IPAddresses()
+	}
+	addrs = slices.DeleteFunc(addrs, func(addr string) bool {
+		return netip.MustParseAddr(addr).IsLinkLocalUnicast()
 	})
+	if hostname := appCache[ckey_hostname]; hostname != "" {
+		addrs = append(addrs, hostname)
+	}
+	if len(addrs) == 0 {
+		// fallback to localhost
+		addrs = []string{"localhost"}
+	}
+	return addrs
+}
+
+// serverURL returns the URL of the path on the first network
+// address of the host, as encoded in the QR codes.
+func serverURL(p string) url.URL {
+	return addrURL(serverAddrs()[0], p)
+}
+
+// addrURL returns the URL of the path on the address,
+// an IPv6 address is enclosed in brackets.
+func addrURL(addr, p string) url.URL {
+	host := net.JoinHostPort(addr, appCache[ckey_port])
+	return url.URL{Scheme: scheme(), Host: host, Path: p}
+}
+
+// qrImage returns the QR code of the content as a base64 PNG image.
+func qrImage(content string) (string, error) {
+	// generate the QR code image as a byte slice (PNG format)
+	byt, err := qrcode.Encode(content, qrcode.Medium, 320)
+	if err != nil {
+		return "", err
+	}
+
+	// convert the byte slice to a base64 string
+	return base64.StdEncoding.EncodeToString(byt), nil
+}
+
+// printQR prints the QR code of the path on the first network address
+// to the terminal, followed by the URL of the path on every address.
+func printQR(w io.Writer, p string) error {
+	u := serverURL(p)
+	q, err := qrcode.New(u.String(), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	// drawn for the light text of dark terminals
+	fmt.Fprint(w, q.ToSmallString(false))
+	for _, addr := range serverAddrs() {
+		u := addrURL(addr, p)
+		fmt.Fprintf(w, "  %s\n", u.String())
+	}
+	fmt.Fprintln(w)
+	return nil
 }
 
 func fileHandler(prefix string) http.Handler {
-	fileDir := appCache[ckey_storage]
-	return http.StripPrefix(prefix, http.FileServer(http.Dir(fileDir)))
+	return http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, info, err := openStorageFile(r.URL.Path)
+		if err != nil {
+			storageErrorHandler(w, err)
+			return
+		}
+		defer file.Close()
+
+		// folders are browsed on the upload page
+		if info.IsDir() {
+			http.Redirect(w, r, view.FolderLink(cleanDir(r.URL.Path)), http.StatusSeeOther)
+			return
+		}
+
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	}))
+}
+
+// cleanDir trims the slashes of the folder query parameter.
+func cleanDir(dir string) string {
+	return strings.Trim(dir, "/")
+}
+
+// storageDir returns the absolute path of the folder inside the storage.
+func storageDir(dir string) (string, error) {
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], dir)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fs.ErrNotExist
+	}
+	return fpath, nil
+}
+
+// storageErrorHandler maps the storage errors to the response status.
+func storageErrorHandler(w http.ResponseWriter, err error) {
+	code, message := storageError(err)
+	errorHandler(w, message, code)
+}
+
+func storageError(err error) (int, string) {
+	switch {
+	case errors.Is(err, fsutil.ErrInvalidPath),
+		errors.Is(err, fsutil.ErrInvalidFileName):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound, "file or folder not found."
+	case errors.Is(err, fs.ErrExist):
+		return http.StatusConflict, "file or folder already exists."
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden, "permission denied."
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
 }
 
 func routesHandler(w http.ResponseWriter, r *http.Request) {
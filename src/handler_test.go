@@ -0,0 +1,474 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"localfs/session"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hostile file names, each must never be rendered verbatim
+var hostileNames = []string{
+	`<script>alert(1)<script>.txt`,
+	`"><img src=x onerror=alert(1)>.txt`,
+	`' onmouseover='alert(1).txt`,
+	`javascript:alert(1)`,
+	`a#b?c=<b>d&e.txt`,
+	`{{.Build}}.txt`,
+}
+
+// setupStorage points the storage to a temporary directory
+// and initializes the upload sessions.
+func setupStorage(t *testing.T) string {
+	path := t.TempDir()
+	appCache[ckey_storage] = path
+	appCache[ckey_port] = defaultPort
+
+	prgCache = session.NewStore[[]fileInfo](time.Minute, 16)
+	t.Cleanup(prgCache.Close)
+	return path
+}
+
+// assertEscaped fails when the body contains the name or its markup unescaped.
+func assertEscaped(t *testing.T, body, name string) {
+	t.Helper()
+	if strings.ContainsAny(name, `<>"'&`) && strings.Contains(body, name) {
+		t.Errorf("\nTest Data: (%s)\nExpected: escaped\nActual: rendered verbatim", name)
+	}
+	for _, raw := range []string{"<script>alert", "<img", "<b>"} {
+		if strings.Contains(name, raw) && strings.Contains(body, raw) {
+			t.Errorf("\nTest Data: (%s)\nExpected: escaped '%s'\nActual: rendered verbatim", name, raw)
+		}
+	}
+}
+
+func TestUploadPageEscaping(t *testing.T) {
+	path := setupStorage(t)
+	for _, name := range hostileNames {
+		err := os.WriteFile(filepath.Join(path, name), []byte("Fuiyoh!!"), 0644)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+	}
+
+	w := httptest.NewRecorder()
+	uploadPageHandler(w, httptest.NewRequest(http.MethodGet, "/upload", nil))
+	body := w.Body.String()
+
+	if w.Code != http.StatusOK {
+		t.Errorf("\nExpected: %d\nActual: %d", http.StatusOK, w.Code)
+	}
+
+	t.Run("Listing Names Are Escaped", func(t *testing.T) {
+		for _, name := range hostileNames {
+			assertEscaped(t, body, name)
+		}
+		if strings.Contains(body, "build#"+appBuild+".txt") {
+			t.Errorf("\nExpected template actions in names not to be evaluated.")
+		}
+	})
+
+	t.Run("Download Links Are Path Escaped", func(t *testing.T) {
+		expected := `href="/download/a%23b%3Fc=%3Cb%3Ed&amp;e.txt"`
+		if !strings.Contains(body, expected) {
+			t.Errorf("\nExpected: %s\nActual: link not found", expected)
+		}
+		expected = `href="/download/javascript:alert%281%29"`
+		if !strings.Contains(body, expected) {
+			t.Errorf("\nExpected: %s\nActual: link not found", expected)
+		}
+	})
+
+	t.Run("Download Links Serve The File", func(t *testing.T) {
+		h := fileHandler("/download/")
+		for _, link := range []string{
+			"/download/a%23b%3Fc=%3Cb%3Ed&e.txt",
+			"/download/%3Cscript%3Ealert%281%29%3Cscript%3E.txt",
+		} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, link, nil))
+			if w.Code != http.StatusOK || w.Body.String() != "Fuiyoh!!" {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", link, http.StatusOK, w.Code)
+			}
+		}
+	})
+}
+
+// uploadFile posts the file through the upload handler.
+func uploadFile(name string, content string) *httptest.ResponseRecorder {
+	return uploadFileTo("", name, content)
+}
+
+// uploadFileTo posts the file into the folder through the upload handler.
+func uploadFileTo(dir, name string, content string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, _ := mw.CreateFormFile("file", name)
+	io.WriteString(part, content)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload/file?dir="+url.QueryEscape(dir), &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, r)
+	return w
+}
+
+func TestUploadStatusPageEscaping(t *testing.T) {
+	setupStorage(t)
+
+	for _, name := range hostileNames {
+		// the status page renders the name as stored by the upload
+		w := uploadFile(name, "Fuiyoh!!")
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", name, http.StatusSeeOther, w.Code)
+			continue
+		}
+
+		location := w.Header().Get("Location")
+		w = httptest.NewRecorder()
+		uploadStatusPageHandler(w, httptest.NewRequest(http.MethodGet, location, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", name, http.StatusOK, w.Code)
+		}
+		assertEscaped(t, w.Body.String(), name)
+	}
+}
+
+func TestErrorPageEscaping(t *testing.T) {
+	for _, name := range hostileNames {
+		w := httptest.NewRecorder()
+		errorHandler(w, "open "+name+": no such file or directory", http.StatusNotFound)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", name, http.StatusNotFound, w.Code)
+		}
+		assertEscaped(t, w.Body.String(), name)
+	}
+}
+
+func TestIndexPageEscaping(t *testing.T) {
+	setupStorage(t)
+
+	w := httptest.NewRecorder()
+	indexPageHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+
+	if w.Code != http.StatusOK {
+		t.Errorf("\nExpected: %d\nActual: %d", http.StatusOK, w.Code)
+	}
+	// the QR code image must survive the URL sanitizer
+	if !strings.Contains(body, `src="data:image/png;base64, iVBOR`) {
+		t.Errorf("\nExpected: QR code data URL\nActual: %s", body)
+	}
+}
+
+func TestIndexPageAddresses(t *testing.T) {
+	setupStorage(t)
+	boundHosts = []string{"192.168.1.5", "10.8.0.2", "fd00::2", "fe80::1%eth0", "127.0.0.1", "::1"}
+	t.Cleanup(func() { boundHosts = nil })
+
+	// initialize testcases
+	tcs := []struct {
+		query    string
+		expected string
+	}{
+		{"", "192.168.1.5"},
+		{"?addr=10.8.0.2", "10.8.0.2"},
+		// only the addresses of the host are encoded
+		{"?addr=203.0.113.1", "192.168.1.5"},
+		{"?addr=127.0.0.1", "192.168.1.5"},
+		{"?addr=fd00::2", "fd00::2"},
+		// link-local addresses are of the interface of the host
+		{"?addr=fe80::1%25eth0", "192.168.1.5"},
+	}
+
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		indexPageHandler(w, httptest.NewRequest(http.MethodGet, "/"+tc.query, nil))
+		body := w.Body.String()
+		if !strings.Contains(body, "<p>"+tc.expected+"</p>") {
+			t.Errorf("\nTest Data: (%s)\nExpected: %s\nActual: %s", tc.query, tc.expected, body)
+		}
+		// the other addresses are links to switch to
+		if !strings.Contains(body, `href="/?addr=10.8.0.2"`) || strings.Contains(body, `addr=127.0.0.1"`) {
+			t.Errorf("\nTest Data: (%s)\nExpected: address links\nActual: %s", tc.query, body)
+		}
+	}
+}
+
+func TestAddrURL(t *testing.T) {
+	appCache[ckey_port] = "5000"
+	t.Cleanup(func() { delete(appCache, ckey_port) })
+
+	// initialize testcases
+	tcs := []struct {
+		addr     string
+		expected string
+	}{
+		{"192.168.1.5", "http://192.168.1.5:5000/upload"},
+		{"fd00::2", "http://[fd00::2]:5000/upload"},
+		{"localfs.local", "http://localfs.local:5000/upload"},
+	}
+
+	for _, tc := range tcs {
+		u := addrURL(tc.addr, "upload")
+		if actual := u.String(); actual != tc.expected {
+			t.Errorf("\nTest Data: (%s)\nExpected: %s\nActual: %s", tc.addr, tc.expected, actual)
+		}
+	}
+}
+
+func TestFolders(t *testing.T) {
+	root := setupStorage(t)
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	t.Run("Create Nested Folder And Upload Into It", func(t *testing.T) {
+		for _, dir := range []string{"", "project"} {
+			r := httptest.NewRequest(http.MethodPost, "/upload/folder?dir="+dir,
+				strings.NewReader("name=project"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			uploadFolderHandler(w, r)
+			if w.Code != http.StatusSeeOther {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", dir, http.StatusSeeOther, w.Code)
+			}
+		}
+
+		w := uploadFileTo("project/project", "test_file", "Fuiyoh!!")
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusSeeOther, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		fileHandler("/download/").ServeHTTP(w,
+			httptest.NewRequest(http.MethodGet, "/download/project/project/test_file", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %d Fuiyoh!!\nActual: %d %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		uploadPageHandler(w, httptest.NewRequest(http.MethodGet, "/upload?dir=project", nil))
+		body := w.Body.String()
+		for _, expected := range []string{`href="/upload?dir=project%2Fproject"`,
+			`<li><a href="/upload">Upload</a></li>`, `action="/upload/file?dir=project"`} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("\nExpected: %s\nActual: not found", expected)
+			}
+		}
+	})
+
+	t.Run("Paths Escaping The Storage Are Rejected", func(t *testing.T) {
+		for _, dir := range []string{"..", "project/../..", "escape"} {
+			w := uploadFileTo(dir, "test_file", "Fuiyoh!!")
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", dir, http.StatusBadRequest, w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			uploadPageHandler(w, httptest.NewRequest(http.MethodGet, "/upload?dir="+dir, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", dir, http.StatusBadRequest, w.Code)
+			}
+		}
+
+		entries, _ := os.ReadDir(outside)
+		if len(entries) != 0 {
+			t.Errorf("\nExpected no file outside of the storage, but found %d.", len(entries))
+		}
+	})
+}
+
+func TestBatchOperations(t *testing.T) {
+	root := setupStorage(t)
+	for _, name := range []string{"test_file_1", "test_file_2", "test_file_3"} {
+		os.WriteFile(filepath.Join(root, name), []byte(name), 0644)
+	}
+
+	post := func(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	t.Run("Delete Selected Files", func(t *testing.T) {
+		w := post(uploadDeleteHandler, "/upload/delete",
+			url.Values{"name": {"test_file_1", "test_file_2"}})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusSeeOther, w.Code)
+		}
+
+		entries, _ := os.ReadDir(root)
+		if len(entries) != 1 || entries[0].Name() != "test_file_3" {
+			t.Errorf("\nExpected: [test_file_3]\nActual: %v", entries)
+		}
+	})
+
+	t.Run("Failed Names Are Reported", func(t *testing.T) {
+		w := post(uploadDeleteHandler, "/upload/delete",
+			url.Values{"name": {"missing_file", "test_file_3"}})
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "missing_file") {
+			t.Errorf("\nExpected: %d missing_file\nActual: %d", http.StatusNotFound, w.Code)
+		}
+		if _, err := os.Stat(filepath.Join(root, "test_file_3")); err == nil {
+			t.Errorf("\nExpected the remaining names to be processed.")
+		}
+	})
+
+	t.Run("Current Folder Is Rejected", func(t *testing.T) {
+		os.Mkdir(filepath.Join(root, "project"), 0755)
+		tcs := []struct {
+			title   string
+			handler http.HandlerFunc
+			target  string
+			form    url.Values
+		}{
+			{"Delete Storage", uploadDeleteHandler, "/upload/delete", url.Values{"name": {"/"}}},
+			{"Delete Empty Name", uploadDeleteHandler, "/upload/delete", url.Values{"name": {""}}},
+			{"Delete Current Folder", uploadDeleteHandler, "/upload/delete?dir=project", url.Values{"name": {"//"}}},
+			{"Rename Storage", uploadRenameHandler, "/upload/rename", url.Values{"name": {"/"}, "newname": {"project_2"}}},
+			{"Move Storage", uploadMoveHandler, "/upload/move", url.Values{"name": {""}, "target": {"project"}}},
+		}
+		for _, tc := range tcs {
+			w := post(tc.handler, tc.target, tc.form)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", tc.title, http.StatusBadRequest, w.Code)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(root, "project")); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+	})
+
+	t.Run("Only Post Is Allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		uploadDeleteHandler(w, httptest.NewRequest(http.MethodGet, "/upload/delete?name=x", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	root := setupStorage(t)
+	appCache[ckey_readonly] = "true"
+	t.Cleanup(func() { appCache[ckey_readonly] = "false" })
+
+	t.Run("Writes Are Rejected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/upload/folder", strings.NewReader("name=project"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		writable(uploadFolderHandler, errorHandler)(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusForbidden, w.Code)
+		}
+		if _, err := os.Stat(filepath.Join(root, "project")); err == nil {
+			t.Errorf("\nExpected folder not to be created, but found it.")
+		}
+	})
+
+	t.Run("Upload Controls Are Hidden", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		uploadPageHandler(w, httptest.NewRequest(http.MethodGet, "/upload", nil))
+		for _, control := range []string{`id="uform"`, `data-batch`, `<script>`} {
+			if strings.Contains(w.Body.String(), control) {
+				t.Errorf("\nExpected '%s' to be hidden, but found it.", control)
+			}
+		}
+	})
+}
+
+func TestMultipleUpload(t *testing.T) {
+	root := setupStorage(t)
+
+	// initialize testcases
+	tcs := []struct {
+		name     string
+		expected string
+	}{
+		{"photos/2024/a.jpg", "photos/2024/a.jpg"},
+		{"photos/b.jpg", "photos/b.jpg"},
+		{"photos/../../escape.jpg", ""},
+		{"test_file", "test_file"},
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, tc := range tcs {
+		part, _ := mw.CreateFormFile("file", tc.name)
+		io.WriteString(part, "Fuiyoh!!")
+	}
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload/file", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("\nExpected: %d\nActual: %d", http.StatusSeeOther, w.Code)
+		t.FailNow()
+	}
+
+	t.Run("Relative Paths Are Preserved", func(t *testing.T) {
+		for _, tc := range tcs {
+			if tc.expected == "" {
+				continue
+			}
+			byt, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(tc.expected)))
+			if err != nil || string(byt) != "Fuiyoh!!" {
+				t.Errorf("\nTest Data: (%s)\nExpected: Fuiyoh!!\nActual: %s (%v)", tc.name, byt, err)
+			}
+		}
+	})
+
+	t.Run("Status Lists Every File", func(t *testing.T) {
+		location := w.Header().Get("Location")
+		w := httptest.NewRecorder()
+		uploadStatusPageHandler(w, httptest.NewRequest(http.MethodGet, location, nil))
+		body := w.Body.String()
+
+		if strings.Count(body, "Completed") != 3 || !strings.Contains(body, "1 failed") {
+			t.Errorf("\nExpected: 3 completed, 1 failed\nActual: %s", body)
+		}
+		for _, tc := range tcs {
+			if !strings.Contains(body, "file: "+tc.name) {
+				t.Errorf("\nTest Data: (%s)\nExpected: listed\nActual: not found", tc.name)
+			}
+		}
+	})
+
+	t.Run("Status Of Several Uploads", func(t *testing.T) {
+		uids := url.Values{}
+		for _, name := range []string{"c.jpg", "d.jpg"} {
+			location := uploadFile(name, "Haiyaa!!").Header().Get("Location")
+			u, _ := url.Parse(location)
+			uids.Add("uid", u.Query().Get("uid"))
+		}
+
+		w := httptest.NewRecorder()
+		uploadStatusPageHandler(w, httptest.NewRequest(http.MethodGet, "/upload/status?"+uids.Encode(), nil))
+		if body := w.Body.String(); !strings.Contains(body, "c.jpg") || !strings.Contains(body, "d.jpg") {
+			t.Errorf("\nExpected: c.jpg and d.jpg\nActual: %s", body)
+		}
+	})
+}
@@ -7,35 +7,133 @@ package main
 import (
 	"flag"
 	"fmt"
+	"localfs/mdns"
+	"localfs/s3"
+	"localfs/util/netutil"
 	"log"
+	"net/netip"
 	"os"
-	"runtime"
+	"strings"
 )
 
-func commandLineFlag() (port *string) {
+func commandLineFlag() (cfg *config) {
+	cfg = &config{}
+
+	// handle subcommand
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			cfg.serve = true
+			args = args[1:]
+		case "send":
+			cfg.send = true
+			args = args[1:]
+		case "receive":
+			cfg.receive = true
+			args = args[1:]
+		}
+	}
+
 	//override flag usage
 	flag.Usage = func() {
 		fmt.Printf("LocalFS %s, a portable web-based local file server.\n", appBuild)
 		fmt.Printf("Usage: %s [options]\n", os.Args[0])
+		fmt.Printf("       %s serve [options] <dir>   share an existing directory\n", os.Args[0])
+		fmt.Printf("       %s send [options] <file>...   send files to a device once, then exit\n", os.Args[0])
+		fmt.Printf("       %s receive [options] [<dir>]   receive files into the directory, then exit\n", os.Args[0])
 		fmt.Printf("options\n")
 		fmt.Printf("  %-20s server port to use (default %s).\n", "-p, --port", defaultPort)
-		fmt.Printf("  %-20s use '/var/tmp' for the temporary directory instead of\n"+
-			"%-23s'tmpfs' to handle large file uploads (linux systems only).\n", "    --no-tmpfs", "")
+		fmt.Printf("  %-20s address to listen on, repeat or separate by commas for several\n"+
+			"%-23s(default every address).\n", "    --host", "")
+		fmt.Printf("  %-20s network interfaces to listen on and show QR codes of, name\n"+
+			"%-23spatterns such as 'wlan*', repeat or separate by commas for several.\n", "    --interface", "")
+		fmt.Printf("  %-20s network interfaces not to show QR codes of, in addition to\n"+
+			"%-23s'%s'.\n", "    --exclude-interface", "", strings.Join(netutil.DefaultExclude, ","))
+		fmt.Printf("  %-20s storage directory to use, created when missing\n"+
+			"%-23s(default ~/%s).\n", "-s, --storage", "", defaultStorage)
+		fmt.Printf("  %-20s share the storage read-only, uploads and changes are rejected.\n",
+			"    --read-only")
+		fmt.Printf("  %-20s how long an upload status link stays valid (default %s).\n",
+			"    --session-ttl", defaultSessionTTL)
+		fmt.Printf("  %-20s maximum number of upload status links kept (default %d).\n",
+			"    --session-capacity", defaultSessionCapacity)
+		fmt.Printf("  %-20s require a pairing PIN, shown with the QR code on the host.\n",
+			"    --auth")
+		fmt.Printf("  %-20s require the password instead of a PIN, implies '--auth'\n"+
+			"%-23s(default $%s).\n", "    --password", "", passwordEnv)
+		fmt.Printf("  %-20s pair upload-only and read-only clients with PINs of their own,\n"+
+			"%-23sthe '--auth' PIN or password is the admin, implies '--auth'.\n", "    --roles", "")
+		fmt.Printf("  %-20s serve HTTPS with a self-signed certificate, created on first use.\n",
+			"    --tls")
+		fmt.Printf("  %-20s serve HTTPS with the certificate file instead, implies '--tls'.\n",
+			"    --tls-cert")
+		fmt.Printf("  %-20s private key file of the certificate.\n", "    --tls-key")
+		fmt.Printf("  %-20s serve the S3-compatible API at /s3 with the access key, of the\n"+
+			"%-23sform <access key id>:<secret>[:<role>], repeat for several keys\n"+
+			"%-23s(default $%s).\n", "    --s3-key", "", "", s3KeyEnv)
+		fmt.Printf("  %-20s serve SFTP, sign in with the PIN or password of '--auth'.\n",
+			"    --sftp")
+		fmt.Printf("  %-20s SFTP server port to use (default %s).\n", "    --sftp-port", defaultSFTPPort)
+		fmt.Printf("  %-20s sign in to SFTP as admin with the keys of the authorized_keys\n"+
+			"%-23sfile, implies '--sftp'.\n", "    --sftp-keys", "")
+		fmt.Printf("  %-20s 'send' and 'receive' exit after this many downloads or files\n"+
+			"%-23s(default %d).\n", "    --count", "", defaultCount)
+		fmt.Printf("  %-20s 'send' and 'receive' exit with status 1 after this time\n"+
+			"%-23s(default %s).\n", "    --timeout", "", defaultTimeout)
+		fmt.Printf("  %-20s name announced by mDNS, reachable at <name>.local (default %s).\n",
+			"    --name", defaultName)
+		fmt.Printf("  %-20s do not announce the server by mDNS.\n", "    --no-mdns")
 		fmt.Printf("  %-20s print this list and exit.\n", "-h, --help")
 		fmt.Printf("  %-20s print the version and exit.\n", "-v, --version")
 		fmt.Printf("\n")
 	}
 
 	// server port
-	port = flag.String("port", defaultPort, "server port to use")
-	flag.StringVar(port, "p", defaultPort, "server port to use")
+	flag.StringVar(&cfg.port, "port", defaultPort, "server port to use")
+	flag.StringVar(&cfg.port, "p", defaultPort, "server port to use")
+	// network
+	flag.Var((*listFlag)(&cfg.hosts), "host", "address to listen on")
+	flag.Var((*listFlag)(&cfg.interfaces), "interface", "network interfaces to listen on")
+	flag.Var((*listFlag)(&cfg.excludeInterfaces), "exclude-interface", "network interfaces not to show QR codes of")
+	// storage
+	flag.StringVar(&cfg.storage, "storage", "", "storage directory to use")
+	flag.StringVar(&cfg.storage, "s", "", "storage directory to use")
+	flag.BoolVar(&cfg.readOnly, "read-only", false, "share the storage read-only")
+	// upload sessions
+	flag.DurationVar(&cfg.sessionTTL, "session-ttl", defaultSessionTTL,
+		"how long an upload status link stays valid")
+	flag.IntVar(&cfg.sessionCapacity, "session-capacity", defaultSessionCapacity,
+		"maximum number of upload status links kept")
+	// authentication
+	flag.BoolVar(&cfg.auth, "auth", false, "require a pairing PIN")
+	flag.StringVar(&cfg.password, "password", os.Getenv(passwordEnv),
+		"require the password instead of a PIN")
+	flag.BoolVar(&cfg.roles, "roles", false, "pair upload-only and read-only clients with PINs of their own")
+	// HTTPS
+	flag.BoolVar(&cfg.tls, "tls", false, "serve HTTPS with a self-signed certificate")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", "", "serve HTTPS with the certificate file")
+	flag.StringVar(&cfg.tlsKey, "tls-key", "", "private key file of the certificate")
+	// S3
+	s3Keys := listFlag{}
+	flag.Var(&s3Keys, "s3-key", "serve the S3-compatible API with the access key")
+	// SFTP
+	flag.BoolVar(&cfg.sftp, "sftp", false, "serve SFTP")
+	flag.StringVar(&cfg.sftpPort, "sftp-port", defaultSFTPPort, "SFTP server port to use")
+	flag.StringVar(&cfg.sftpAuthorizedKeys, "sftp-keys", "", "sign in to SFTP with the keys of the authorized_keys file")
+	// one-shot subcommands
+	flag.IntVar(&cfg.count, "count", defaultCount, "exit after this many downloads or files")
+	flag.DurationVar(&cfg.timeout, "timeout", defaultTimeout, "exit with status 1 after this time")
+	// mDNS
+	flag.StringVar(&cfg.name, "name", defaultName, "name announced by mDNS")
+	noMDNS := flag.Bool("no-mdns", false, "do not announce the server by mDNS")
 	// build version
 	version := flag.Bool("version", false, "print the version and exit")
 	flag.BoolVar(version, "v", false, "print the version and exit")
-	// temporary directory
-	tmpfs := flag.Bool("no-tmpfs", false, "use /var/tmp for the temporary directory"+
-		" instead of tmpfs to handle large file uploads (linux systems only)")
-	flag.Parse()
+	// temporary directory, uploads are streamed into the storage
+	// directly and no longer use it, the flag is kept for compatibility
+	tmpfs := flag.Bool("no-tmpfs", false, "deprecated, uploads no longer use the temporary directory")
+	operands := parseFlag(flag.CommandLine, args)
 
 	// handle flag -v
 	if *version {
@@ -45,24 +143,193 @@ func commandLineFlag() (port *string) {
 
 	// handle flag --no-tmpfs
 	if *tmpfs {
-		if runtime.GOOS == "linux" {
-			log.Printf("INFO set '/var/tmp' as temporary directory.\n")
-			os.Setenv("TMPDIR", "/var/tmp")
-		} else {
-			log.Printf("ERROR '--no-tmpfs' flag is for linux systems only.\n")
+		log.Printf("WARN '--no-tmpfs' flag is deprecated, uploads no longer use the temporary directory.\n")
+	}
+
+	// handle subcommand serve <dir>
+	switch {
+	case cfg.serve && len(operands) != 1:
+		log.Printf("ERROR 'serve' requires exactly one directory.\n")
+		flag.Usage()
+		os.Exit(0)
+	case cfg.serve && cfg.storage != "":
+		log.Printf("ERROR 'serve' and '--storage' can not be used together.\n")
+		flag.Usage()
+		os.Exit(0)
+	case cfg.serve:
+		cfg.storage = operands[0]
+	case cfg.send && cfg.storage != "":
+		log.Printf("ERROR 'send' and '--storage' can not be used together.\n")
+		flag.Usage()
+		os.Exit(0)
+	case cfg.send && len(operands) == 0:
+		log.Printf("ERROR 'send' requires at least one file.\n")
+		flag.Usage()
+		os.Exit(0)
+	case cfg.send:
+		cfg.files = operands
+	case cfg.receive && len(operands) > 1:
+		log.Printf("ERROR 'receive' takes at most one directory.\n")
+		flag.Usage()
+		os.Exit(0)
+	case cfg.receive && (cfg.storage != "" || cfg.readOnly):
+		log.Printf("ERROR 'receive' can not be used with '--storage' or '--read-only'.\n")
+		flag.Usage()
+		os.Exit(0)
+	case cfg.receive:
+		// the current directory by default
+		cfg.storage = "."
+		if len(operands) == 1 {
+			cfg.storage = operands[0]
+		}
+	case len(operands) > 0:
+		log.Printf("ERROR unknown command '%s'.\n", operands[0])
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// validate upload sessions
+	if cfg.sessionTTL <= 0 || cfg.sessionCapacity <= 0 {
+		log.Printf("ERROR '--session-ttl' and '--session-capacity' must be positive.\n")
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// validate one-shot subcommands
+	if cfg.count <= 0 || cfg.timeout <= 0 {
+		log.Printf("ERROR '--count' and '--timeout' must be positive.\n")
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// validate own certificate
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		log.Printf("ERROR '--tls-cert' and '--tls-key' must be used together.\n")
+		flag.Usage()
+		os.Exit(0)
+	}
+	if cfg.tlsCert != "" {
+		cfg.tls = true
+	}
+
+	// validate network
+	for _, host := range cfg.hosts {
+		if _, err := netip.ParseAddr(host); err != nil {
+			log.Printf("ERROR '--host' '%s' is not an IP address.\n", host)
 			flag.Usage()
 			os.Exit(0)
 		}
 	}
+	if err := cfg.filter().Validate(); err != nil {
+		log.Printf("ERROR %s.\n", err)
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// validate mDNS name
+	cfg.mdns = !*noMDNS
+	if cfg.mdns && !mdns.ValidName(cfg.name) {
+		log.Printf("ERROR '--name' %s.\n", mdns.ErrInvalidName)
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// validate S3 keys
+	if len(s3Keys) == 0 && os.Getenv(s3KeyEnv) != "" {
+		s3Keys.Set(os.Getenv(s3KeyEnv))
+	}
+	for _, k := range s3Keys {
+		key, err := s3.ParseKey(k)
+		if err == nil && key.Role != "" {
+			if _, ok := parseRole(key.Role); !ok {
+				err = fmt.Errorf("unknown role '%s' of key '%s'", key.Role, key.AccessKeyID)
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR '--s3-key' %s.\n", err)
+			flag.Usage()
+			os.Exit(0)
+		}
+		cfg.s3Keys = append(cfg.s3Keys, key)
+	}
+
+	// validate SFTP
+	if cfg.sftpAuthorizedKeys != "" {
+		cfg.sftp = true
+	}
+	if cfg.sftp && cfg.sftpPort == cfg.port {
+		log.Printf("ERROR '--sftp-port' and '--port' must differ.\n")
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	// a password and roles imply authentication
+	if cfg.password != "" || cfg.roles {
+		cfg.auth = true
+	}
 
 	return
 }
 
+// listFlag is a flag repeated, or separated by commas, for several values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// parseFlag parses the flags and returns the operands, unlike
+// flag.Parse the flags may also follow the operands.
+func parseFlag(fs *flag.FlagSet, args []string) []string {
+	operands := []string{}
+	for {
+		// exits on error, as flag.Parse
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return operands
+		}
+		operands = append(operands, args[0])
+		args = args[1:]
+	}
+}
+
 func main() {
-	port := commandLineFlag()
+	cfg := commandLineFlag()
 
-	s := httpServer(*port)
+	s := httpServer(cfg)
+	if cfg.send {
+		s.initNetwork()
+		s.initTLS()
+		s.send()
+		return
+	}
+	if cfg.receive {
+		s.initStorage()
+		s.initNetwork()
+		s.initTLS()
+		s.receive()
+		return
+	}
 	s.initStorage()
+	s.initSessions()
+	s.initShares()
+	s.initResumable()
+	s.initS3()
+	s.initAuth()
+	s.initNetwork()
+	s.initMDNS()
+	s.initTLS()
+	s.initSFTP()
 	s.initRoutes()
 	s.run()
 }
@@ -0,0 +1,657 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package mdns announces a service on the local network by multicast DNS
+// and DNS-SD, so clients find it by name instead of by its address, see
+// RFC 6762 and RFC 6763.
+package mdns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	port = 5353
+	// hostTTL of the address and SRV records, serviceTTL of the others
+	hostTTL    = 120
+	serviceTTL = 4500
+	// cacheFlush marks a record as unique to the responder
+	cacheFlush = 1 << 15
+	// unicastResponse marks a question asking for a unicast response
+	unicastResponse = 1 << 15
+	// servicesName lists the service types of the network
+	servicesName = "_services._dns-sd._udp.local."
+	// probes sent for a name before it is owned, and their interval
+	probes        = 3
+	probeInterval = 250 * time.Millisecond
+	// maxRenames of a name in use by another host
+	maxRenames = 32
+)
+
+var (
+	groupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: port}
+	groupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: port}
+)
+
+var (
+	ErrInvalidName = errors.New("invalid name, use up to 63 letters, digits and hyphens")
+	ErrNameInUse   = errors.New("name is in use by other hosts")
+)
+
+// Service announced by the responder.
+type Service struct {
+	// Name of the instance, the host is answered as <Name>.local too
+	Name string
+	Port int
+	// Types of the service, e.g. _http._tcp
+	Types []string
+	// Text of the TXT record, key=value pairs
+	Text []string
+}
+
+// ValidName reports whether the name is a single DNS label of letters,
+// digits and hyphens.
+func ValidName(name string) bool {
+	if name == "" || len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Responder answers the multicast DNS queries of the service and its host.
+type Responder struct {
+	svc    Service
+	ifaces []net.Interface
+	conns  []*conn
+
+	mu       sync.Mutex
+	name     string
+	probing  bool
+	conflict bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Listen joins the multicast groups on the interfaces, probes the name of
+// the service and announces it. A name in use by another host is renamed
+// to <name>-2, <name>-3 and so on, see Name.
+func Listen(svc Service, ifaces []net.Interface) (*Responder, error) {
+	if !ValidName(svc.Name) {
+		return nil, ErrInvalidName
+	}
+	r := newResponder(svc)
+	r.ifaces = ifaces
+
+	errs := []error{}
+	for _, listen := range []func([]net.Interface) (*conn, error){listen4, listen6} {
+		c, err := listen(ifaces)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.conns = append(r.conns, c)
+	}
+	if len(r.conns) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, c := range r.conns {
+		r.wg.Add(1)
+		go r.serve(c)
+	}
+
+	if err := r.probe(); err != nil {
+		r.shutdown()
+		return nil, err
+	}
+	r.wg.Add(1)
+	go r.announce()
+	return r, nil
+}
+
+func newResponder(svc Service) *Responder {
+	if len(svc.Text) == 0 {
+		// an empty TXT record holds a single empty string
+		svc.Text = []string{""}
+	}
+	return &Responder{svc: svc, name: svc.Name, done: make(chan struct{})}
+}
+
+// Name returns the name owned by the responder, which differs
+// from the name of the service after a conflict.
+func (r *Responder) Name() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.name
+}
+
+// Close sends the goodbye of the records, so clients remove
+// them from their caches, and leaves the multicast groups.
+func (r *Responder) Close() error {
+	r.closeOnce.Do(func() {
+		r.multicast(func(addrs []net.IP) dnsmessage.Message {
+			m := r.announcement(addrs)
+			for _, rs := range [][]dnsmessage.Resource{m.Answers, m.Additionals} {
+				for i := range rs {
+					rs[i].Header.TTL = 0
+				}
+			}
+			return m
+		})
+		r.shutdown()
+	})
+	return nil
+}
+
+func (r *Responder) shutdown() {
+	close(r.done)
+	for _, c := range r.conns {
+		c.udp.Close()
+	}
+	r.wg.Wait()
+}
+
+// probe queries the name until no other host answers it.
+func (r *Responder) probe() error {
+	for i := 2; i <= maxRenames+1; i++ {
+		r.mu.Lock()
+		r.probing, r.conflict = true, false
+		r.mu.Unlock()
+
+		for range probes {
+			r.multicast(func([]net.IP) dnsmessage.Message { return r.probeQuery() })
+			select {
+			case <-r.done:
+				return net.ErrClosed
+			case <-time.After(probeInterval):
+			}
+		}
+
+		r.mu.Lock()
+		r.probing = false
+		conflict := r.conflict
+		if conflict {
+			r.name = fmt.Sprintf("%s-%d", r.svc.Name, i)
+		}
+		r.mu.Unlock()
+		if !conflict {
+			return nil
+		}
+	}
+	return ErrNameInUse
+}
+
+// announce sends the records unsolicited twice, a second apart.
+func (r *Responder) announce() {
+	defer r.wg.Done()
+	for range 2 {
+		r.multicast(r.announcement)
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// serve answers the queries received by the connection.
+func (r *Responder) serve(c *conn) {
+	defer r.wg.Done()
+	b := make([]byte, 9000)
+	for {
+		n, ifIndex, src, err := c.read(b)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		r.handle(c, b[:n], ifIndex, src)
+	}
+}
+
+func (r *Responder) handle(c *conn, b []byte, ifIndex int, src *net.UDPAddr) {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		return
+	}
+	if m.Header.Response {
+		r.checkConflict(m)
+		return
+	}
+
+	r.mu.Lock()
+	probing := r.probing
+	r.mu.Unlock()
+	if probing {
+		return
+	}
+
+	resp, unicast := r.response(m, r.addrs(ifIndex))
+	if len(resp.Answers) == 0 {
+		return
+	}
+	// a legacy resolver, not listening on the mDNS port, expects
+	// a conventional DNS response
+	if src.Port != port {
+		unicast = true
+		resp.Header.ID = m.Header.ID
+		resp.Questions = m.Questions
+		for _, rs := range [][]dnsmessage.Resource{resp.Answers, resp.Additionals} {
+			for i := range rs {
+				rs[i].Header.Class &^= cacheFlush
+				rs[i].Header.TTL = min(rs[i].Header.TTL, 10)
+			}
+		}
+	}
+
+	b, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	if unicast {
+		c.write(b, ifIndex, src)
+		return
+	}
+	c.write(b, ifIndex, c.group)
+}
+
+// checkConflict records a response of another host to the probed name.
+func (r *Responder) checkConflict(m dnsmessage.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.probing {
+		return
+	}
+	names := r.ownNames()
+	for _, rs := range [][]dnsmessage.Resource{m.Answers, m.Additionals} {
+		for _, res := range rs {
+			for _, name := range names {
+				if strings.EqualFold(res.Header.Name.String(), name) {
+					r.conflict = true
+				}
+			}
+		}
+	}
+}
+
+// response answers the questions of the query with the records of the
+// addresses, unicast reports whether every answered question asked for
+// a unicast response.
+func (r *Responder) response(m dnsmessage.Message, addrs []net.IP) (dnsmessage.Message, bool) {
+	resp := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	unicast := true
+	for _, q := range m.Questions {
+		class := q.Class &^ unicastResponse
+		if class != dnsmessage.ClassINET && class != dnsmessage.ClassANY {
+			continue
+		}
+		answers, additionals := r.answer(q, addrs)
+		if len(answers) > 0 && q.Class&unicastResponse == 0 {
+			unicast = false
+		}
+		resp.Answers = appendUnique(resp.Answers, answers...)
+		resp.Additionals = appendUnique(resp.Additionals, additionals...)
+	}
+
+	// additional records already answered are left out
+	additionals := []dnsmessage.Resource{}
+	for _, res := range resp.Additionals {
+		if !containsRecord(resp.Answers, res) {
+			additionals = append(additionals, res)
+		}
+	}
+	resp.Additionals = additionals
+	return resp, unicast
+}
+
+// answer returns the records answering the question,
+// and the additional records a client likely asks next.
+func (r *Responder) answer(q dnsmessage.Question, addrs []net.IP) (answers, additionals []dnsmessage.Resource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := q.Name.String()
+	if strings.EqualFold(name, r.hostName()) {
+		return r.hostRecords(addrs, q.Type), nil
+	}
+	if strings.EqualFold(name, servicesName) && matchType(q.Type, dnsmessage.TypePTR) {
+		for _, t := range r.svc.Types {
+			answers = append(answers, pointer(servicesName, t+".local."))
+		}
+		return answers, nil
+	}
+	for _, t := range r.svc.Types {
+		switch {
+		case strings.EqualFold(name, t+".local.") && matchType(q.Type, dnsmessage.TypePTR):
+			answers = append(answers, pointer(t+".local.", r.instanceName(t)))
+			additionals = append(additionals, r.serviceRecords(t, dnsmessage.TypeALL)...)
+			additionals = append(additionals, r.hostRecords(addrs, dnsmessage.TypeALL)...)
+		case strings.EqualFold(name, r.instanceName(t)):
+			answers = append(answers, r.serviceRecords(t, q.Type)...)
+			if matchType(q.Type, dnsmessage.TypeSRV) {
+				additionals = append(additionals, r.hostRecords(addrs, dnsmessage.TypeALL)...)
+			}
+		}
+	}
+	return answers, additionals
+}
+
+// announcement returns the unsolicited response of every record.
+func (r *Responder) announcement(addrs []net.IP) dnsmessage.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	for _, t := range r.svc.Types {
+		m.Answers = append(m.Answers,
+			pointer(servicesName, t+".local."),
+			pointer(t+".local.", r.instanceName(t)))
+		m.Answers = append(m.Answers, r.serviceRecords(t, dnsmessage.TypeALL)...)
+	}
+	m.Answers = append(m.Answers, r.hostRecords(addrs, dnsmessage.TypeALL)...)
+	return m
+}
+
+// probeQuery returns the query of the names the responder is about to own.
+func (r *Responder) probeQuery() dnsmessage.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := dnsmessage.Message{}
+	for _, name := range r.ownNames() {
+		m.Questions = append(m.Questions, dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeALL,
+			Class: dnsmessage.ClassINET | unicastResponse,
+		})
+	}
+	return m
+}
+
+// ownNames returns the host name and the instance names, r.mu is held.
+func (r *Responder) ownNames() []string {
+	names := []string{r.hostName()}
+	for _, t := range r.svc.Types {
+		names = append(names, r.instanceName(t))
+	}
+	return names
+}
+
+func (r *Responder) hostName() string {
+	return r.name + ".local."
+}
+
+func (r *Responder) instanceName(serviceType string) string {
+	return r.name + "." + serviceType + ".local."
+}
+
+// serviceRecords returns the SRV and TXT records of the service type.
+func (r *Responder) serviceRecords(serviceType string, qtype dnsmessage.Type) []dnsmessage.Resource {
+	name := dnsmessage.MustNewName(r.instanceName(serviceType))
+	rs := []dnsmessage.Resource{}
+	if matchType(qtype, dnsmessage.TypeSRV) {
+		rs = append(rs, dnsmessage.Resource{
+			Header: header(name, dnsmessage.TypeSRV, hostTTL, true),
+			Body: &dnsmessage.SRVResource{
+				Port:   uint16(r.svc.Port),
+				Target: dnsmessage.MustNewName(r.hostName()),
+			},
+		})
+	}
+	if matchType(qtype, dnsmessage.TypeTXT) {
+		rs = append(rs, dnsmessage.Resource{
+			Header: header(name, dnsmessage.TypeTXT, serviceTTL, true),
+			Body:   &dnsmessage.TXTResource{TXT: r.svc.Text},
+		})
+	}
+	return rs
+}
+
+// hostRecords returns the A and AAAA records of the addresses.
+func (r *Responder) hostRecords(addrs []net.IP, qtype dnsmessage.Type) []dnsmessage.Resource {
+	name := dnsmessage.MustNewName(r.hostName())
+	rs := []dnsmessage.Resource{}
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			if matchType(qtype, dnsmessage.TypeA) {
+				rs = append(rs, dnsmessage.Resource{
+					Header: header(name, dnsmessage.TypeA, hostTTL, true),
+					Body:   &dnsmessage.AResource{A: [4]byte(ip4)},
+				})
+			}
+		} else if ip16 := ip.To16(); ip16 != nil && matchType(qtype, dnsmessage.TypeAAAA) {
+			rs = append(rs, dnsmessage.Resource{
+				Header: header(name, dnsmessage.TypeAAAA, hostTTL, true),
+				Body:   &dnsmessage.AAAAResource{AAAA: [16]byte(ip16)},
+			})
+		}
+	}
+	return rs
+}
+
+// addrs returns the addresses of the interface, or of every interface
+// when the interface of a query is unknown.
+func (r *Responder) addrs(ifIndex int) []net.IP {
+	ips := []net.IP{}
+	for _, iface := range r.ifaces {
+		if ifIndex != 0 && iface.Index != ifIndex {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	if len(ips) == 0 && ifIndex != 0 {
+		// e.g. a query of the host itself, on the loopback interface
+		return r.addrs(0)
+	}
+	return ips
+}
+
+// multicast sends the message built of the addresses of each interface.
+func (r *Responder) multicast(build func(addrs []net.IP) dnsmessage.Message) {
+	for _, c := range r.conns {
+		for _, iface := range c.ifaces {
+			m := build(r.addrs(iface.Index))
+			if b, err := m.Pack(); err == nil {
+				c.write(b, iface.Index, c.group)
+			}
+		}
+	}
+}
+
+func pointer(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: header(dnsmessage.MustNewName(name), dnsmessage.TypePTR, serviceTTL, false),
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)},
+	}
+}
+
+// header returns the resource header, unique records flush the caches
+// of the clients.
+func header(name dnsmessage.Name, t dnsmessage.Type, ttl uint32, unique bool) dnsmessage.ResourceHeader {
+	class := dnsmessage.ClassINET
+	if unique {
+		class |= cacheFlush
+	}
+	return dnsmessage.ResourceHeader{Name: name, Type: t, Class: class, TTL: ttl}
+}
+
+func matchType(qtype, t dnsmessage.Type) bool {
+	return qtype == t || qtype == dnsmessage.TypeALL
+}
+
+func containsRecord(rs []dnsmessage.Resource, res dnsmessage.Resource) bool {
+	for _, r := range rs {
+		if r.GoString() == res.GoString() {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(rs []dnsmessage.Resource, more ...dnsmessage.Resource) []dnsmessage.Resource {
+	for _, res := range more {
+		if !containsRecord(rs, res) {
+			rs = append(rs, res)
+		}
+	}
+	return rs
+}
+
+// conn is the multicast connection of an IP version.
+type conn struct {
+	group  *net.UDPAddr
+	udp    *net.UDPConn
+	ifaces []net.Interface
+	// either of the packet connections is set
+	v4 *ipv4.PacketConn
+	v6 *ipv6.PacketConn
+	// mu serializes the outgoing interface and the write
+	mu sync.Mutex
+}
+
+func listen4(ifaces []net.Interface) (*conn, error) {
+	ifaces = multicastInterfaces(ifaces, func(ip net.IP) bool { return ip.To4() != nil })
+	if len(ifaces) == 0 {
+		return nil, errors.New("mdns: no IPv4 multicast interface")
+	}
+	udp, err := net.ListenMulticastUDP("udp4", &ifaces[0], groupIPv4)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{group: groupIPv4, udp: udp, v4: ipv4.NewPacketConn(udp)}
+	c.ifaces = append(c.ifaces, ifaces[0])
+	for _, iface := range ifaces[1:] {
+		if err := c.v4.JoinGroup(&iface, groupIPv4); err == nil {
+			c.ifaces = append(c.ifaces, iface)
+		}
+	}
+	// not supported on every platform, the interface
+	// of a query is then unknown
+	c.v4.SetControlMessage(ipv4.FlagInterface, true)
+	c.v4.SetMulticastTTL(255)
+	c.v4.SetMulticastLoopback(true)
+	return c, nil
+}
+
+func listen6(ifaces []net.Interface) (*conn, error) {
+	ifaces = multicastInterfaces(ifaces, func(ip net.IP) bool { return ip.To4() == nil })
+	if len(ifaces) == 0 {
+		return nil, errors.New("mdns: no IPv6 multicast interface")
+	}
+	udp, err := net.ListenMulticastUDP("udp6", &ifaces[0], groupIPv6)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{group: groupIPv6, udp: udp, v6: ipv6.NewPacketConn(udp)}
+	c.ifaces = append(c.ifaces, ifaces[0])
+	for _, iface := range ifaces[1:] {
+		if err := c.v6.JoinGroup(&iface, groupIPv6); err == nil {
+			c.ifaces = append(c.ifaces, iface)
+		}
+	}
+	c.v6.SetControlMessage(ipv6.FlagInterface, true)
+	c.v6.SetMulticastHopLimit(255)
+	c.v6.SetMulticastLoopback(true)
+	return c, nil
+}
+
+// multicastInterfaces returns the multicast interfaces with an address
+// of the IP version.
+func multicastInterfaces(ifaces []net.Interface, version func(net.IP) bool) []net.Interface {
+	filtered := []net.Interface{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && version(ipNet.IP) {
+				filtered = append(filtered, iface)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func (c *conn) read(b []byte) (n int, ifIndex int, src *net.UDPAddr, err error) {
+	var addr net.Addr
+	if c.v4 != nil {
+		var cm *ipv4.ControlMessage
+		n, cm, addr, err = c.v4.ReadFrom(b)
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, addr, err = c.v6.ReadFrom(b)
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	src, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, 0, nil, errors.New("mdns: unexpected source address")
+	}
+	return n, ifIndex, src, nil
+}
+
+// write sends the message to the destination, a multicast message
+// leaves through the interface, or through every interface when the
+// interface is unknown.
+func (c *conn) write(b []byte, ifIndex int, dst *net.UDPAddr) {
+	if dst != c.group {
+		c.udp.WriteToUDP(b, dst)
+		return
+	}
+
+	if !slices.ContainsFunc(c.ifaces, func(iface net.Interface) bool { return iface.Index == ifIndex }) {
+		ifIndex = 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, iface := range c.ifaces {
+		if ifIndex != 0 && iface.Index != ifIndex {
+			continue
+		}
+		if c.v4 != nil {
+			c.v4.SetMulticastInterface(&iface)
+		} else {
+			c.v6.SetMulticastInterface(&iface)
+		}
+		c.udp.WriteToUDP(b, dst)
+	}
+}
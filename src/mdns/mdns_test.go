@@ -0,0 +1,158 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestValidName(t *testing.T) {
+	tcs := []struct {
+		name     string
+		expected bool
+	}{
+		{"localfs", true},
+		{"localfs-2", true},
+		{"LocalFS", true},
+		{"", false},
+		{"-localfs", false},
+		{"local.fs", false},
+		{"local fs", false},
+		{"localfs-ฟู", false},
+	}
+
+	for _, tc := range tcs {
+		if actual := ValidName(tc.name); actual != tc.expected {
+			t.Errorf("\nTest Data: (%s)\nExpected: %t\nActual: %t", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	r := newResponder(Service{Name: "localfs", Port: 5000, Types: []string{"_http._tcp", "_localfs._tcp"}})
+	addrs := []net.IP{net.ParseIP("192.168.1.5"), net.ParseIP("fe80::1")}
+
+	// query returns the query of the question
+	query := func(name string, qtype dnsmessage.Type) dnsmessage.Message {
+		return dnsmessage.Message{Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}}}
+	}
+
+	// initialize testcases
+	tcs := []struct {
+		title       string
+		name        string
+		qtype       dnsmessage.Type
+		answers     []dnsmessage.Type
+		additionals []dnsmessage.Type
+	}{
+		{"Browse Service Types", "_services._dns-sd._udp.local.", dnsmessage.TypePTR,
+			[]dnsmessage.Type{dnsmessage.TypePTR, dnsmessage.TypePTR}, nil},
+		{"Browse Service", "_http._tcp.local.", dnsmessage.TypePTR,
+			[]dnsmessage.Type{dnsmessage.TypePTR},
+			[]dnsmessage.Type{dnsmessage.TypeSRV, dnsmessage.TypeTXT, dnsmessage.TypeA, dnsmessage.TypeAAAA}},
+		{"Resolve Instance", "localfs._localfs._tcp.local.", dnsmessage.TypeSRV,
+			[]dnsmessage.Type{dnsmessage.TypeSRV},
+			[]dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}},
+		{"Host Address", "LocalFS.local.", dnsmessage.TypeA,
+			[]dnsmessage.Type{dnsmessage.TypeA}, nil},
+		{"Host Any", "localfs.local.", dnsmessage.TypeALL,
+			[]dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}, nil},
+		{"Other Host", "other.local.", dnsmessage.TypeA, nil, nil},
+		{"Other Service", "_ftp._tcp.local.", dnsmessage.TypePTR, nil, nil},
+	}
+
+	types := func(rs []dnsmessage.Resource) []dnsmessage.Type {
+		ts := []dnsmessage.Type{}
+		for _, res := range rs {
+			ts = append(ts, res.Header.Type)
+		}
+		return ts
+	}
+	equal := func(a, b []dnsmessage.Type) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.title, func(t *testing.T) {
+			resp, unicast := r.response(query(tc.name, tc.qtype), addrs)
+			if !equal(types(resp.Answers), tc.answers) || !equal(types(resp.Additionals), tc.additionals) {
+				t.Errorf("\nExpected: %v %v\nActual: %v %v",
+					tc.answers, tc.additionals, types(resp.Answers), types(resp.Additionals))
+			}
+			if len(tc.answers) > 0 && unicast {
+				t.Errorf("\nExpected: multicast response\nActual: unicast response")
+			}
+			if _, err := resp.Pack(); err != nil {
+				t.Errorf("\nError: %s", err)
+			}
+		})
+	}
+
+	t.Run("Service Record Points To The Host", func(t *testing.T) {
+		resp, _ := r.response(query("localfs._http._tcp.local.", dnsmessage.TypeSRV), addrs)
+		srv := resp.Answers[0].Body.(*dnsmessage.SRVResource)
+		if srv.Target.String() != "localfs.local." || srv.Port != 5000 {
+			t.Errorf("\nExpected: %s:%d\nActual: %s:%d", "localfs.local.", 5000, srv.Target, srv.Port)
+		}
+	})
+}
+
+func TestConflict(t *testing.T) {
+	r := newResponder(Service{Name: "localfs", Port: 5000, Types: []string{"_http._tcp"}})
+	other := newResponder(Service{Name: "localfs", Port: 5001, Types: []string{"_http._tcp"}})
+	resp := other.announcement([]net.IP{net.ParseIP("192.168.1.6")})
+
+	// answers of other hosts are conflicts only while probing
+	r.checkConflict(resp)
+	if r.conflict {
+		t.Errorf("\nExpected: no conflict after probing\nActual: conflict")
+	}
+	r.probing = true
+	r.checkConflict(resp)
+	if !r.conflict {
+		t.Errorf("\nExpected: conflict while probing\nActual: no conflict")
+	}
+
+}
+
+func TestListen(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	svc := Service{Name: "localfs-test", Port: 5000, Types: []string{"_localfs._tcp"}}
+	r, err := Listen(svc, ifaces)
+	if err != nil {
+		t.Skip("multicast is not available: ", err)
+	}
+	defer r.Close()
+
+	// the second responder of the name is renamed
+	svc.Port = 5001
+	other, err := Listen(svc, ifaces)
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	defer other.Close()
+	if r.Name() != "localfs-test" || other.Name() != "localfs-test-2" {
+		t.Errorf("\nExpected: %s %s\nActual: %s %s", "localfs-test", "localfs-test-2", r.Name(), other.Name())
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+// openAPIDocument describes the JSON API, served at /api/v1/openapi.json.
+const openAPIDocument string = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "localFS API",
+    "description": "List, upload, download and delete files of a localFS storage.",
+    "version": "1"
+  },
+  "servers": [
+    {"url": "/api/v1"}
+  ],
+  "paths": {
+    "/files": {
+      "get": {
+        "summary": "List the files and folders of a folder",
+        "operationId": "listFiles",
+        "parameters": [
+          {"$ref": "#/components/parameters/Dir"},
+          {
+            "name": "hash",
+            "in": "query",
+            "description": "Set to 1 to compute the checksums of the files, otherwise only checksums already computed are listed.",
+            "schema": {"type": "string", "enum": ["0", "1"], "default": "0"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Folders sorted by name, followed by files sorted by latest modified time.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Listing"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Upload files into a folder",
+        "description": "Every multipart part named 'file' is stored, a conflicting name is resolved to the next available 'name(n).ext'.",
+        "operationId": "uploadFiles",
+        "parameters": [
+          {"$ref": "#/components/parameters/Dir"}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "file": {"type": "array", "items": {"type": "string", "format": "binary"}}
+                },
+                "required": ["file"]
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "The stored files.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Listing"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/files/{path}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/Path"}
+      ],
+      "get": {
+        "summary": "Get the metadata of a file or folder",
+        "operationId": "getFile",
+        "responses": {
+          "200": {
+            "description": "The file metadata.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/File"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a file, or a folder and everything it contains",
+        "operationId": "deleteFile",
+        "responses": {
+          "204": {"description": "Deleted."},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/download/{path}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/Path"}
+      ],
+      "get": {
+        "summary": "Download a file",
+        "description": "Supports range and conditional requests.",
+        "operationId": "downloadFile",
+        "responses": {
+          "200": {
+            "description": "The file content.",
+            "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+          },
+          "206": {"description": "The requested range of the file content."},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "operationId": "getOpenAPI",
+        "responses": {
+          "200": {"description": "The OpenAPI document.", "content": {"application/json": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "Dir": {
+        "name": "dir",
+        "in": "query",
+        "description": "Slash-separated folder path relative to the storage, the storage when empty.",
+        "schema": {"type": "string", "default": ""}
+      },
+      "Path": {
+        "name": "path",
+        "in": "path",
+        "required": true,
+        "description": "Slash-separated path relative to the storage, each segment URL path escaped.",
+        "schema": {"type": "string"}
+      }
+    },
+    "schemas": {
+      "File": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "path": {"type": "string"},
+          "type": {"type": "string", "enum": ["file", "dir"]},
+          "size": {"type": "integer", "format": "int64"},
+          "mtime": {"type": "string", "format": "date-time"},
+          "sha256": {"type": "string", "description": "Hex encoded SHA-256 checksum, files only. Always given by the metadata, and by a listing with hash=1."},
+          "download": {"type": "string", "description": "Download URL path, files only."}
+        },
+        "required": ["name", "path", "type", "size", "mtime"]
+      },
+      "Listing": {
+        "type": "object",
+        "properties": {
+          "dir": {"type": "string"},
+          "files": {"type": "array", "items": {"$ref": "#/components/schemas/File"}}
+        },
+        "required": ["dir", "files"]
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "object",
+            "properties": {
+              "code": {"type": "integer"},
+              "status": {"type": "string"},
+              "message": {"type": "string"}
+            },
+            "required": ["code", "status", "message"]
+          }
+        },
+        "required": ["error"]
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "The error.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  }
+}
+`
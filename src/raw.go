@@ -0,0 +1,115 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"localfs/util/fsutil"
+	"localfs/view"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// conflict policies of the raw upload, set by the X-Conflict header
+const (
+	conflictRename    string = "rename"
+	conflictOverwrite string = "overwrite"
+	conflictFail      string = "fail"
+)
+
+// rawUploadHandler stores the request body as the file at the path, e.g.
+//
+//	curl -T photo.jpg http://localhost:5000/files/
+//	tar cz docs | curl -T - http://localhost:5000/files/docs.tar.gz
+//
+// and replies with a receipt of the stored file, as JSON when the
+// request accepts application/json.
+func rawUploadHandler(w http.ResponseWriter, r *http.Request) {
+	asJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+	fail := func(code int, message string) {
+		if asJSON {
+			apiErrorHandler(w, message, code)
+			return
+		}
+		http.Error(w, message, code)
+	}
+
+	rel := strings.Trim(r.PathValue("path"), "/")
+	dir, name := path.Split(rel)
+	dir = cleanDir(dir)
+	if name == "" {
+		fail(http.StatusBadRequest, fsutil.ErrInvalidFileName.Error())
+		return
+	}
+
+	policy := strings.ToLower(r.Header.Get("X-Conflict"))
+	if policy == "" {
+		policy = conflictRename
+	}
+
+	fpath, err := storageDir(dir)
+	if err != nil {
+		fail(storageError(err))
+		return
+	}
+
+	var size int64
+	var hash string
+	switch policy {
+	case conflictRename:
+		name, size, hash, err = fsutil.SaveStream(fpath, name, r.Body)
+	case conflictFail:
+		size, hash, err = fsutil.SaveStreamExclusive(fpath, name, r.Body)
+	case conflictOverwrite:
+		// replacing a file deletes it
+		if !requestRole(r).can(permModify) {
+			fail(http.StatusForbidden, "overwrite requires the admin role.")
+			return
+		}
+		// folders are never replaced
+		if info, serr := os.Lstat(filepath.Join(fpath, name)); serr == nil && info.IsDir() {
+			fail(http.StatusConflict, "a folder with the same name exists.")
+			return
+		}
+		size, hash, err = fsutil.WriteStreamToFileSha256(fpath, name, r.Body)
+	default:
+		fail(http.StatusBadRequest, fmt.Sprintf("unknown conflict policy '%s', use %s, %s or %s.",
+			policy, conflictRename, conflictOverwrite, conflictFail))
+		return
+	}
+	if err != nil {
+		fail(storageError(err))
+		return
+	}
+
+	stored := filepath.Join(fpath, name)
+	info, err := os.Stat(stored)
+	if err != nil {
+		fail(storageError(err))
+		return
+	}
+	hashCache.Put(stored, info, hash)
+
+	rel = path.Join(dir, name)
+	w.Header().Set("Location", view.DownloadLink(rel))
+	if asJSON {
+		f, err := newAPIFile(rel, stored, info, false)
+		if err != nil {
+			fail(http.StatusInternalServerError, err.Error())
+			return
+		}
+		apiWriteJSON(w, f, http.StatusCreated)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "file: %s\nsize: %d\nhash: %s\n", rel, size, hash)
+}
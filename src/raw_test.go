@@ -0,0 +1,68 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRawUpload(t *testing.T) {
+	root := setupStorage(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /files/{path...}", rawUploadHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	put := func(name, content, policy string) (int, string) {
+		r, _ := http.NewRequest(http.MethodPut, srv.URL+"/files/"+name, strings.NewReader(content))
+		if policy != "" {
+			r.Header.Set("X-Conflict", policy)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		defer res.Body.Close()
+		inbyte, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(inbyte)
+	}
+
+	// initialize testcases
+	tcs := []struct {
+		policy   string
+		content  string
+		expected int
+		receipt  string
+	}{
+		{"", "Fuiyoh!!", http.StatusCreated, "file: test_file\nsize: 8\n"},
+		{"rename", "Fuiyoh!!", http.StatusCreated, "file: test_file(1)\nsize: 8\n"},
+		{"fail", "Haiyaa!!", http.StatusConflict, ""},
+		{"overwrite", "Haiyaa!!", http.StatusCreated, "file: test_file\nsize: 8\n"},
+		{"unknown", "Haiyaa!!", http.StatusBadRequest, ""},
+	}
+
+	t.Run("Conflict Policies", func(t *testing.T) {
+		for _, tc := range tcs {
+			code, receipt := put("test_file", tc.content, tc.policy)
+			if code != tc.expected || !strings.HasPrefix(receipt, tc.receipt) {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d %q\nActual: %d %q",
+					tc.policy, tc.expected, tc.receipt, code, receipt)
+			}
+		}
+
+		inbyte, _ := os.ReadFile(filepath.Join(root, "test_file"))
+		if string(inbyte) != "Haiyaa!!" {
+			t.Errorf("\nExpected: Haiyaa!!\nActual: %s", inbyte)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"localfs/view"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// receivePrefix is the route of the upload page, followed by the token.
+const receivePrefix = "/receive/"
+
+// receiver stores the files uploaded to 'localfs receive' into the
+// storage, and prints the stored path and SHA-256 of every file to
+// the output, in the format of sha256sum.
+type receiver struct {
+	token string
+	count int
+	out   io.Writer
+
+	mu sync.Mutex
+	// stored files, and the uploads in progress
+	received int
+	reserved int
+	// closed once the files are received
+	done chan struct{}
+}
+
+func newReceiver(count int, out io.Writer) (*receiver, error) {
+	rnd := make([]byte, 18)
+	if _, err := rand.Read(rnd); err != nil {
+		return nil, err
+	}
+	return &receiver{
+		token: base64.RawURLEncoding.EncodeToString(rnd),
+		count: count,
+		out:   out,
+		done:  make(chan struct{}),
+	}, nil
+}
+
+// routes registers the upload page and the uploads, a browser form
+// or curl -F posts the files, curl -T puts a file.
+func (rc *receiver) routes(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+receivePrefix+"{token}", rc.pageHandler)
+	mux.HandleFunc("POST "+receivePrefix+"{token}", rc.formHandler)
+	mux.HandleFunc("PUT "+receivePrefix+"{token}/{name}", rc.rawHandler)
+}
+
+// valid reports whether the token of the request is the token
+// of the receiver, and rejects the request otherwise.
+func (rc *receiver) valid(w http.ResponseWriter, r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.PathValue("token")), []byte(rc.token)) != 1 {
+		http.NotFound(w, r)
+		return false
+	}
+	return true
+}
+
+// reserve reserves one of the files expected, so concurrent
+// uploads never exceed the count.
+func (rc *receiver) reserve() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.received+rc.reserved >= rc.count {
+		return false
+	}
+	rc.reserved++
+	return true
+}
+
+// release ends the reservation of the upload, a stored file is
+// printed and counted as received.
+func (rc *receiver) release(fi fileInfo) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.reserved--
+	if fi.err != "" {
+		return
+	}
+	rc.received++
+	stored := filepath.Join(appCache[ckey_storage], filepath.FromSlash(fi.relPath()))
+	fmt.Fprintf(rc.out, "%s  %s\n", fi.hash, stored)
+	if rc.received == rc.count {
+		close(rc.done)
+	}
+}
+
+func (rc *receiver) remaining() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.count - rc.received
+}
+
+// receive stores the stream as the file name, unless every
+// expected file is already received or being uploaded.
+func (rc *receiver) receive(name string, stream io.Reader) fileInfo {
+	if !rc.reserve() {
+		return fileInfo{name: name, err: "no more files are expected."}
+	}
+	fi := saveUpload("", appCache[ckey_storage], name, stream)
+	rc.release(fi)
+	if fi.err == "" {
+		log.Printf("INFO '%s' received (%d bytes).\n", fi.relPath(), fi.size)
+	}
+	return fi
+}
+
+func (rc *receiver) pageHandler(w http.ResponseWriter, r *http.Request) {
+	if !rc.valid(w, r) {
+		return
+	}
+	rc.render(w, nil, http.StatusOK)
+}
+
+// formHandler stores the file parts of the multipart form, the result
+// is rendered to a browser and printed as text to other clients.
+func (rc *receiver) formHandler(w http.ResponseWriter, r *http.Request) {
+	if !rc.valid(w, r) {
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files := []fileInfo{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			files = append(files, fileInfo{err: "upload interrupted: " + err.Error()})
+			break
+		}
+		name := partFileName(part)
+		if part.FormName() != "file" || name == "" {
+			part.Close()
+			continue
+		}
+		files = append(files, rc.receive(name, part))
+		part.Close()
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		rc.render(w, files, http.StatusOK)
+		return
+	}
+	code := http.StatusCreated
+	if len(files) == 0 {
+		code = http.StatusBadRequest
+		files = append(files, fileInfo{err: http.ErrMissingFile.Error()})
+	}
+	writeReceived(w, files, code)
+}
+
+// rawHandler stores the request body as the file name.
+func (rc *receiver) rawHandler(w http.ResponseWriter, r *http.Request) {
+	if !rc.valid(w, r) {
+		return
+	}
+	fi := rc.receive(r.PathValue("name"), r.Body)
+	writeReceived(w, []fileInfo{fi}, http.StatusCreated)
+}
+
+// writeReceived writes the received files as the raw upload does,
+// the status is a conflict when a file failed.
+func writeReceived(w http.ResponseWriter, files []fileInfo, code int) {
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	for _, fi := range files {
+		if fi.err != "" && code == http.StatusCreated {
+			code = http.StatusConflict
+		}
+	}
+	w.WriteHeader(code)
+	for _, fi := range files {
+		if fi.err != "" {
+			fmt.Fprintf(w, "file: %s\nerror: %s\n", fi.name, fi.err)
+			continue
+		}
+		fmt.Fprintf(w, "file: %s\nsize: %d\nhash: %s\n", fi.relPath(), fi.size, fi.hash)
+	}
+}
+
+// render renders the upload page with the result of the files.
+func (rc *receiver) render(w http.ResponseWriter, files []fileInfo, code int) {
+	vm := view.ReceivePageViewModel{Token: rc.token, Remaining: rc.remaining()}
+	for _, fi := range files {
+		if fi.err != "" {
+			vm.Message = fmt.Sprintf("%s: %s", fi.name, fi.err)
+			continue
+		}
+		vm.Files = append(vm.Files, fi.relPath())
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+
+	t, err := template.New("receivePage").Parse(view.ReceivePageTmpl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	t.Execute(w, vm)
+}
+
+// receive serves the upload page until the count of files is received,
+// or the timeout passes, then exits, with status 1 on timeout. The QR
+// code is printed to stderr, so stdout lists the received files only.
+func (s *server) receive() {
+	appCache[ckey_port] = s.port
+	rc, err := newReceiver(s.count, os.Stdout)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+
+	mux := http.NewServeMux()
+	rc.routes(mux)
+	deadline := time.Now().Add(s.timeout)
+	log.Printf("INFO receiving %d file(s) until %s.\n", s.count, deadline.Format("15:04:05"))
+	if !s.serveOnce(mux, receivePrefix+rc.token, os.Stderr, rc.done, deadline) {
+		log.Printf("WARN timed out after %s, %d of %d file(s) received.\n",
+			s.timeout, s.count-rc.remaining(), s.count)
+		os.Exit(1)
+	}
+	log.Printf("INFO %d file(s) received.\n", s.count)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReceive(t *testing.T) {
+	root := setupStorage(t)
+	os.WriteFile(filepath.Join(root, "test_file.txt"), []byte("Fuiyoh!!"), 0644)
+
+	out := &bytes.Buffer{}
+	rc, err := newReceiver(3, out)
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	mux := http.NewServeMux()
+	rc.routes(mux)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	form := func(names ...string) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for _, name := range names {
+			fw, _ := mw.CreateFormFile("file", name)
+			fw.Write([]byte("Haiyaa!!"))
+		}
+		mw.Close()
+		return body, mw.FormDataContentType()
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("Haiyaa!!")))
+
+	t.Run("Unknown Token", func(t *testing.T) {
+		if w := serve(httptest.NewRequest(http.MethodGet, receivePrefix+"Fuiyoh", nil)); w.Code != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, w.Code)
+		}
+		r := httptest.NewRequest(http.MethodPut, receivePrefix+"Fuiyoh/test_file.txt", strings.NewReader("Haiyaa!!"))
+		if w := serve(r); w.Code != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Upload Keeps Existing Files", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, receivePrefix+rc.token+"/test_file.txt", strings.NewReader("Haiyaa!!"))
+		w := serve(r)
+		if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), "file: test_file(1).txt") {
+			t.Errorf("\nExpected: %d test_file(1).txt\nActual: %d %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		if b, _ := os.ReadFile(filepath.Join(root, "test_file.txt")); string(b) != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!", b)
+		}
+	})
+
+	t.Run("Control Characters Are Rejected", func(t *testing.T) {
+		// a newline would print a line of its own
+		r := httptest.NewRequest(http.MethodPut, receivePrefix+rc.token+"/test_file%0A"+hash+"%20%20forged_file", strings.NewReader("Haiyaa!!"))
+		if w := serve(r); w.Code != http.StatusConflict {
+			t.Errorf("\nExpected: %d\nActual: %d %s", http.StatusConflict, w.Code, w.Body.String())
+		}
+		entries, _ := os.ReadDir(root)
+		for _, entry := range entries {
+			if strings.Contains(entry.Name(), "forged_file") {
+				t.Errorf("\nExpected: rejected\nActual: %q", entry.Name())
+			}
+		}
+	})
+
+	t.Run("Form Upload", func(t *testing.T) {
+		body, contentType := form("photos/beach.jpg")
+		r := httptest.NewRequest(http.MethodPost, receivePrefix+rc.token, body)
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", "text/html")
+		w := serve(r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "photos/beach.jpg") || !strings.Contains(w.Body.String(), "1 file(s) expected") {
+			t.Errorf("\nExpected: %d photos/beach.jpg\nActual: %d %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Files Beyond The Count Are Rejected", func(t *testing.T) {
+		body, contentType := form("first_file", "second_file")
+		r := httptest.NewRequest(http.MethodPost, receivePrefix+rc.token, body)
+		r.Header.Set("Content-Type", contentType)
+		w := serve(r)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "file: first_file\n") {
+			t.Errorf("\nExpected: %d first_file\nActual: %d %s", http.StatusConflict, w.Code, w.Body.String())
+		}
+		if _, err := os.Stat(filepath.Join(root, "second_file")); err == nil {
+			t.Errorf("\nExpected: rejected\nActual: %s", "second_file")
+		}
+
+		select {
+		case <-rc.done:
+		default:
+			t.Errorf("\nExpected: done once the files are received")
+		}
+	})
+
+	t.Run("Stored Files Are Printed", func(t *testing.T) {
+		expected := ""
+		for _, name := range []string{"test_file(1).txt", filepath.Join("photos", "beach.jpg"), "first_file"} {
+			expected += fmt.Sprintf("%s  %s\n", hash, filepath.Join(root, name))
+		}
+		if out.String() != expected {
+			t.Errorf("\nExpected: %s\nActual: %s", expected, out.String())
+		}
+	})
+}
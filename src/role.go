@@ -0,0 +1,100 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// role of a signed-in client, each role pairs with its own PIN.
+type role string
+
+const (
+	// roleAdmin may do everything, the only role without '--roles'
+	roleAdmin role = "admin"
+	// roleUploader uploads into the storage as a drop box,
+	// it can not list or download the files
+	roleUploader role = "uploader"
+	// roleReader browses and downloads the files only
+	roleReader role = "reader"
+)
+
+// roles in the order shown on the index page
+var roles = []role{roleAdmin, roleUploader, roleReader}
+
+// roleTitles are the captions of the QR codes of the roles
+var roleTitles = map[role]string{
+	roleAdmin:    "Admin",
+	roleUploader: "Upload Only",
+	roleReader:   "Read Only",
+}
+
+func parseRole(s string) (role, bool) {
+	for _, r := range roles {
+		if string(r) == s {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// permission required by a route.
+type permission int
+
+const (
+	// list, download and archive files
+	permRead permission = iota
+	// upload files and create folders
+	permUpload
+	// delete, rename, move and overwrite files, and share links
+	permModify
+)
+
+// can reports whether the role has the permission.
+func (ro role) can(p permission) bool {
+	switch ro {
+	case roleAdmin:
+		return true
+	case roleUploader:
+		return p == permUpload
+	case roleReader:
+		return p == permRead
+	}
+	return false
+}
+
+type roleContextKey struct{}
+
+// withRole returns the request carrying the role of its session.
+func withRole(r *http.Request, ro role) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), roleContextKey{}, ro))
+}
+
+// requestRole returns the role of the request, every client
+// is admin when authentication is disabled.
+func requestRole(r *http.Request) role {
+	return contextRole(r.Context())
+}
+
+// contextRole is the requestRole of the request context.
+func contextRole(ctx context.Context) role {
+	if ro, ok := ctx.Value(roleContextKey{}).(role); ok {
+		return ro
+	}
+	return roleAdmin
+}
+
+// allow rejects the request with the error handler
+// when its role lacks the permission.
+func allow(p permission, h http.HandlerFunc, errorHandler func(http.ResponseWriter, string, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requestRole(r).can(p) {
+			errorHandler(w, "permission denied for the "+string(requestRole(r))+" role.", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
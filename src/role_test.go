@@ -0,0 +1,118 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	root := setupStorage(t)
+	setupShares(t)
+	setupAuth(t, "")
+	if err := os.WriteFile(filepath.Join(root, "test_file"), []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	mux := http.NewServeMux()
+	routes(mux)
+	h := authHandler(mux)
+
+	// serve sends the request with the PIN of the role as bearer token
+	serve := func(ro role, method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+authn.secretOf(ro))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// initialize testcases
+	tcs := []struct {
+		method string
+		target string
+		body   string
+		// allowed roles, the others are forbidden
+		allowed []role
+	}{
+		{http.MethodGet, "/download/test_file", "", []role{roleAdmin, roleReader}},
+		{http.MethodGet, "/archive?name=test_file", "", []role{roleAdmin, roleReader}},
+		{http.MethodGet, "/api/v1/files", "", []role{roleAdmin, roleReader}},
+		{http.MethodPut, "/files/upload_file", "Haiyaa!!", []role{roleAdmin, roleUploader}},
+		{http.MethodGet, "/upload/shares", "", []role{roleAdmin}},
+		{http.MethodDelete, "/api/v1/files/missing_file", "", []role{roleAdmin}},
+	}
+
+	for _, tc := range tcs {
+		for _, ro := range roles {
+			t.Run(string(ro)+" "+tc.method+" "+tc.target, func(t *testing.T) {
+				w := serve(ro, tc.method, tc.target, tc.body)
+				allowed := false
+				for _, a := range tc.allowed {
+					allowed = allowed || a == ro
+				}
+				if allowed == (w.Code == http.StatusForbidden) {
+					t.Errorf("\nExpected: allowed %t\nActual: %d", allowed, w.Code)
+				}
+			})
+		}
+	}
+
+	t.Run("Uploader Can Not Overwrite", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, "/files/test_file", strings.NewReader("Haiyaa!!"))
+		r.Header.Set("Authorization", "Bearer "+authn.secretOf(roleUploader))
+		r.Header.Set("X-Conflict", "overwrite")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("Upload Page Of The Role", func(t *testing.T) {
+		for _, tc := range []struct {
+			ro      role
+			form    bool
+			listing bool
+		}{
+			{roleAdmin, true, true},
+			{roleUploader, true, false},
+			{roleReader, false, true},
+		} {
+			body := serve(tc.ro, http.MethodGet, "/upload", "").Body.String()
+			form := strings.Contains(body, `id="uform"`)
+			listing := strings.Contains(body, "test_file")
+			if form != tc.form || listing != tc.listing {
+				t.Errorf("\nTest Data: (%s)\nExpected: form %t, listing %t\nActual: form %t, listing %t",
+					tc.ro, tc.form, tc.listing, form, listing)
+			}
+		}
+	})
+
+	t.Run("Session Keeps The Role Of The PIN", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/upload?pin="+authn.secretOf(roleReader), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Errorf("\nExpected a session cookie, but got %v.", cookies)
+			t.FailNow()
+		}
+
+		r = httptest.NewRequest(http.MethodPut, "/files/reader_file", strings.NewReader("Haiyaa!!"))
+		r.AddCookie(cookies[0])
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusForbidden, w.Code)
+		}
+	})
+}
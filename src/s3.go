@@ -0,0 +1,883 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"localfs/s3"
+	"localfs/util/fsutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Prefix is the endpoint of the S3-compatible API, with path-style
+// buckets mapped to the top-level folders of the storage, e.g.
+//
+//	aws s3 cp photo.jpg s3://photos/ --endpoint-url http://localhost:5000/s3
+const s3Prefix = "/s3/"
+
+// s3KeyEnv sets the '--s3-key' flag, which keeps the secret out of the process list
+const s3KeyEnv string = "LOCALFS_S3_KEY"
+
+const (
+	// hidden folder of the in-progress multipart uploads, inside
+	// the storage so complete uploads are moved by rename
+	s3UploadsDir string = fsutil.TempFilePrefix + "s3"
+	// multipart uploads not completed within the expiry are removed
+	s3Expiry time.Duration = 24 * time.Hour
+	// maximum keys of a listing page, as S3
+	s3MaxKeys int = 1000
+	// maximum size of the XML documents of the requests
+	s3MaxXML int64 = 1 << 20
+)
+
+// s3Verifier is nil when no key is configured, which disables the
+// endpoint, and s3Multipart is nil when the storage is read-only.
+// They are initialized by server.initS3.
+var (
+	s3Verifier  *s3.Verifier
+	s3Multipart *s3.Multipart
+)
+
+// etagCache keeps the ETags of the objects written over S3, the MD5 of
+// the object or of its parts, objects written otherwise are given an
+// ETag of their size and modified time rather than hashed on each read.
+var etagCache = fsutil.NewHashCache()
+
+func s3Routes(mux *http.ServeMux) {
+	mux.HandleFunc(strings.TrimSuffix(s3Prefix, "/"), s3Handler)
+	mux.HandleFunc(s3Prefix, s3Handler)
+}
+
+// s3Handler authenticates the request with its access key, which carries
+// the role of the request, and routes it to the operation.
+func s3Handler(w http.ResponseWriter, r *http.Request) {
+	if s3Verifier == nil {
+		errorHandler(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	key, err := s3Verifier.Verify(r)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	ro := roleAdmin
+	if key.Role != "" {
+		ro, _ = parseRole(key.Role)
+	}
+	r = withRole(r, ro)
+
+	rel := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(s3Prefix, "/")), "/")
+	bucket, object, _ := strings.Cut(rel, "/")
+	s3Route(r, bucket, object)(w, r)
+}
+
+// s3Route returns the handler of the operation, with the permission
+// required of the role, as on the upload page, replacing or deleting
+// an object requires the admin role.
+func s3Route(r *http.Request, bucket, object string) http.HandlerFunc {
+	q := r.URL.Query()
+	read := func(h http.HandlerFunc) http.HandlerFunc {
+		return allow(permRead, h, s3ErrorHandler)
+	}
+	write := func(p permission, h http.HandlerFunc) http.HandlerFunc {
+		return allow(p, writable(h, s3ErrorHandler), s3ErrorHandler)
+	}
+	// unless the key is new
+	overwrite := func() permission {
+		if strings.HasSuffix(object, "/") {
+			return permUpload
+		}
+		if fpath, err := s3Path(bucket, object); err == nil {
+			if _, err := os.Lstat(fpath); err == nil {
+				return permModify
+			}
+		}
+		return permUpload
+	}
+	notImplemented := func(w http.ResponseWriter, r *http.Request) {
+		s3Error(w, r, s3.ErrNotImplemented)
+	}
+
+	switch {
+	case bucket == "":
+		if r.Method == http.MethodGet {
+			return read(s3ListBucketsHandler)
+		}
+	case object == "":
+		switch r.Method {
+		case http.MethodGet:
+			switch {
+			case q.Has("location"):
+				// any role may find the bucket, as on HEAD
+				return func(w http.ResponseWriter, r *http.Request) { s3BucketLocationHandler(w, r, bucket) }
+			case q.Has("uploads"), q.Has("versioning"), q.Has("versions"), q.Has("policy"), q.Has("acl"):
+				return notImplemented
+			}
+			return read(func(w http.ResponseWriter, r *http.Request) { s3ListObjectsHandler(w, r, bucket) })
+		case http.MethodHead:
+			return func(w http.ResponseWriter, r *http.Request) { s3HeadBucketHandler(w, r, bucket) }
+		case http.MethodPut:
+			return write(permUpload, func(w http.ResponseWriter, r *http.Request) { s3CreateBucketHandler(w, r, bucket) })
+		case http.MethodDelete:
+			return write(permModify, func(w http.ResponseWriter, r *http.Request) { s3DeleteBucketHandler(w, r, bucket) })
+		case http.MethodPost:
+			if q.Has("delete") {
+				return write(permModify, func(w http.ResponseWriter, r *http.Request) { s3DeleteObjectsHandler(w, r, bucket) })
+			}
+		}
+	default:
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if q.Has("uploadId") || q.Has("acl") || q.Has("tagging") {
+				return notImplemented
+			}
+			return read(func(w http.ResponseWriter, r *http.Request) { s3GetObjectHandler(w, r, bucket, object) })
+		case http.MethodPut:
+			switch {
+			case r.Header.Get("X-Amz-Copy-Source") != "" && q.Has("uploadId"):
+				return notImplemented
+			case r.Header.Get("X-Amz-Copy-Source") != "":
+				// reads the source, only the admin role reads and writes
+				return write(permModify, func(w http.ResponseWriter, r *http.Request) { s3CopyObjectHandler(w, r, bucket, object) })
+			case q.Has("uploadId"):
+				return write(permUpload, func(w http.ResponseWriter, r *http.Request) { s3UploadPartHandler(w, r, bucket, object) })
+			case q.Has("acl"), q.Has("tagging"):
+				return notImplemented
+			}
+			return write(overwrite(), func(w http.ResponseWriter, r *http.Request) { s3PutObjectHandler(w, r, bucket, object) })
+		case http.MethodPost:
+			switch {
+			case q.Has("uploads"):
+				return write(overwrite(), func(w http.ResponseWriter, r *http.Request) { s3CreateMultipartHandler(w, r, bucket, object) })
+			case q.Has("uploadId"):
+				return write(overwrite(), func(w http.ResponseWriter, r *http.Request) { s3CompleteMultipartHandler(w, r, bucket, object) })
+			}
+		case http.MethodDelete:
+			if q.Has("uploadId") {
+				return write(permUpload, func(w http.ResponseWriter, r *http.Request) { s3AbortMultipartHandler(w, r, bucket, object) })
+			}
+			return write(permModify, func(w http.ResponseWriter, r *http.Request) { s3DeleteObjectHandler(w, r, bucket, object) })
+		}
+	}
+	return notImplemented
+}
+
+// s3Bucket returns the path of the folder of the bucket.
+func s3Bucket(bucket string) (string, error) {
+	if !fsutil.ValidFileName(bucket) {
+		return "", s3.ErrInvalidBucketName
+	}
+	fpath, err := storageDir(bucket)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", s3.ErrNoSuchBucket
+	}
+	return fpath, err
+}
+
+// s3Path returns the path of the key inside the folder of the bucket,
+// the slashes of the key separate its folders.
+func s3Path(bucket, key string) (string, error) {
+	root, err := s3Bucket(bucket)
+	if err != nil {
+		return "", err
+	}
+	fpath, err := fsutil.SecureJoin(root, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", s3.ErrNoSuchKey
+	}
+	return fpath, err
+}
+
+// s3ETag returns the ETag of the object, its MD5 when written over S3.
+func s3ETag(fpath string, info fs.FileInfo) string {
+	if etag, ok := etagCache.Get(fpath, info); ok {
+		return etag
+	}
+	if info.IsDir() {
+		return `"d41d8cd98f00b204e9800998ecf8427e"`
+	}
+	// not an MD5, as the ETag of a multipart upload
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func s3ListBucketsHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := fsutil.Listing(appCache[ckey_storage])
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+
+	result := s3.ListAllMyBucketsResult{Xmlns: s3.Namespace, Owner: s3Owner(), Buckets: []s3.Bucket{}}
+	for _, e := range entries {
+		if e.IsDir {
+			result.Buckets = append(result.Buckets, s3.Bucket{Name: e.Name, CreationDate: e.ModTime.UTC()})
+		}
+	}
+	s3WriteXML(w, result, http.StatusOK)
+}
+
+func s3HeadBucketHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	if _, err := s3Bucket(bucket); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func s3BucketLocationHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	if _, err := s3Bucket(bucket); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	// the default region
+	s3WriteXML(w, s3.LocationConstraint{Xmlns: s3.Namespace}, http.StatusOK)
+}
+
+func s3CreateBucketHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := fsutil.CreateDir(appCache[ckey_storage], bucket)
+	switch {
+	case errors.Is(err, fsutil.ErrInvalidFileName):
+		err = s3.ErrInvalidBucketName
+	case errors.Is(err, fs.ErrExist):
+		err = s3.ErrBucketAlreadyExists
+	}
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func s3DeleteBucketHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	root, err := s3Bucket(bucket)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	if err = os.Remove(root); err != nil {
+		s3Error(w, r, s3.ErrBucketNotEmpty)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// s3Entry is an object or a common prefix of a listing.
+type s3Entry struct {
+	key  string
+	path string
+	info fs.FileInfo
+	// prefix groups the keys up to the delimiter
+	prefix bool
+}
+
+// s3List returns the objects of the bucket starting with the prefix,
+// sorted by key. Keys are grouped up to the delimiter following the
+// prefix, and folders are grouped as well so that empty folders list.
+func s3List(root, prefix, delimiter string) ([]s3Entry, error) {
+	// walk from the folder of the prefix only
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		fpath, err := fsutil.SecureJoin(root, prefix[:i])
+		if err != nil {
+			return []s3Entry{}, nil
+		}
+		start = fpath
+	}
+
+	entries := []s3Entry{}
+	prefixes := map[string]bool{}
+	group := func(key string) bool {
+		if delimiter == "" || !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		i := strings.Index(key[len(prefix):], delimiter)
+		if i < 0 {
+			return false
+		}
+		p := key[:len(prefix)+i+len(delimiter)]
+		if !prefixes[p] {
+			prefixes[p] = true
+			entries = append(entries, s3Entry{key: p, prefix: true})
+		}
+		return true
+	}
+
+	err := filepath.WalkDir(start, func(fpath string, d fs.DirEntry, err error) error {
+		if fpath == start {
+			return err
+		}
+		if err != nil {
+			// removed or unreadable while walking
+			return nil
+		}
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		// skip in-progress writes
+		if strings.HasPrefix(d.Name(), fsutil.TempFilePrefix) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			key += "/"
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+				return filepath.SkipDir
+			}
+			// the keys inside share the group of the folder
+			if group(key) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(key, prefix) || group(key) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, s3Entry{key: key, path: fpath, info: info})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return []s3Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries, nil
+}
+
+// s3ListObjectsHandler lists the objects of version 1 of the API, or
+// version 2 with list-type=2, a page of the keys after the marker.
+func s3ListObjectsHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	root, err := s3Bucket(bucket)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+
+	maxKeys := s3MaxKeys
+	if s := q.Get("max-keys"); s != "" {
+		maxKeys, err = strconv.Atoi(s)
+		if err != nil || maxKeys < 0 {
+			s3Error(w, r, &s3.Error{Code: "InvalidArgument", Message: "max-keys must be a positive integer.", Status: http.StatusBadRequest})
+			return
+		}
+		maxKeys = min(maxKeys, s3MaxKeys)
+	}
+
+	result := s3.ListBucketResult{
+		Xmlns:     s3.Namespace,
+		Name:      bucket,
+		Prefix:    q.Get("prefix"),
+		Delimiter: q.Get("delimiter"),
+		MaxKeys:   maxKeys,
+	}
+	after := ""
+	if v2 {
+		result.ContinuationToken = q.Get("continuation-token")
+		result.StartAfter = q.Get("start-after")
+		after = result.StartAfter
+		if result.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				s3Error(w, r, &s3.Error{Code: "InvalidArgument", Message: "The continuation token is not valid.", Status: http.StatusBadRequest})
+				return
+			}
+			after = string(token)
+		}
+	} else {
+		marker := q.Get("marker")
+		result.Marker = &marker
+		after = marker
+	}
+
+	entries, err := s3List(root, result.Prefix, result.Delimiter)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	entries = entries[sort.Search(len(entries), func(i int) bool { return entries[i].key > after }):]
+	if len(entries) > maxKeys {
+		entries = entries[:maxKeys]
+		result.IsTruncated = true
+	}
+
+	// keys may hold characters not allowed in XML
+	encode := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		encode = url.QueryEscape
+		result.Prefix = encode(result.Prefix)
+		result.Delimiter = encode(result.Delimiter)
+		result.StartAfter = encode(result.StartAfter)
+		if result.Marker != nil {
+			marker := encode(*result.Marker)
+			result.Marker = &marker
+		}
+	}
+
+	for _, e := range entries {
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3.CommonPrefix{Prefix: encode(e.key)})
+			continue
+		}
+		result.Contents = append(result.Contents, s3.Object{
+			Key:          encode(e.key),
+			LastModified: e.info.ModTime().UTC(),
+			ETag:         s3ETag(e.path, e.info),
+			Size:         e.info.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	if result.IsTruncated && len(entries) > 0 {
+		last := entries[len(entries)-1].key
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			result.NextMarker = encode(last)
+		}
+	}
+	if v2 {
+		result.KeyCount = len(entries)
+	}
+	s3WriteXML(w, result, http.StatusOK)
+}
+
+// s3GetObjectHandler serves the object, with ranges and conditional
+// requests. A key ending with a slash is the folder, an empty object.
+func s3GetObjectHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	fpath, err := s3Path(bucket, key)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	if info.IsDir() != strings.HasSuffix(key, "/") || !(info.IsDir() || info.Mode().IsRegular()) {
+		s3Error(w, r, s3.ErrNoSuchKey)
+		return
+	}
+
+	w.Header().Set("ETag", s3ETag(fpath, info))
+	if info.IsDir() {
+		http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(nil))
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// s3PutObjectHandler stores the object, its missing folders are
+// created. A key ending with a slash creates the folder.
+func s3PutObjectHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	root, err := s3Bucket(bucket)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			s3Error(w, r, err)
+			return
+		}
+		if _, err := fsutil.CreateDirAll(root, key); err != nil {
+			s3Error(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	f, fpath, err := s3Create(root, key, r)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r.Body)
+	if want := r.Header.Get("Content-MD5"); err == nil && want != "" && want != base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+		err = s3.ErrBadDigest
+	}
+	if err != nil {
+		f.Abort()
+		s3Error(w, r, err)
+		return
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	if err = s3Commit(r, f, fpath, etag); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3Create creates the temporary file of the key, s3Commit stores it
+// as the object. Folders are never replaced.
+func s3Create(root, key string, r *http.Request) (*fsutil.AtomicFile, string, error) {
+	dir, name := path.Split(key)
+	if !fsutil.ValidFileName(name) {
+		return nil, "", &s3.Error{Code: "InvalidArgument", Message: "The key is not a valid file path.", Status: http.StatusBadRequest}
+	}
+	parent, err := fsutil.CreateDirAll(root, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	fpath := filepath.Join(parent, name)
+	if info, err := os.Lstat(fpath); err == nil {
+		if info.IsDir() {
+			return nil, "", &s3.Error{Code: "InvalidArgument", Message: "A folder with the same name exists.", Status: http.StatusConflict}
+		}
+		if r.Header.Get("If-None-Match") == "*" {
+			return nil, "", s3.ErrPreconditionFailed
+		}
+	}
+	f, err := fsutil.CreateAtomic(parent, name)
+	return f, fpath, err
+}
+
+// s3Commit stores the file as the object and caches its ETag. An
+// object created since the upload started is kept, as the existing one,
+// when the role may not replace files or the request asks for a new key.
+func s3Commit(r *http.Request, f *fsutil.AtomicFile, fpath, etag string) error {
+	commit := f.Commit
+	if !requestRole(r).can(permModify) || r.Header.Get("If-None-Match") == "*" {
+		commit = f.CommitNoReplace
+	}
+	if err := commit(); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return s3.ErrPreconditionFailed
+		}
+		return err
+	}
+	if info, err := os.Stat(fpath); err == nil {
+		etagCache.Put(fpath, info, etag)
+	}
+	return nil
+}
+
+// s3CopyObjectHandler copies the object of the x-amz-copy-source
+// header, "<bucket>/<key>", within the storage.
+func s3CopyObjectHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		s3Error(w, r, s3.ErrInvalidArgument)
+		return
+	}
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	srcPath, err := s3Path(srcBucket, srcKey)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	defer src.Close()
+	if info, err := src.Stat(); err != nil || !info.Mode().IsRegular() {
+		s3Error(w, r, s3.ErrNoSuchKey)
+		return
+	}
+
+	root, err := s3Bucket(bucket)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	f, fpath, err := s3Create(root, key, r)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	h := md5.New()
+	if _, err = io.Copy(io.MultiWriter(f, h), src); err != nil {
+		f.Abort()
+		s3Error(w, r, err)
+		return
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	if err = s3Commit(r, f, fpath, etag); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	s3WriteXML(w, s3.CopyObjectResult{Xmlns: s3.Namespace, LastModified: time.Now().UTC(), ETag: etag}, http.StatusOK)
+}
+
+// s3DeleteObjectHandler deletes the object, a key ending with a slash
+// deletes the folder when empty. Missing keys are deleted as well.
+func s3DeleteObjectHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if err := s3Delete(bucket, key); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func s3Delete(bucket, key string) error {
+	fpath, err := s3Path(bucket, key)
+	if errors.Is(err, s3.ErrNoSuchKey) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(fpath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir() && strings.HasSuffix(key, "/"):
+		// the keys inside are kept, as in S3
+		if err := os.Remove(fpath); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	case !info.IsDir() && !strings.HasSuffix(key, "/"):
+		return fsutil.Remove(fpath)
+	}
+	return nil
+}
+
+func s3DeleteObjectsHandler(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req s3.Delete
+	if err := s3ReadXML(r, &req); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	if _, err := s3Bucket(bucket); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+
+	result := s3.DeleteResult{Xmlns: s3.Namespace}
+	for _, o := range req.Objects {
+		if err := s3Delete(bucket, o.Key); err != nil {
+			e := s3StorageError(err)
+			result.Errors = append(result.Errors, s3.DeleteError{Key: o.Key, Code: e.Code, Message: e.Message})
+			continue
+		}
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, s3.DeletedObject{Key: o.Key})
+		}
+	}
+	s3WriteXML(w, result, http.StatusOK)
+}
+
+func s3CreateMultipartHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if _, err := s3Bucket(bucket); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	if _, name := path.Split(key); !fsutil.ValidFileName(name) {
+		s3Error(w, r, &s3.Error{Code: "InvalidArgument", Message: "The key is not a valid file path.", Status: http.StatusBadRequest})
+		return
+	}
+
+	s3Multipart.Purge(s3Expiry)
+	u, err := s3Multipart.Create(bucket, key)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	s3WriteXML(w, s3.InitiateMultipartUploadResult{Xmlns: s3.Namespace, Bucket: bucket, Key: key, UploadID: u.ID}, http.StatusOK)
+}
+
+// s3Upload returns the multipart upload of the request, of the key.
+func s3Upload(r *http.Request, bucket, key string) (s3.Upload, error) {
+	u, err := s3Multipart.Get(r.URL.Query().Get("uploadId"))
+	if err == nil && (u.Bucket != bucket || u.Key != key) {
+		err = s3.ErrNoSuchUpload
+	}
+	return u, err
+}
+
+func s3UploadPartHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	u, err := s3Upload(r, bucket, key)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		s3Error(w, r, s3.ErrInvalidArgument)
+		return
+	}
+	etag, err := s3Multipart.PutPart(u.ID, number, r.Body)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3CompleteMultipartHandler joins the parts into the object, and
+// removes the upload once the object is stored.
+func s3CompleteMultipartHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	var req s3.CompleteMultipartUpload
+	if err := s3ReadXML(r, &req); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	u, err := s3Upload(r, bucket, key)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	root, err := s3Bucket(bucket)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+
+	f, fpath, err := s3Create(root, key, r)
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	etag, err := s3Multipart.Complete(u.ID, req.Parts, f)
+	if err != nil {
+		f.Abort()
+		s3Error(w, r, err)
+		return
+	}
+	if err = s3Commit(r, f, fpath, etag); err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	s3Multipart.Remove(u.ID)
+	s3WriteXML(w, s3.CompleteMultipartUploadResult{Xmlns: s3.Namespace, Bucket: bucket, Key: key, ETag: etag}, http.StatusOK)
+}
+
+func s3AbortMultipartHandler(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	u, err := s3Upload(r, bucket, key)
+	if err == nil {
+		err = s3Multipart.Remove(u.ID)
+	}
+	if err != nil {
+		s3Error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func s3Owner() s3.Owner {
+	return s3.Owner{ID: "localfs", DisplayName: "localfs"}
+}
+
+// s3ReadXML decodes the XML document of the request, the whole body is
+// read so that its payload signature is verified.
+func s3ReadXML(r *http.Request, v any) error {
+	byt, err := io.ReadAll(io.LimitReader(r.Body, s3MaxXML))
+	if err != nil {
+		return err
+	}
+	if err = xml.Unmarshal(byt, v); err != nil {
+		return s3.ErrMalformedXML
+	}
+	return nil
+}
+
+func s3WriteXML(w http.ResponseWriter, v any, code int) {
+	byt, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	w.Write([]byte(xml.Header))
+	w.Write(byt)
+}
+
+// s3StorageError maps the storage errors to the S3 errors.
+func s3StorageError(err error) *s3.Error {
+	var e *s3.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	switch {
+	case errors.Is(err, fsutil.ErrInvalidPath),
+		errors.Is(err, fsutil.ErrInvalidFileName):
+		return &s3.Error{Code: "InvalidArgument", Message: err.Error(), Status: http.StatusBadRequest}
+	case errors.Is(err, fs.ErrNotExist):
+		return s3.ErrNoSuchKey
+	case errors.Is(err, fs.ErrExist):
+		return &s3.Error{Code: "InvalidArgument", Message: "A file or folder with the same name exists.", Status: http.StatusConflict}
+	case errors.Is(err, fs.ErrPermission):
+		return s3.ErrAccessDenied
+	}
+	return s3.AsError(err)
+}
+
+// s3Error replies with the XML document of the error, which
+// clients parse for the error code.
+func s3Error(w http.ResponseWriter, r *http.Request, err error) {
+	e := s3StorageError(err)
+	byt, _ := xml.Marshal(s3.ErrorResponse{Code: e.Code, Message: e.Message, Resource: r.URL.Path})
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(xml.Header))
+		w.Write(byt)
+	}
+}
+
+// s3ErrorHandler replies to the rejections of the route guards.
+func s3ErrorHandler(w http.ResponseWriter, message string, code int) {
+	byt, _ := xml.Marshal(s3.ErrorResponse{Code: s3.ErrAccessDenied.Code, Message: message})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	w.Write([]byte(xml.Header))
+	w.Write(byt)
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxParts of a multipart upload, numbered from 1.
+const MaxParts = 10000
+
+// Upload is the persisted state of a multipart upload.
+type Upload struct {
+	ID      string    `json:"id"`
+	Bucket  string    `json:"bucket"`
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// Multipart keeps each multipart upload as a directory of the parts and
+// a JSON info file, uploads survive server restarts until completed,
+// aborted or purged.
+type Multipart struct {
+	dir string
+}
+
+// NewMultipart returns the store of the directory, creating it when missing.
+func NewMultipart(dir string) (*Multipart, error) {
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+	return &Multipart{dir: dir}, nil
+}
+
+// Create creates an upload of the key without parts.
+func (m *Multipart) Create(bucket, key string) (Upload, error) {
+	rnd := make([]byte, 16)
+	if _, err := rand.Read(rnd); err != nil {
+		return Upload{}, err
+	}
+
+	u := Upload{ID: hex.EncodeToString(rnd), Bucket: bucket, Key: key, Created: time.Now().UTC()}
+	if err := os.Mkdir(m.uploadDir(u.ID), os.FileMode(0700)); err != nil {
+		return Upload{}, err
+	}
+	byt, err := json.Marshal(u)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(m.uploadDir(u.ID), "upload.json"), byt, 0600)
+	}
+	if err != nil {
+		os.RemoveAll(m.uploadDir(u.ID))
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+// Get returns the upload.
+func (m *Multipart) Get(id string) (Upload, error) {
+	if !validID(id) {
+		return Upload{}, ErrNoSuchUpload
+	}
+	byt, err := os.ReadFile(filepath.Join(m.uploadDir(id), "upload.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Upload{}, ErrNoSuchUpload
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	var u Upload
+	err = json.Unmarshal(byt, &u)
+	return u, err
+}
+
+// PutPart writes the part of the upload, replacing a part of the same
+// number once complete, and returns its quoted ETag, the MD5 of the part.
+func (m *Multipart) PutPart(id string, number int, r io.Reader) (string, error) {
+	if number < 1 || number > MaxParts {
+		return "", &Error{"InvalidArgument", fmt.Sprintf("Part number must be an integer between 1 and %d.", MaxParts), http.StatusBadRequest}
+	}
+	if _, err := m.Get(id); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(m.uploadDir(id), "*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := md5.New()
+	if _, err = io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(f.Name(), m.partPath(id, number)); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
+
+// Complete writes the listed parts, in ascending order, to the writer
+// and returns the ETag of the object, the MD5 of the MD5 of the parts
+// followed by the number of parts. The upload is kept until removed.
+func (m *Multipart) Complete(id string, parts []CompletePart, w io.Writer) (string, error) {
+	if _, err := m.Get(id); err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", ErrMalformedXML
+	}
+
+	sums := md5.New()
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return "", ErrInvalidPartOrder
+		}
+		if part.PartNumber < 1 || part.PartNumber > MaxParts {
+			return "", ErrInvalidPart
+		}
+		sum, err := m.copyPart(id, part.PartNumber, w)
+		if err != nil {
+			return "", err
+		}
+		if strings.Trim(part.ETag, `"`) != hex.EncodeToString(sum) {
+			return "", ErrInvalidPart
+		}
+		sums.Write(sum)
+	}
+	return fmt.Sprintf(`"%x-%d"`, sums.Sum(nil), len(parts)), nil
+}
+
+func (m *Multipart) copyPart(id string, number int, w io.Writer) ([]byte, error) {
+	f, err := os.Open(m.partPath(id, number))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrInvalidPart
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err = io.Copy(io.MultiWriter(w, h), f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Remove removes the upload and its parts, e.g. once aborted or completed.
+func (m *Multipart) Remove(id string) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(m.uploadDir(id))
+}
+
+// Purge removes the uploads created before the age and returns their number.
+func (m *Multipart) Purge(age time.Duration) int {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return 0
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		if !validID(entry.Name()) {
+			continue
+		}
+		if u, err := m.Get(entry.Name()); err == nil && time.Since(u.Created) < age {
+			continue
+		}
+		if err := os.RemoveAll(m.uploadDir(entry.Name())); err == nil {
+			purged++
+		}
+	}
+	return purged
+}
+
+func (m *Multipart) uploadDir(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+func (m *Multipart) partPath(id string, number int) string {
+	return filepath.Join(m.uploadDir(id), fmt.Sprintf("%05d.part", number))
+}
+
+func validID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package s3_test
+
+import (
+	"bytes"
+	"errors"
+	"localfs/s3"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMultipart(t *testing.T) *s3.Multipart {
+	m, err := s3.NewMultipart(t.TempDir())
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	return m
+}
+
+func TestMultipart(t *testing.T) {
+	t.Run("Parts Are Joined In Order", func(t *testing.T) {
+		m := newMultipart(t)
+		u, _ := m.Create("photos", "test_file")
+		etag2, _ := m.PutPart(u.ID, 2, strings.NewReader("Haiyaa!!"))
+		etag1, _ := m.PutPart(u.ID, 1, strings.NewReader("Fuiyoh!!"))
+
+		var b bytes.Buffer
+		etag, err := m.Complete(u.ID, []s3.CompletePart{{PartNumber: 1, ETag: etag1}, {PartNumber: 2, ETag: etag2}}, &b)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+		if b.String() != "Fuiyoh!!Haiyaa!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!Haiyaa!!", b.String())
+		}
+		// the ETag of S3 for two parts
+		if expected := `"4eb6d17af4e8325154aebea7d377d49b-2"`; etag != expected {
+			t.Errorf("\nExpected: %s\nActual: %s", expected, etag)
+		}
+
+		if err := m.Remove(u.ID); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+		if _, err := m.Get(u.ID); !errors.Is(err, s3.ErrNoSuchUpload) {
+			t.Errorf("\nExpected: %s\nActual: %v", s3.ErrNoSuchUpload, err)
+		}
+	})
+
+	t.Run("Invalid Parts Are Rejected", func(t *testing.T) {
+		m := newMultipart(t)
+		u, _ := m.Create("photos", "test_file")
+		etag1, _ := m.PutPart(u.ID, 1, strings.NewReader("Fuiyoh!!"))
+		etag2, _ := m.PutPart(u.ID, 2, strings.NewReader("Haiyaa!!"))
+
+		tcs := []struct {
+			title string
+			parts []s3.CompletePart
+			err   error
+		}{
+			{"Descending Order", []s3.CompletePart{{PartNumber: 2, ETag: etag2}, {PartNumber: 1, ETag: etag1}}, s3.ErrInvalidPartOrder},
+			{"Missing Part", []s3.CompletePart{{PartNumber: 1, ETag: etag1}, {PartNumber: 3, ETag: etag2}}, s3.ErrInvalidPart},
+			{"Wrong ETag", []s3.CompletePart{{PartNumber: 1, ETag: etag2}}, s3.ErrInvalidPart},
+		}
+
+		for _, tc := range tcs {
+			t.Run(tc.title, func(t *testing.T) {
+				if _, err := m.Complete(u.ID, tc.parts, &bytes.Buffer{}); !errors.Is(err, tc.err) {
+					t.Errorf("\nExpected: %s\nActual: %v", tc.err, err)
+				}
+			})
+		}
+		if _, err := m.PutPart(u.ID, s3.MaxParts+1, strings.NewReader("Fuiyoh!!")); err == nil {
+			t.Errorf("\nExpected: error\nActual: %v", err)
+		}
+	})
+
+	t.Run("Stale Uploads Are Purged", func(t *testing.T) {
+		m := newMultipart(t)
+		u, _ := m.Create("photos", "test_file")
+		time.Sleep(50 * time.Millisecond)
+		if actual := m.Purge(time.Hour); actual != 0 {
+			t.Errorf("\nExpected: %d\nActual: %d", 0, actual)
+		}
+		if actual := m.Purge(10 * time.Millisecond); actual != 1 {
+			t.Errorf("\nExpected: %d\nActual: %d", 1, actual)
+		}
+		if _, err := m.Get(u.ID); !errors.Is(err, s3.ErrNoSuchUpload) {
+			t.Errorf("\nExpected: %s\nActual: %v", s3.ErrNoSuchUpload, err)
+		}
+	})
+}
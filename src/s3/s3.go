@@ -0,0 +1,226 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package s3 implements the parts of the Amazon S3 protocol served by
+// localfs: Signature Version 4 authentication, multipart uploads and the
+// XML documents of the responses, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/Welcome.html.
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Namespace of the XML documents.
+const Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Error is the error response of a request.
+type Error struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrAccessDenied          = &Error{"AccessDenied", "Access Denied.", http.StatusForbidden}
+	ErrMissingSecurityHeader = &Error{"MissingSecurityHeader", "The request is not signed.", http.StatusForbidden}
+	ErrInvalidAccessKeyID    = &Error{"InvalidAccessKeyId", "The access key does not exist.", http.StatusForbidden}
+	ErrSignatureDoesNotMatch = &Error{"SignatureDoesNotMatch", "The request signature does not match, check the secret key.", http.StatusForbidden}
+	ErrRequestTimeTooSkewed  = &Error{"RequestTimeTooSkewed", "The difference between the request time and the server time is too large.", http.StatusForbidden}
+	ErrExpiredToken          = &Error{"AccessDenied", "Request has expired.", http.StatusForbidden}
+	ErrAuthorizationHeader   = &Error{"AuthorizationHeaderMalformed", "The authorization header is malformed.", http.StatusBadRequest}
+	ErrContentSHA256Mismatch = &Error{"XAmzContentSHA256Mismatch", "The content SHA-256 does not match the body.", http.StatusBadRequest}
+	ErrBadDigest             = &Error{"BadDigest", "The Content-MD5 does not match the body.", http.StatusBadRequest}
+	ErrIncompleteBody        = &Error{"IncompleteBody", "The body is shorter than its declared length.", http.StatusBadRequest}
+	ErrNoSuchBucket          = &Error{"NoSuchBucket", "The bucket does not exist.", http.StatusNotFound}
+	ErrNoSuchKey             = &Error{"NoSuchKey", "The key does not exist.", http.StatusNotFound}
+	ErrNoSuchUpload          = &Error{"NoSuchUpload", "The multipart upload does not exist.", http.StatusNotFound}
+	ErrInvalidBucketName     = &Error{"InvalidBucketName", "The bucket name is not a valid folder name.", http.StatusBadRequest}
+	ErrBucketAlreadyExists   = &Error{"BucketAlreadyOwnedByYou", "The bucket already exists.", http.StatusConflict}
+	ErrBucketNotEmpty        = &Error{"BucketNotEmpty", "The bucket is not empty.", http.StatusConflict}
+	ErrInvalidArgument       = &Error{"InvalidArgument", "Invalid argument.", http.StatusBadRequest}
+	ErrInvalidPart           = &Error{"InvalidPart", "A part was not uploaded, or its ETag does not match.", http.StatusBadRequest}
+	ErrInvalidPartOrder      = &Error{"InvalidPartOrder", "The parts are not in ascending order.", http.StatusBadRequest}
+	ErrMalformedXML          = &Error{"MalformedXML", "The XML document is malformed.", http.StatusBadRequest}
+	ErrPreconditionFailed    = &Error{"PreconditionFailed", "The key already exists.", http.StatusPreconditionFailed}
+	ErrNotImplemented        = &Error{"NotImplemented", "The operation is not supported.", http.StatusNotImplemented}
+)
+
+// ErrorResponse is the XML document of an error.
+type ErrorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+}
+
+// Key is an access key of the clients.
+type Key struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// Role of the clients of the key, empty for the default role
+	Role string
+}
+
+// ParseKey parses the key of the form "<access key id>:<secret access key>",
+// optionally followed by ":<role>".
+func ParseKey(s string) (Key, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Key{}, fmt.Errorf("invalid key '%s', use <access key id>:<secret access key>[:<role>]", s)
+	}
+	k := Key{AccessKeyID: parts[0], SecretAccessKey: parts[1]}
+	if len(parts) == 3 {
+		k.Role = parts[2]
+	}
+	return k, nil
+}
+
+// Bucket of the ListAllMyBucketsResult.
+type Bucket struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+// ListAllMyBucketsResult is the response of ListBuckets.
+type ListAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   Owner    `xml:"Owner"`
+	Buckets []Bucket `xml:"Buckets>Bucket"`
+}
+
+// Owner of the buckets and objects.
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// Object of a listing.
+type Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+// CommonPrefix groups the keys of a listing up to the delimiter.
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// ListBucketResult is the response of ListObjects and ListObjectsV2, the
+// continuation fields are of version 2, the markers of version 1.
+type ListBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	KeyCount              int            `xml:"KeyCount,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	Contents              []Object       `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// LocationConstraint is the response of GetBucketLocation.
+type LocationConstraint struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:",chardata"`
+}
+
+// InitiateMultipartUploadResult is the response of CreateMultipartUpload.
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// CompletePart is a part listed by CompleteMultipartUpload.
+type CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// CompleteMultipartUpload is the request of CompleteMultipartUpload.
+type CompleteMultipartUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []CompletePart `xml:"Part"`
+}
+
+// CompleteMultipartUploadResult is the response of CompleteMultipartUpload.
+type CompleteMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+// Delete is the request of DeleteObjects.
+type Delete struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+// DeletedObject of the DeleteResult.
+type DeletedObject struct {
+	Key string `xml:"Key"`
+}
+
+// DeleteError of the DeleteResult.
+type DeleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// DeleteResult is the response of DeleteObjects.
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+// CopyObjectResult is the response of CopyObject.
+type CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	Xmlns        string    `xml:"xmlns,attr"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+// AsError returns the S3 error of the error, an internal error
+// for errors of other types.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{"InternalError", err.Error(), http.StatusInternalServerError}
+}
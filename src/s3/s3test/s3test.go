@@ -0,0 +1,81 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package s3test signs requests with Signature Version 4 as the S3
+// clients do, for the tests of the S3-compatible endpoint. It does not
+// share code with the verifier, so the tests check one against the other.
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"localfs/s3"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sign signs the request with the key in the Authorization header,
+// the body is sent as an unsigned payload.
+func Sign(r *http.Request, key s3.Key, region string, t time.Time) {
+	t = t.UTC()
+	date, amzDate := t.Format("20060102"), t.Format("20060102T150405Z")
+	scope := date + "/" + region + "/s3/aws4_request"
+	r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	query := []string{}
+	for k, values := range r.URL.Query() {
+		for _, v := range values {
+			query = append(query, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	sort.Strings(query)
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		escape(path, false),
+		strings.Join(query, "&"),
+		"host:" + r.Host + "\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	signingKey := []byte("AWS4" + key.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		signingKey = mac(signingKey, part)
+	}
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+key.AccessKeyID+"/"+scope+
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date"+
+		", Signature="+hex.EncodeToString(mac(signingKey, stringToSign)))
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escape percent-encodes every byte except the unreserved characters,
+// and the slash of a path.
+func escape(s string, query bool) string {
+	b := strings.Builder{}
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("-_.~", c) >= 0 || (c == '/' && !query) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,458 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	// payload hashes of the x-amz-content-sha256 header, besides
+	// the hex encoded SHA-256 of the body
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	timeFormat = "20060102T150405Z"
+	// maxSkew between the clocks of the client and the server
+	maxSkew = 15 * time.Minute
+	// maxExpires of a presigned URL
+	maxExpires = 7 * 24 * time.Hour
+)
+
+// Verifier authenticates the requests signed with Signature Version 4,
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html.
+type Verifier struct {
+	keys map[string]Key
+	// now is the clock of the server, replaced by the tests
+	now func() time.Time
+}
+
+// NewVerifier returns the verifier of the keys.
+func NewVerifier(keys []Key) *Verifier {
+	v := &Verifier{keys: map[string]Key{}, now: time.Now}
+	for _, k := range keys {
+		v.keys[k.AccessKeyID] = k
+	}
+	return v
+}
+
+// signature holds the parsed authentication of a request.
+type signature struct {
+	accessKeyID   string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	time          time.Time
+	payload       string
+	// expires of a presigned URL
+	expires time.Duration
+}
+
+func (s signature) scope() string {
+	return s.date + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+// Verify authenticates the request, signed in the Authorization header or
+// in the query of a presigned URL, and returns its key. The request body
+// is replaced by a body verifying the payload while read, it fails at the
+// end of the payload with ErrContentSHA256Mismatch or with
+// ErrSignatureDoesNotMatch when the payload does not match the signature.
+func (v *Verifier) Verify(r *http.Request) (Key, error) {
+	var sig signature
+	var err error
+	if r.Header.Get("Authorization") != "" {
+		sig, err = parseHeader(r)
+	} else if r.URL.Query().Has("X-Amz-Signature") {
+		sig, err = parseQuery(r)
+	} else {
+		return Key{}, ErrMissingSecurityHeader
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	key, ok := v.keys[sig.accessKeyID]
+	if !ok {
+		return Key{}, ErrInvalidAccessKeyID
+	}
+
+	now := v.now()
+	if sig.expires > 0 {
+		if now.Before(sig.time.Add(-maxSkew)) {
+			return Key{}, ErrRequestTimeTooSkewed
+		}
+		if now.After(sig.time.Add(sig.expires)) {
+			return Key{}, ErrExpiredToken
+		}
+	} else if d := now.Sub(sig.time); d > maxSkew || d < -maxSkew {
+		return Key{}, ErrRequestTimeTooSkewed
+	}
+
+	signingKey := deriveKey(key.SecretAccessKey, sig)
+	stringToSign := strings.Join([]string{
+		algorithm,
+		sig.amzDate,
+		sig.scope(),
+		hexSHA256([]byte(canonicalRequest(r, sig))),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return Key{}, ErrSignatureDoesNotMatch
+	}
+
+	switch sig.payload {
+	case unsignedPayload:
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		c := &chunkedReader{r: bufio.NewReaderSize(r.Body, 64<<10), body: r.Body}
+		if sig.payload != streamingUnsignedTrailer {
+			c.prev = sig.signature
+			c.sign = func(prev string, chunkHash []byte) string {
+				return hex.EncodeToString(hmacSHA256(signingKey, strings.Join([]string{
+					algorithm + "-PAYLOAD", sig.amzDate, sig.scope(), prev, emptySHA256, hex.EncodeToString(chunkHash),
+				}, "\n")))
+			}
+		}
+		r.Body = c
+		if n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+			r.ContentLength = n
+		}
+	default:
+		expected, err := hex.DecodeString(sig.payload)
+		if err != nil || len(expected) != sha256.Size {
+			return Key{}, ErrContentSHA256Mismatch
+		}
+		r.Body = &hashReader{ReadCloser: r.Body, hash: sha256.New(), expected: expected}
+	}
+	return key, nil
+}
+
+func parseHeader(r *http.Request) (signature, error) {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), algorithm+" ")
+	if !ok {
+		return signature{}, ErrAuthorizationHeader
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[k] = v
+	}
+
+	sig, err := parseCredential(fields["Credential"])
+	if err != nil {
+		return sig, err
+	}
+	sig.signedHeaders = strings.Split(fields["SignedHeaders"], ";")
+	sig.signature = fields["Signature"]
+	sig.payload = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payload == "" {
+		return sig, &Error{"InvalidRequest", "Missing required header x-amz-content-sha256.", http.StatusBadRequest}
+	}
+
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	if sig.amzDate == "" {
+		// the Date header signs the request without x-amz-date
+		t, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return sig, ErrAuthorizationHeader
+		}
+		sig.amzDate = t.UTC().Format(timeFormat)
+	}
+	if sig.time, err = time.Parse(timeFormat, sig.amzDate); err != nil {
+		return sig, ErrAuthorizationHeader
+	}
+	if !strings.HasPrefix(sig.amzDate, sig.date) || sig.signature == "" {
+		return sig, ErrAuthorizationHeader
+	}
+	return sig, nil
+}
+
+func parseQuery(r *http.Request) (signature, error) {
+	q := r.URL.Query()
+	if q.Get("X-Amz-Algorithm") != algorithm {
+		return signature{}, ErrAuthorizationHeader
+	}
+	sig, err := parseCredential(q.Get("X-Amz-Credential"))
+	if err != nil {
+		return sig, err
+	}
+	sig.signedHeaders = strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+	sig.signature = q.Get("X-Amz-Signature")
+	sig.payload = unsignedPayload
+	if payload := q.Get("X-Amz-Content-Sha256"); payload != "" {
+		sig.payload = payload
+	}
+
+	sig.amzDate = q.Get("X-Amz-Date")
+	if sig.time, err = time.Parse(timeFormat, sig.amzDate); err != nil {
+		return sig, ErrAuthorizationHeader
+	}
+	seconds, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	sig.expires = time.Duration(seconds) * time.Second
+	if err != nil || sig.expires <= 0 || sig.expires > maxExpires {
+		return sig, &Error{"AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 second and 7 days.", http.StatusBadRequest}
+	}
+	if !strings.HasPrefix(sig.amzDate, sig.date) || sig.signature == "" {
+		return sig, ErrAuthorizationHeader
+	}
+	return sig, nil
+}
+
+// parseCredential parses <access key id>/<date>/<region>/<service>/aws4_request.
+func parseCredential(credential string) (signature, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return signature{}, ErrAuthorizationHeader
+	}
+	return signature{accessKeyID: parts[0], date: parts[1], region: parts[2], service: parts[3]}, nil
+}
+
+func canonicalRequest(r *http.Request, sig signature) string {
+	uri := uriEncode(r.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+
+	// sorted query, without the signature of a presigned URL
+	params := [][2]string{}
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		k, _ = url.QueryUnescape(k)
+		v, _ = url.QueryUnescape(v)
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		params = append(params, [2]string{uriEncode(k, true), uriEncode(v, true)})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	query := make([]string, len(params))
+	for i, p := range params {
+		query[i] = p[0] + "=" + p[1]
+	}
+
+	headers := strings.Builder{}
+	for _, name := range sig.signedHeaders {
+		values := r.Header.Values(name)
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			if len(values) == 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		}
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uri,
+		strings.Join(query, "&"),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payload,
+	}, "\n")
+}
+
+// uriEncode encodes every byte except the unreserved characters,
+// and the slash unless it is encoded too.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+func deriveKey(secret string, sig signature) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), sig.date)
+	key = hmacSHA256(key, sig.region)
+	key = hmacSHA256(key, sig.service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashReader fails at the end of the body when its SHA-256 does not match.
+type hashReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(h.hash.Sum(nil), h.expected) {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes the aws-chunked body of a streaming upload, each
+// chunk is "<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n", the
+// last chunk is empty and followed by the trailing headers.
+type chunkedReader struct {
+	r    *bufio.Reader
+	body io.Closer
+	// sign returns the signature of the chunk, nil for unsigned chunks
+	sign      func(prev string, chunkHash []byte) string
+	prev      string
+	chunkSig  string
+	hash      hash.Hash
+	remaining int64
+	done      bool
+	err       error
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil && c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.nextChunk()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = ErrIncompleteBody
+	}
+	if err != nil {
+		c.err = err
+		return n, err
+	}
+	if c.remaining == 0 {
+		// the data is followed by CRLF
+		if crlf, err := c.readLine(); err != nil || crlf != "" {
+			c.err = ErrIncompleteBody
+			return n, c.err
+		}
+		if err := c.verify(); err != nil {
+			c.err = err
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// nextChunk reads the header of the next chunk, and the trailing
+// headers after the last chunk.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	size, params, _ := strings.Cut(line, ";")
+	n, err := strconv.ParseInt(size, 16, 64)
+	if err != nil || n < 0 {
+		return ErrIncompleteBody
+	}
+	c.chunkSig, _ = strings.CutPrefix(params, "chunk-signature=")
+	c.hash = sha256.New()
+	c.remaining = n
+	if n > 0 {
+		return nil
+	}
+
+	if err := c.verify(); err != nil {
+		return err
+	}
+	// the trailing headers, e.g. the checksum, end with an empty line
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+	}
+	c.done = true
+	return nil
+}
+
+// verify verifies the signature of the chunk, chained to the previous.
+func (c *chunkedReader) verify() error {
+	if c.sign == nil {
+		return nil
+	}
+	expected := c.sign(c.prev, c.hash.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return ErrSignatureDoesNotMatch
+	}
+	c.prev = expected
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, io.EOF) {
+		return "", ErrIncompleteBody
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"localfs/s3"
+	"localfs/s3/s3test"
+	"localfs/util/fsutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupS3(t *testing.T, root string) {
+	s3Verifier = s3.NewVerifier([]s3.Key{
+		{AccessKeyID: "admin", SecretAccessKey: "Fuiyoh!!"},
+		{AccessKeyID: "uploader", SecretAccessKey: "Fuiyoh!!", Role: string(roleUploader)},
+		{AccessKeyID: "reader", SecretAccessKey: "Fuiyoh!!", Role: string(roleReader)},
+	})
+	m, err := s3.NewMultipart(filepath.Join(root, s3UploadsDir))
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	s3Multipart = m
+	t.Cleanup(func() { s3Verifier, s3Multipart = nil, nil })
+}
+
+func TestS3(t *testing.T) {
+	root := setupStorage(t)
+	setupAuth(t, "")
+	setupS3(t, root)
+	os.MkdirAll(filepath.Join(root, "photos", "2024"), 0755)
+	if err := os.WriteFile(filepath.Join(root, "photos", "test_file"), []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	os.WriteFile(filepath.Join(root, "photos", "2024", "beach.jpg"), []byte("Haiyaa!!"), 0644)
+	os.WriteFile(filepath.Join(root, "photos", fsutil.TempFilePrefix+"hidden.part"), []byte("Haiyaa!!"), 0644)
+
+	mux := http.NewServeMux()
+	s3Routes(mux)
+	h := authHandler(mux)
+
+	// serve signs the request with the access key of the role
+	serve := func(accessKeyID, method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		s3test.Sign(r, s3.Key{AccessKeyID: accessKeyID, SecretAccessKey: "Fuiyoh!!"}, "us-east-1", time.Now())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Request Is Signed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/s3/photos/test_file", nil))
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "MissingSecurityHeader") {
+			t.Errorf("\nExpected: %d MissingSecurityHeader\nActual: %d %s", http.StatusForbidden, w.Code, w.Body.String())
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/s3/photos/test_file", nil)
+		s3test.Sign(r, s3.Key{AccessKeyID: "admin", SecretAccessKey: "Haiyaa!!"}, "us-east-1", time.Now())
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "SignatureDoesNotMatch") {
+			t.Errorf("\nExpected: %d SignatureDoesNotMatch\nActual: %d %s", http.StatusForbidden, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Buckets Are Top-Level Folders", func(t *testing.T) {
+		w := serve("admin", http.MethodGet, "/s3/", "")
+		var result s3.ListAllMyBucketsResult
+		xml.Unmarshal(w.Body.Bytes(), &result)
+		if len(result.Buckets) != 1 || result.Buckets[0].Name != "photos" {
+			t.Errorf("\nExpected: [photos]\nActual: %d %s", w.Code, w.Body.String())
+		}
+		if w := serve("admin", http.MethodHead, "/s3/videos", ""); w.Code != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Listing Groups Folders", func(t *testing.T) {
+		w := serve("admin", http.MethodGet, "/s3/photos?list-type=2&delimiter=%2F", "")
+		var result s3.ListBucketResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Errorf("\nError: %s\n%s", err, w.Body.String())
+		}
+		if len(result.Contents) != 1 || result.Contents[0].Key != "test_file" || result.Contents[0].Size != 8 {
+			t.Errorf("\nExpected: [test_file]\nActual: %s", w.Body.String())
+		}
+		if len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "2024/" {
+			t.Errorf("\nExpected: [2024/]\nActual: %s", w.Body.String())
+		}
+
+		// every key, one page at a time
+		keys := []string{}
+		for token := ""; ; {
+			w := serve("admin", http.MethodGet, "/s3/photos?list-type=2&max-keys=1&continuation-token="+token, "")
+			var page s3.ListBucketResult
+			xml.Unmarshal(w.Body.Bytes(), &page)
+			for _, o := range page.Contents {
+				keys = append(keys, o.Key)
+			}
+			if !page.IsTruncated || len(keys) > 2 {
+				break
+			}
+			token = page.NextContinuationToken
+		}
+		if strings.Join(keys, ",") != "2024/beach.jpg,test_file" {
+			t.Errorf("\nExpected: %s\nActual: %s", "2024/beach.jpg,test_file", keys)
+		}
+	})
+
+	t.Run("Upload And Download", func(t *testing.T) {
+		w := serve("uploader", http.MethodPut, "/s3/photos/2025/new%20file", "Haiyaa!!")
+		if w.Code != http.StatusOK || w.Header().Get("ETag") != `"92a890e65bb40ea284b139a3d0382602"` {
+			t.Errorf("\nExpected: %d\nActual: %d %s %s", http.StatusOK, w.Code, w.Header().Get("ETag"), w.Body.String())
+		}
+		w = serve("reader", http.MethodGet, "/s3/photos/2025/new%20file", "")
+		if w.Code != http.StatusOK || w.Body.String() != "Haiyaa!!" || w.Header().Get("ETag") != `"92a890e65bb40ea284b139a3d0382602"` {
+			t.Errorf("\nExpected: %d %s\nActual: %d %s", http.StatusOK, "Haiyaa!!", w.Code, w.Body.String())
+		}
+		if w := serve("admin", http.MethodGet, "/s3/photos/missing_file", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchKey") {
+			t.Errorf("\nExpected: %d NoSuchKey\nActual: %d %s", http.StatusNotFound, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Multipart Upload", func(t *testing.T) {
+		w := serve("uploader", http.MethodPost, "/s3/photos/multipart_file?uploads", "")
+		var upload s3.InitiateMultipartUploadResult
+		xml.Unmarshal(w.Body.Bytes(), &upload)
+		if upload.UploadID == "" {
+			t.Errorf("\nExpected: upload ID\nActual: %d %s", w.Code, w.Body.String())
+			t.FailNow()
+		}
+
+		complete := "<CompleteMultipartUpload>"
+		for i, part := range []string{"Fuiyoh!!", "Haiyaa!!"} {
+			w := serve("uploader", http.MethodPut, "/s3/photos/multipart_file?partNumber="+string(rune('1'+i))+"&uploadId="+upload.UploadID, part)
+			complete += "<Part><PartNumber>" + string(rune('1'+i)) + "</PartNumber><ETag>" + w.Header().Get("ETag") + "</ETag></Part>"
+		}
+		complete += "</CompleteMultipartUpload>"
+		w = serve("uploader", http.MethodPost, "/s3/photos/multipart_file?uploadId="+upload.UploadID, complete)
+		if w.Code != http.StatusOK {
+			t.Errorf("\nExpected: %d\nActual: %d %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if b, _ := os.ReadFile(filepath.Join(root, "photos", "multipart_file")); string(b) != "Fuiyoh!!Haiyaa!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!Haiyaa!!", b)
+		}
+		if _, err := s3Multipart.Get(upload.UploadID); err == nil {
+			t.Errorf("\nExpected: upload removed\nActual: %s", upload.UploadID)
+		}
+	})
+
+	t.Run("Upload Keeps Objects Created Meanwhile", func(t *testing.T) {
+		r := withRole(httptest.NewRequest(http.MethodPut, "/s3/photos/raced_file", nil), roleUploader)
+		f, fpath, err := s3Create(filepath.Join(root, "photos"), "raced_file", r)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		f.Write([]byte("Haiyaa!!"))
+		os.WriteFile(fpath, []byte("Fuiyoh!!"), 0644)
+		if err := s3Commit(r, f, fpath, `"92a890e65bb40ea284b139a3d0382602"`); err != s3.ErrPreconditionFailed {
+			t.Errorf("\nExpected: %s\nActual: %v", s3.ErrPreconditionFailed, err)
+		}
+		if b, _ := os.ReadFile(fpath); string(b) != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!", b)
+		}
+	})
+
+	t.Run("Permissions Of The Roles", func(t *testing.T) {
+		tcs := []struct {
+			accessKeyID string
+			method      string
+			target      string
+			code        int
+		}{
+			// replacing a file deletes it
+			{"uploader", http.MethodPut, "/s3/photos/test_file", http.StatusForbidden},
+			{"uploader", http.MethodGet, "/s3/photos/test_file", http.StatusForbidden},
+			{"uploader", http.MethodGet, "/s3/photos?list-type=2", http.StatusForbidden},
+			{"uploader", http.MethodDelete, "/s3/photos/test_file", http.StatusForbidden},
+			{"uploader", http.MethodPut, "/s3/uploads", http.StatusOK},
+			{"reader", http.MethodPut, "/s3/photos/reader_file", http.StatusForbidden},
+			{"reader", http.MethodHead, "/s3/photos/test_file", http.StatusOK},
+			{"admin", http.MethodPut, "/s3/photos/test_file", http.StatusOK},
+			// hidden names and paths outside the bucket are rejected
+			{"admin", http.MethodGet, "/s3/photos/" + fsutil.TempFilePrefix + "hidden.part", http.StatusBadRequest},
+			{"admin", http.MethodPut, "/s3/photos/2024/..%2F..%2Fescaped_file", http.StatusBadRequest},
+			{"admin", http.MethodDelete, "/s3/photos", http.StatusConflict},
+			{"admin", http.MethodDelete, "/s3/photos/2024/beach.jpg", http.StatusNoContent},
+		}
+
+		for _, tc := range tcs {
+			w := serve(tc.accessKeyID, tc.method, tc.target, "Haiyaa!!")
+			if w.Code != tc.code {
+				t.Errorf("\nTest Data: (%s %s %s)\nExpected: %d\nActual: %d %s", tc.accessKeyID, tc.method, tc.target, tc.code, w.Code, w.Body.String())
+			}
+		}
+		if _, err := os.Stat(filepath.Join(root, "escaped_file")); err == nil {
+			t.Errorf("\nExpected: rejected\nActual: %s", "escaped_file")
+		}
+	})
+
+	t.Run("Read-Only Storage", func(t *testing.T) {
+		appCache[ckey_readonly] = "true"
+		t.Cleanup(func() { delete(appCache, ckey_readonly) })
+		if w := serve("admin", http.MethodPut, "/s3/photos/readonly_file", "Haiyaa!!"); w.Code != http.StatusForbidden {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusForbidden, w.Code)
+		}
+		w := serve("admin", http.MethodGet, "/s3/photos/test_file", "")
+		if body, _ := io.ReadAll(w.Body); w.Code != http.StatusOK || string(body) != "Haiyaa!!" {
+			t.Errorf("\nExpected: %d %s\nActual: %d %s", http.StatusOK, "Haiyaa!!", w.Code, body)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"localfs/share"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sendPrefix is the route of the sent file, followed by the token
+// and the file name.
+const sendPrefix = "/send/"
+
+// sender serves the files of 'localfs send' at a share link, a single
+// file as is and several files, or a folder, as a zip archive.
+type sender struct {
+	files []string
+	name  string
+	store *share.Store
+	link  share.Link
+	// closed once the downloads are used up
+	done chan struct{}
+	once sync.Once
+}
+
+// newSender returns the sender of the files, which are downloaded
+// count times within the timeout.
+func newSender(files []string, count int, timeout time.Duration) (*sender, error) {
+	names := []string{}
+	taken := map[string]bool{}
+	for i, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = os.Stat(abs); err != nil {
+			return nil, err
+		}
+		// the files are named by their base name in the archive
+		name := filepath.Base(abs)
+		if taken[name] {
+			return nil, fmt.Errorf("'%s' is sent twice, the names of the files must differ", name)
+		}
+		taken[name] = true
+		names = append(names, name)
+		files[i] = abs
+	}
+
+	name := names[0]
+	if len(files) > 1 || !isRegular(files[0]) {
+		name = archiveName("", names) + ".zip"
+	}
+
+	store, err := share.NewStore()
+	if err != nil {
+		return nil, err
+	}
+	l, err := store.Create(files[0], share.Options{Expiry: timeout, MaxDownloads: count})
+	if err != nil {
+		return nil, err
+	}
+	return &sender{files: files, name: name, store: store, link: l, done: make(chan struct{})}, nil
+}
+
+// path returns the path of the link, which ends with the file name
+// so that clients such as wget save the file under its name.
+func (sd *sender) path() string {
+	return sendPrefix + sd.link.Token + "/" + url.PathEscape(sd.name)
+}
+
+// ServeHTTP serves the file of the link, a download is reserved before
+// the file is sent, so the file is sent at most the count of times. The
+// last download ends the sender once it is served, complete or not.
+func (sd *sender) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	if _, err := sd.store.Get(token); err != nil {
+		http.Error(w, err.Error()+".", shareErrorStatus(err))
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": sd.name}))
+	h.Set("Cache-Control", "no-store")
+
+	var l share.Link
+	var complete bool
+	var err error
+	if len(sd.files) == 1 && isRegular(sd.files[0]) {
+		l, complete, err = sd.serveFile(w, r)
+	} else {
+		l, complete, err = sd.serveArchive(w, r)
+	}
+	if err != nil {
+		http.Error(w, err.Error()+".", shareErrorStatus(err))
+		return
+	}
+	// nothing was reserved by a HEAD request or a missing file
+	if r.Method != http.MethodGet || l.Downloads == 0 {
+		return
+	}
+	sd.served(r, l, complete)
+}
+
+// served logs the reserved download, and ends the sender
+// once the downloads are used up.
+func (sd *sender) served(r *http.Request, l share.Link, complete bool) {
+	if complete {
+		log.Printf("INFO '%s' downloaded by %s (%d of %d).\n", sd.name, r.RemoteAddr, l.Downloads, l.MaxDownloads)
+	} else {
+		log.Printf("WARN '%s' download by %s interrupted (%d of %d).\n", sd.name, r.RemoteAddr, l.Downloads, l.MaxDownloads)
+	}
+	if l.Remaining() == 0 {
+		sd.once.Do(func() { close(sd.done) })
+	}
+}
+
+// serveFile serves the file and reports whether it was sent whole.
+func (sd *sender) serveFile(w http.ResponseWriter, r *http.Request) (share.Link, bool, error) {
+	file, err := os.Open(sd.files[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return share.Link{}, false, nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return share.Link{}, false, nil
+	}
+	return serveLink(sd.store, sd.link.Token, w, r, info, file)
+}
+
+// serveArchive streams the files as a zip archive and reports whether
+// it was written.
+func (sd *sender) serveArchive(w http.ResponseWriter, r *http.Request) (share.Link, bool, error) {
+	entries := []archiveEntry{}
+	for _, file := range sd.files {
+		e, err := archiveEntries(filepath.Dir(file), []string{filepath.Base(file)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return share.Link{}, false, nil
+		}
+		entries = append(entries, e...)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	if r.Method == http.MethodHead {
+		return share.Link{}, false, nil
+	}
+	l, err := sd.store.Reserve(sd.link.Token)
+	if err != nil {
+		return l, false, err
+	}
+	if err := writeArchive(zipArchive{zip.NewWriter(w)}, entries); err != nil {
+		log.Printf("ERROR archive '%s': %s\n", sd.name, err)
+		sd.served(r, l, false)
+		panic(http.ErrAbortHandler)
+	}
+	return l, true, nil
+}
+
+// sendWriter records the status and the size of the response.
+type sendWriter struct {
+	http.ResponseWriter
+	code int
+	n    int64
+}
+
+func (w *sendWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sendWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile of the response writer.
+func (w *sendWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.n += n
+	return n, err
+}
+
+func isRegular(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// send serves the files until they are downloaded the count of times,
+// or the timeout passes, then exits, with status 1 on timeout.
+func (s *server) send() {
+	appCache[ckey_port] = s.port
+	sd, err := newSender(s.files, s.count, s.timeout)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET "+sendPrefix+"{token}/{name}", sd)
+	log.Printf("INFO sending '%s', %d download(s) until %s.\n",
+		sd.name, s.count, sd.link.Expires.Format("15:04:05"))
+	if !s.serveOnce(mux, sd.path(), os.Stdout, sd.done, sd.link.Expires) {
+		log.Printf("WARN '%s' timed out after %s.\n", sd.name, s.timeout)
+		os.Exit(1)
+	}
+	log.Printf("INFO '%s' sent.\n", sd.name)
+}
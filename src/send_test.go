@@ -0,0 +1,130 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test_file.txt")
+	if err := os.WriteFile(file, []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	os.MkdirAll(filepath.Join(dir, "photos"), 0755)
+	os.WriteFile(filepath.Join(dir, "photos", "beach.jpg"), []byte("Haiyaa!!"), 0644)
+
+	serve := func(sd *sender, method, target string, header map[string]string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		mux.Handle("GET "+sendPrefix+"{token}/{name}", sd)
+		r := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	isDone := func(sd *sender) bool {
+		select {
+		case <-sd.done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Run("Downloads Are Reserved", func(t *testing.T) {
+		sd, err := newSender([]string{file}, 3, time.Minute)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if !strings.HasSuffix(sd.path(), "/test_file.txt") {
+			t.Errorf("\nExpected: %s\nActual: %s", "/test_file.txt", sd.path())
+		}
+
+		tcs := []struct {
+			title     string
+			method    string
+			rng       string
+			code      int
+			downloads int
+		}{
+			{"Head Is Not Counted", http.MethodHead, "", http.StatusOK, 0},
+			{"Last Byte Is The Whole File", http.MethodGet, "bytes=-1", http.StatusOK, 1},
+			{"Partial Download Is The Whole File", http.MethodGet, "bytes=4-", http.StatusOK, 2},
+			{"Download Is Counted", http.MethodGet, "", http.StatusOK, 3},
+			{"Downloads Are Used Up", http.MethodGet, "", http.StatusGone, 3},
+		}
+
+		for _, tc := range tcs {
+			w := serve(sd, tc.method, sd.path(), map[string]string{"Range": tc.rng})
+			l, _ := sd.store.Get(sd.link.Token)
+			if w.Code != tc.code || l.Downloads != tc.downloads {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d, %d download(s)\nActual: %d, %d download(s)", tc.title, tc.code, tc.downloads, w.Code, l.Downloads)
+			}
+		}
+		if !isDone(sd) {
+			t.Errorf("\nExpected: done once the downloads are used up")
+		}
+	})
+
+	t.Run("Unknown Token", func(t *testing.T) {
+		sd, _ := newSender([]string{file}, 1, time.Minute)
+		if w := serve(sd, http.MethodGet, sendPrefix+"Fuiyoh/test_file.txt", nil); w.Code != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Several Files Are Archived", func(t *testing.T) {
+		sd, err := newSender([]string{file, filepath.Join(dir, "photos")}, 1, time.Minute)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		w := serve(sd, http.MethodGet, sd.path(), nil)
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		names := []string{}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if expected := "test_file.txt,photos/,photos/beach.jpg," + archiveManifest; strings.Join(names, ",") != expected {
+			t.Errorf("\nExpected: %s\nActual: %s", expected, strings.Join(names, ","))
+		}
+		if !isDone(sd) {
+			t.Errorf("\nExpected: done once the downloads are used up")
+		}
+	})
+
+	t.Run("Invalid Files", func(t *testing.T) {
+		tcs := []struct {
+			title string
+			files []string
+		}{
+			{"Missing File", []string{filepath.Join(dir, "missing_file")}},
+			{"Same Name Twice", []string{file, filepath.Join(dir, "photos", "..", "test_file.txt")}},
+		}
+		for _, tc := range tcs {
+			if _, err := newSender(tc.files, 1, time.Minute); err == nil {
+				t.Errorf("\nTest Data: (%s)\nExpected: error", tc.title)
+			}
+		}
+	})
+}
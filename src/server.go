@@ -5,55 +5,504 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"io"
+	"localfs/mdns"
+	"localfs/s3"
+	"localfs/session"
+	"localfs/share"
+	"localfs/tus"
+	"localfs/util/certutil"
 	"localfs/util/fsutil"
+	"localfs/util/netutil"
+	"localfs/util/sshutil"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type server struct {
-	port string
+	port            string
+	storage         string
+	readOnly        bool
+	createStorage   bool
+	sessionTTL      time.Duration
+	sessionCapacity int
+	auth            bool
+	roles           bool
+	password        string
+	tls             bool
+	tlsCert         string
+	tlsKey          string
+	certificate     *tls.Certificate
+	name            string
+	mdns            bool
+	hosts           []string
+	interfaces      bool
+	filter          netutil.Filter
+	s3Keys          []s3.Key
+	sftp            bool
+	sftpPort        string
+	sftpKeys        string
+	sftpConfig      *ssh.ServerConfig
+	files           []string
+	count           int
+	timeout         time.Duration
 }
 
-func httpServer(port string) *server {
+func httpServer(cfg *config) *server {
 	return &server{
-		port: port,
+		port:            cfg.port,
+		storage:         cfg.storage,
+		readOnly:        cfg.readOnly,
+		createStorage:   !cfg.serve,
+		sessionTTL:      cfg.sessionTTL,
+		sessionCapacity: cfg.sessionCapacity,
+		auth:            cfg.auth,
+		roles:           cfg.roles,
+		password:        cfg.password,
+		tls:             cfg.tls,
+		tlsCert:         cfg.tlsCert,
+		tlsKey:          cfg.tlsKey,
+		name:            cfg.name,
+		mdns:            cfg.mdns,
+		hosts:           cfg.hosts,
+		interfaces:      len(cfg.interfaces) > 0,
+		filter:          cfg.filter(),
+		s3Keys:          cfg.s3Keys,
+		sftp:            cfg.sftp,
+		sftpPort:        cfg.sftpPort,
+		sftpKeys:        cfg.sftpAuthorizedKeys,
+		files:           cfg.files,
+		count:           cfg.count,
+		timeout:         cfg.timeout,
 	}
 }
 
 func (s *server) initRoutes() {
+	routes(http.DefaultServeMux)
+}
+
+// routes registers every route on the mux, with the permission
+// required of the role of the request.
+func routes(mux *http.ServeMux) {
 	// handle index page and all invalid routes
-	http.HandleFunc("/", routesHandler)
+	mux.HandleFunc("/", routesHandler)
 	// handle upload routes
-	http.HandleFunc("/upload", uploadPageHandler)
-	http.HandleFunc("/upload/file", uploadFileHandler)
-	http.HandleFunc("/upload/status", uploadStatusPageHandler)
+	mux.HandleFunc("/upload", uploadPageHandler)
+	mux.HandleFunc("/upload/file", allow(permUpload, writable(uploadFileHandler, errorHandler), errorHandler))
+	mux.HandleFunc("/upload/folder", allow(permUpload, writable(uploadFolderHandler, errorHandler), errorHandler))
+	mux.HandleFunc("/upload/delete", allow(permModify, writable(uploadDeleteHandler, errorHandler), errorHandler))
+	mux.HandleFunc("/upload/rename", allow(permModify, writable(uploadRenameHandler, errorHandler), errorHandler))
+	mux.HandleFunc("/upload/move", allow(permModify, writable(uploadMoveHandler, errorHandler), errorHandler))
+	mux.HandleFunc("/upload/status", allow(permUpload, uploadStatusPageHandler, errorHandler))
 	// handle files download
-	http.Handle("/download/", fileHandler("/download/"))
+	mux.HandleFunc("/download/", allow(permRead, fileHandler("/download/").ServeHTTP, errorHandler))
+	mux.HandleFunc("GET /archive", allow(permRead, archiveHandler, errorHandler))
+	// handle raw uploads, e.g. curl -T
+	mux.HandleFunc("PUT /files/{path...}", allow(permUpload, writable(rawUploadHandler, http.Error), http.Error))
+	mux.HandleFunc("POST /files/{path...}", allow(permUpload, writable(rawUploadHandler, http.Error), http.Error))
+	// handle resumable uploads
+	tusRoutes(mux)
+	// handle share links
+	shareRoutes(mux)
+	// handle JSON API routes
+	apiRoutes(mux)
+	// handle WebDAV mounts
+	davRoutes(mux)
+	// handle S3 clients
+	s3Routes(mux)
+}
+
+// writable rejects the request with the error handler
+// when the storage is shared read-only.
+func writable(h http.HandlerFunc, errorHandler func(http.ResponseWriter, string, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isReadOnly() {
+			errorHandler(w, "the storage is read-only.", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
 }
 
 func (s *server) initStorage() {
-	userHome, err := os.UserHomeDir()
+	path := s.storage
+	if path == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			log.Fatal("FATAL", err)
+		}
+		path = filepath.Join(userHome, defaultStorage)
+	}
+
+	path, err := filepath.Abs(path)
 	if err != nil {
 		log.Fatal("FATAL", err)
 	}
 
-	path := filepath.Join(userHome, defaultStorage)
-	err = fsutil.Mkdir(path)
+	if s.createStorage {
+		err = fsutil.Mkdir(path)
+		if err != nil {
+			log.Fatal("FATAL", err)
+		}
+	}
+
+	// validate permissions before serving
+	info, err := os.Stat(path)
 	if err != nil {
-		log.Fatal("FATAL", err)
+		log.Fatal("FATAL ", err)
+	}
+	if !info.IsDir() {
+		log.Fatalf("FATAL storage '%s' is not a directory.\n", path)
+	}
+	if _, err = os.ReadDir(path); err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	if !s.readOnly {
+		if err = fsutil.CheckWritable(path); err != nil {
+			log.Fatalf("FATAL storage '%s' is not writable, use '--read-only' to share it: %s\n", path, err)
+		}
 	}
 
 	appCache[ckey_storage] = path
+	appCache[ckey_readonly] = strconv.FormatBool(s.readOnly)
+	if s.readOnly {
+		log.Printf("INFO storage '%s' (read-only).\n", path)
+		return
+	}
 	log.Printf("INFO storage '%s'.\n", path)
 }
 
+func (s *server) initSessions() {
+	prgCache = session.NewStore[[]fileInfo](s.sessionTTL, s.sessionCapacity)
+	log.Printf("INFO upload sessions expire after %s (capacity %d).\n",
+		s.sessionTTL, s.sessionCapacity)
+}
+
+func (s *server) initShares() {
+	store, err := share.NewStore()
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	shares = store
+}
+
+func (s *server) initResumable() {
+	if s.readOnly {
+		return
+	}
+
+	store, err := tus.NewStore(filepath.Join(appCache[ckey_storage], tusUploadsDir))
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	if n := store.Purge(tusExpiry); n > 0 {
+		log.Printf("INFO %d expired resumable upload(s) removed.\n", n)
+	}
+	tusStore = store
+}
+
+// initS3 serves the S3-compatible API when access keys are given,
+// multipart uploads need a writable storage.
+func (s *server) initS3() {
+	if len(s.s3Keys) == 0 {
+		return
+	}
+	s3Verifier = s3.NewVerifier(s.s3Keys)
+	log.Printf("INFO S3 endpoint %s, %d access key(s).\n", s3Prefix, len(s.s3Keys))
+	if s.readOnly {
+		return
+	}
+
+	store, err := s3.NewMultipart(filepath.Join(appCache[ckey_storage], s3UploadsDir))
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	if n := store.Purge(s3Expiry); n > 0 {
+		log.Printf("INFO %d expired multipart upload(s) removed.\n", n)
+	}
+	s3Multipart = store
+}
+
+func (s *server) initAuth() {
+	if !s.auth {
+		return
+	}
+
+	a, err := newAuthenticator(s.password, s.roles)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	authn = a
+	if a.password {
+		log.Printf("INFO authentication required, sign in with the password.\n")
+	} else {
+		log.Printf("INFO authentication required, pairing PIN %s.\n", a.secret)
+	}
+	for _, ro := range []role{roleUploader, roleReader} {
+		if pin := a.secretOf(ro); pin != "" {
+			log.Printf("INFO %s role, pairing PIN %s.\n", ro, pin)
+		}
+	}
+}
+
+// initNetwork selects the addresses to listen on, either the given
+// addresses or the IPv4 and IPv6 addresses of the given interfaces.
+// The host keeps the loopback address of each IP version to open
+// the index page. The server listens on every IPv4 and IPv6 address
+// by default.
+func (s *server) initNetwork() {
+	netFilter = s.filter
+	if len(s.hosts) == 0 && s.interfaces {
+		addrs, err := netFilter.IPAddresses()
+		if err != nil {
+			log.Fatal("FATAL ", err)
+		}
+		s.hosts = addrs
+	}
+
+	if len(s.hosts) > 0 && !slices.ContainsFunc(s.hosts, func(host string) bool {
+		ip := netip.MustParseAddr(host)
+		return ip.IsLoopback() || ip.IsUnspecified()
+	}) {
+		hosts := s.hosts
+		if slices.ContainsFunc(hosts, func(host string) bool { return netip.MustParseAddr(host).Is4() }) {
+			s.hosts = append(s.hosts, "127.0.0.1")
+		}
+		if slices.ContainsFunc(hosts, func(host string) bool { return netip.MustParseAddr(host).Is6() }) {
+			s.hosts = append(s.hosts, "::1")
+		}
+	}
+	boundHosts = s.hosts
+
+	addrs := serverAddrs()
+	log.Printf("INFO network address %s.\n", strings.Join(addrs, ", "))
+}
+
+// initMDNS announces the server as <name>.local, the network
+// still works by address when multicast is not available.
+func (s *server) initMDNS() {
+	if !s.mdns {
+		return
+	}
+
+	port, err := strconv.Atoi(s.port)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	ifaces, err := netFilter.Interfaces()
+	if err != nil {
+		log.Printf("WARN mDNS is disabled: %s\n", err)
+		return
+	}
+	svc := mdns.Service{
+		Name:  s.name,
+		Port:  port,
+		Types: []string{"_http._tcp", "_localfs._tcp"},
+		Text:  []string{"path=/upload", "version=" + appBuild},
+	}
+	if s.tls {
+		svc.Types[0] = "_https._tcp"
+	}
+	r, err := mdns.Listen(svc, ifaces)
+	if err != nil {
+		log.Printf("WARN mDNS is disabled: %s\n", err)
+		return
+	}
+
+	hostname := r.Name() + ".local"
+	appCache[ckey_hostname] = hostname
+	if r.Name() != s.name {
+		log.Printf("WARN mDNS name '%s' is in use, renamed to '%s'.\n", s.name, r.Name())
+	}
+	log.Printf("INFO announced by mDNS as '%s'.\n", hostname)
+}
+
+func (s *server) initTLS() {
+	appCache[ckey_tls] = strconv.FormatBool(s.tls)
+	if !s.tls {
+		return
+	}
+
+	cert, err := s.loadCertificate()
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	s.certificate = &cert
+
+	fingerprint := certutil.Fingerprint(cert.Leaf)
+	appCache[ckey_fingerprint] = fingerprint
+	log.Printf("INFO certificate public key fingerprint (SHA-256) %s.\n", fingerprint)
+}
+
+// loadCertificate loads the certificate supplied by the user, or the
+// self-signed certificate of the user config directory.
+func (s *server) loadCertificate() (tls.Certificate, error) {
+	if s.tlsCert != "" {
+		log.Printf("INFO certificate '%s'.\n", s.tlsCert)
+		return certutil.Load(s.tlsCert, s.tlsKey)
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certFile := filepath.Join(configDir, defaultCertFile)
+	keyFile := filepath.Join(configDir, defaultKeyFile)
+
+	// the certificate is valid for every address shown in the QR code
+	cert, created, err := certutil.LoadOrCreate(certFile, keyFile, serverAddrs())
+	if err != nil {
+		return cert, err
+	}
+	if created {
+		log.Printf("INFO self-signed certificate issued '%s'.\n", certFile)
+	} else {
+		log.Printf("INFO self-signed certificate '%s'.\n", certFile)
+	}
+	return cert, nil
+}
+
+// initSFTP configures the SFTP server with the persisted host key
+// of the user config directory, and the authorized keys.
+func (s *server) initSFTP() {
+	if !s.sftp {
+		return
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	keyFile := filepath.Join(configDir, defaultHostKeyFile)
+	hostKey, created, err := sshutil.LoadOrCreateHostKey(keyFile)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	if created {
+		log.Printf("INFO SSH host key created '%s'.\n", keyFile)
+	}
+	log.Printf("INFO SSH host key fingerprint %s.\n", ssh.FingerprintSHA256(hostKey.PublicKey()))
+
+	var keys []ssh.PublicKey
+	if s.sftpKeys != "" {
+		if keys, err = sshutil.LoadAuthorizedKeys(s.sftpKeys); err != nil {
+			log.Fatal("FATAL ", err)
+		}
+		log.Printf("INFO SFTP authorized keys '%s', %d key(s).\n", s.sftpKeys, len(keys))
+	}
+	if authn == nil && len(keys) == 0 {
+		log.Printf("WARN SFTP requires no sign in, use '--auth' or '--sftp-keys'.\n")
+	}
+	s.sftpConfig = sftpConfig(hostKey, keys)
+}
+
 func (s *server) run() {
 	appCache[ckey_port] = s.port
 
-	addr := net.JoinHostPort(defaultHost, s.port)
-	log.Printf("INFO server is listening on %s...\n", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	listeners, err := s.listen(s.port)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	srv := &http.Server{Handler: authHandler(http.DefaultServeMux)}
+	serve := s.serveFunc(srv)
+	protocol := ""
+	if s.certificate != nil {
+		protocol = " (HTTPS)"
+	}
+
+	errc := make(chan error)
+	for _, ln := range listeners {
+		log.Printf("INFO server is listening on %s%s...\n", ln.Addr(), protocol)
+		go func() { errc <- serve(ln) }()
+	}
+	if s.sftpConfig != nil {
+		listeners, err := s.listen(s.sftpPort)
+		if err != nil {
+			log.Fatal("FATAL ", err)
+		}
+		for _, ln := range listeners {
+			log.Printf("INFO SFTP server is listening on %s...\n", ln.Addr())
+			go func() { errc <- sftpListen(ln, s.sftpConfig) }()
+		}
+	}
+	log.Fatal(<-errc)
+}
+
+// listen listens on the port of the addresses of the options,
+// of every address by default.
+func (s *server) listen(port string) ([]net.Listener, error) {
+	hosts := s.hosts
+	if len(hosts) == 0 {
+		hosts = []string{defaultHost}
+	}
+	listeners := []net.Listener{}
+	for _, host := range hosts {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// serveOnce serves the handler of a one-shot subcommand and prints
+// the QR code of the path, until done is closed or the deadline passes.
+// Requests in progress are given a short grace once done is closed, and
+// closed at the deadline, so a stalled client never outlasts the timeout.
+// It reports whether done was closed.
+func (s *server) serveOnce(h http.Handler, p string, qr io.Writer, done <-chan struct{}, deadline time.Time) bool {
+	listeners, err := s.listen(s.port)
+	if err != nil {
+		log.Fatal("FATAL ", err)
+	}
+	srv := &http.Server{Handler: h}
+	serve := s.serveFunc(srv)
+	for _, ln := range listeners {
+		go serve(ln)
+	}
+	if err = printQR(qr, p); err != nil {
+		log.Fatal("FATAL ", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+		srv.Close()
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+	}
+	return true
+}
+
+// serveFunc returns the function serving the server on a listener,
+// over HTTPS once the certificate is loaded.
+func (s *server) serveFunc(srv *http.Server) func(net.Listener) error {
+	if s.certificate == nil {
+		return srv.Serve
+	}
+	srv.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{*s.certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") }
 }
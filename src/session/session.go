@@ -0,0 +1,132 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type entry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// Store is a concurrency-safe in-memory store, entries expire after the
+// time-to-live and the oldest entry is evicted when the store is full.
+type Store[T any] struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	entries  map[string]entry[T]
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewStore returns a store and starts a background janitor which
+// removes expired entries, call Close to stop the janitor.
+func NewStore[T any](ttl time.Duration, capacity int) *Store[T] {
+	s := &Store[T]{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  map[string]entry[T]{},
+		done:     make(chan struct{}),
+	}
+
+	// sweep at least every minute, or more often for short lifetimes
+	interval := min(ttl, time.Minute)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Evict()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Put stores the value and returns its randomly generated id.
+func (s *Store[T]) Put(value T) string {
+	id := uuid.New().String()
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.capacity > 0 && len(s.entries) >= s.capacity {
+		s.evict(now)
+	}
+	// still full, make room by removing the entry closest to expiry
+	for s.capacity > 0 && len(s.entries) >= s.capacity {
+		oldest := ""
+		for key, e := range s.entries {
+			if oldest == "" || e.expires.Before(s.entries[oldest].expires) {
+				oldest = key
+			}
+		}
+		delete(s.entries, oldest)
+	}
+
+	s.entries[id] = entry[T]{value: value, expires: now.Add(s.ttl)}
+	return id
+}
+
+// Get returns the value of an unexpired entry, an entry can be read
+// any number of times until it expires.
+func (s *Store[T]) Get(id string) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok || !time.Now().Before(e.expires) {
+		var zero T
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (s *Store[T]) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+}
+
+func (s *Store[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Evict removes the expired entries and returns the number removed.
+func (s *Store[T]) Evict() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evict(time.Now())
+}
+
+func (s *Store[T]) evict(now time.Time) int {
+	n := 0
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// Close stops the background janitor.
+func (s *Store[T]) Close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
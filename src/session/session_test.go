@@ -0,0 +1,91 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package session_test
+
+import (
+	"localfs/session"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("Entry Is Revisitable Until Expired", func(t *testing.T) {
+		s := session.NewStore[string](200*time.Millisecond, 10)
+		defer s.Close()
+
+		id := s.Put("test_file")
+		for i := 0; i < 2; i++ {
+			actual, ok := s.Get(id)
+			if !ok || actual != "test_file" {
+				t.Errorf("\nExpected: %s\nActual: %s (found %t)", "test_file", actual, ok)
+			}
+		}
+
+		time.Sleep(250 * time.Millisecond)
+		if _, ok := s.Get(id); ok {
+			t.Errorf("\nExpected entry to be expired, but it was found.")
+		}
+	})
+
+	t.Run("Janitor Evicts Expired Entries", func(t *testing.T) {
+		s := session.NewStore[string](100*time.Millisecond, 10)
+		defer s.Close()
+
+		s.Put("test_file_1")
+		s.Put("test_file_2")
+
+		time.Sleep(350 * time.Millisecond)
+		if actual := s.Len(); actual != 0 {
+			t.Errorf("\nExpected: %d\nActual: %d", 0, actual)
+		}
+	})
+
+	t.Run("Capacity Evicts Oldest Entry", func(t *testing.T) {
+		s := session.NewStore[string](time.Minute, 2)
+		defer s.Close()
+
+		first := s.Put("test_file_1")
+		time.Sleep(10 * time.Millisecond)
+		second := s.Put("test_file_2")
+		time.Sleep(10 * time.Millisecond)
+		third := s.Put("test_file_3")
+
+		if actual := s.Len(); actual != 2 {
+			t.Errorf("\nExpected: %d\nActual: %d", 2, actual)
+		}
+		if _, ok := s.Get(first); ok {
+			t.Errorf("\nExpected oldest entry to be evicted, but it was found.")
+		}
+		for _, id := range []string{second, third} {
+			if _, ok := s.Get(id); !ok {
+				t.Errorf("\nExpected entry '%s' to be found.", id)
+			}
+		}
+	})
+
+	t.Run("Concurrent Access", func(t *testing.T) {
+		s := session.NewStore[int](time.Minute, 100)
+		defer s.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := s.Put(i)
+				if actual, ok := s.Get(id); !ok || actual != i {
+					t.Errorf("\nExpected: %d\nActual: %d (found %t)", i, actual, ok)
+				}
+				s.Delete(id)
+			}(i)
+		}
+		wg.Wait()
+
+		if actual := s.Len(); actual != 0 {
+			t.Errorf("\nExpected: %d\nActual: %d", 0, actual)
+		}
+	})
+}
@@ -0,0 +1,501 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"localfs/util/fsutil"
+	"localfs/util/sshutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpConfig returns the SSH server configuration, clients sign in with
+// the PIN or password of a role, or with an authorized key as admin.
+// Without '--auth' and authorized keys, every client is admin as on the
+// web interface.
+func sftpConfig(hostKey ssh.Signer, authorizedKeys []ssh.PublicKey) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		NoClientAuth:  authn == nil && len(authorizedKeys) == 0,
+		ServerVersion: "SSH-2.0-localFS_" + appBuild,
+	}
+	if authn != nil {
+		config.PasswordCallback = func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			ro, ok, limited := authn.verify(meta.RemoteAddr().String(), string(password))
+			if limited {
+				return nil, errors.New("too many attempts, try again later")
+			}
+			if !ok {
+				return nil, errors.New("invalid PIN or password")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"role": string(ro)}}, nil
+		}
+	}
+	if len(authorizedKeys) > 0 {
+		config.PublicKeyCallback = func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !sshutil.Authorized(authorizedKeys, key) {
+				return nil, errors.New("unauthorized key")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"role": string(roleAdmin)}}, nil
+		}
+	}
+	config.AddHostKey(hostKey)
+	return config
+}
+
+// sftpServe serves the sftp subsystem of the sessions of the connection,
+// other channels and commands, e.g. a shell, are rejected.
+func sftpServe(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	ro := roleAdmin
+	if sconn.Permissions != nil && sconn.Permissions.Extensions["role"] != "" {
+		ro, _ = parseRole(sconn.Permissions.Extensions["role"])
+	}
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sftp sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			served := false
+			for req := range requests {
+				// the payload of a subsystem request is its length-prefixed name
+				ok := !served && req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					served = true
+					go func() {
+						server := sftp.NewRequestServer(channel, sftpHandlers(ro))
+						status := uint32(0)
+						if err := server.Serve(); err != nil && err != io.EOF {
+							status = 1
+						}
+						// clients such as scp exit with the status of the session
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+						server.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// sftpHandlers serves the storage with the permissions of the role,
+// as on the upload page, replacing or deleting a file requires the
+// admin role.
+func sftpHandlers(ro role) sftp.Handlers {
+	h := &sftpHandler{ro: ro, uploads: map[string]*sftpUpload{}}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+// sftpHandler is the storage as an SFTP file system. As the other
+// routes, names resolve inside the storage only, and hidden temporary
+// files are neither listed nor reachable.
+type sftpHandler struct {
+	ro role
+
+	// uploads are the files being written in the session by path,
+	// the attributes set on their handles apply to the upload.
+	mu      sync.Mutex
+	uploads map[string]*sftpUpload
+}
+
+// allow returns an error when the role lacks the permission,
+// or when the storage is read-only for a change.
+func (h *sftpHandler) allow(p permission) error {
+	if !h.ro.can(p) || (p != permRead && isReadOnly()) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+func (h *sftpHandler) path(name string) (string, error) {
+	fpath, err := fsutil.SecureJoin(appCache[ckey_storage], name)
+	if errors.Is(err, fsutil.ErrInvalidPath) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return fpath, err
+}
+
+// writablePath returns the path of the name, the storage itself
+// may not be removed or renamed.
+func (h *sftpHandler) writablePath(name string) (string, error) {
+	fpath, err := h.path(name)
+	if err == nil && fpath == appCache[ckey_storage] {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	return fpath, err
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if err := h.allow(permRead); err != nil {
+		return nil, err
+	}
+	fpath, err := h.path(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a folder", r.Filepath)
+	}
+	return f, nil
+}
+
+// Filewrite uploads the file as the other routes, through a hidden
+// temporary file which replaces the file once closed. A file of the
+// name is replaced by the admin role, the other roles reserve the name,
+// or the next available "name(n).ext" when it is taken, as does an
+// exclusive create.
+// Unless truncated, the upload of the admin starts with the content of
+// the file, e.g. as 'reput' resumes and scp writes in place.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if err := h.allow(permUpload); err != nil {
+		return nil, err
+	}
+	fpath, err := h.writablePath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Split(fpath)
+
+	flags := r.Pflags()
+	info, err := os.Lstat(fpath)
+	exists := err == nil
+	if exists && info.IsDir() {
+		return nil, fmt.Errorf("%s is a folder", r.Filepath)
+	}
+	// the name is reserved before the upload, so a concurrent
+	// upload of the name does not replace it once committed
+	reserved, seeded := false, false
+	switch {
+	case flags.Excl:
+		f, err := os.OpenFile(fpath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		reserved = true
+	case !h.ro.can(permModify):
+		if name, err = fsutil.ReserveFile(dir, name); err != nil {
+			return nil, err
+		}
+		reserved = true
+	case exists && !flags.Trunc:
+		seeded = true
+	}
+
+	f, err := fsutil.CreateAtomic(dir, name)
+	if err == nil && seeded {
+		err = seed(f, fpath)
+	}
+	if err != nil {
+		if f != nil {
+			f.Abort()
+		}
+		if reserved {
+			os.Remove(filepath.Join(dir, name))
+		}
+		return nil, err
+	}
+
+	u := &sftpUpload{
+		AtomicFile: f,
+		path:       filepath.Join(dir, name),
+		reserved:   reserved,
+		hash:       sha256.New(),
+		ordered:    !seeded,
+	}
+	h.mu.Lock()
+	h.uploads[fpath] = u
+	h.mu.Unlock()
+	u.done = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.uploads[fpath] == u {
+			delete(h.uploads, fpath)
+		}
+	}
+	return u, nil
+}
+
+// seed copies the content of the file into the upload.
+func seed(f *fsutil.AtomicFile, fpath string) error {
+	src, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(f.File, src)
+	return err
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r)
+	case "Mkdir":
+		if err := h.allow(permUpload); err != nil {
+			return err
+		}
+		fpath, err := h.writablePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return fsutil.CreateDir(filepath.Dir(fpath), filepath.Base(fpath))
+	case "Rename", "PosixRename":
+		if err := h.allow(permModify); err != nil {
+			return err
+		}
+		src, err := h.writablePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		dst, err := h.writablePath(r.Target)
+		if err != nil {
+			return err
+		}
+		// as SFTP version 3, the target is not replaced
+		if _, err := os.Lstat(dst); err == nil && r.Method == "Rename" {
+			return os.ErrExist
+		}
+		return os.Rename(src, dst)
+	case "Remove", "Rmdir":
+		if err := h.allow(permModify); err != nil {
+			return err
+		}
+		fpath, err := h.writablePath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(fpath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() != (r.Method == "Rmdir") {
+			return sftp.ErrSSHFxFailure
+		}
+		// a folder is removed when empty only
+		return os.Remove(fpath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// setstat sets the size and the modified time, e.g. kept by 'put -p',
+// of an upload or a file, other attributes are ignored. Truncating a
+// stored file requires the admin role.
+func (h *sftpHandler) setstat(r *sftp.Request) error {
+	if err := h.allow(permUpload); err != nil {
+		return err
+	}
+	fpath, err := h.writablePath(r.Filepath)
+	if err != nil {
+		return err
+	}
+	flags, attrs := r.AttrFlags(), r.Attributes()
+
+	h.mu.Lock()
+	u := h.uploads[fpath]
+	h.mu.Unlock()
+	if u != nil {
+		return u.setstat(flags, attrs)
+	}
+
+	// stored files are changed by the roles modifying them only
+	if err := h.allow(permModify); err != nil {
+		return err
+	}
+	if flags.Size {
+		if err := os.Truncate(fpath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		return os.Chtimes(fpath, attrs.AccessTime(), attrs.ModTime())
+	}
+	return nil
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		if err := h.allow(permRead); err != nil {
+			return nil, err
+		}
+		fpath, err := h.path(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(fpath)
+		if err != nil {
+			return nil, err
+		}
+		infos := []os.FileInfo{}
+		for _, entry := range entries {
+			// skip in-progress writes
+			if strings.HasPrefix(entry.Name(), fsutil.TempFilePrefix) {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return sftpLister(infos), nil
+	case "Stat":
+		// clients of every role stat the folder to upload into,
+		// the files are only seen by the roles reading them
+		fpath, err := h.path(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(fpath)
+		if err == nil && !info.IsDir() && h.allow(permRead) != nil {
+			err = &fs.PathError{Op: "stat", Path: r.Filepath, Err: fs.ErrNotExist}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type sftpLister []os.FileInfo
+
+func (l sftpLister) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpUpload is the file of an upload, it replaces the stored file on
+// Close unless the session failed. The SHA-256 is computed while written
+// in order, and read back when the client writes out of order.
+type sftpUpload struct {
+	*fsutil.AtomicFile
+	path     string
+	reserved bool
+	done     func()
+
+	mu      sync.Mutex
+	hash    hash.Hash
+	offset  int64
+	ordered bool
+	err     error
+	// times set while writing, applied once stored
+	atime, mtime time.Time
+}
+
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n, err := u.AtomicFile.WriteAt(p, off)
+	if u.ordered && off == u.offset {
+		u.hash.Write(p[:n])
+		u.offset += int64(n)
+	} else {
+		u.ordered = false
+	}
+	return n, err
+}
+
+func (u *sftpUpload) setstat(flags sftp.FileAttrFlags, attrs *sftp.FileStat) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if flags.Size {
+		if err := u.AtomicFile.Truncate(int64(attrs.Size)); err != nil {
+			return err
+		}
+		if int64(attrs.Size) != u.offset {
+			u.ordered = false
+		}
+	}
+	if flags.Acmodtime {
+		u.atime, u.mtime = attrs.AccessTime(), attrs.ModTime()
+	}
+	return nil
+}
+
+// TransferError records the error of the session, e.g. when the
+// client disconnects, so the partial file is discarded.
+func (u *sftpUpload) TransferError(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.err = err
+}
+
+func (u *sftpUpload) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.done()
+	if u.err == nil && !u.ordered {
+		u.hash.Reset()
+		_, u.err = io.Copy(u.hash, io.NewSectionReader(u.AtomicFile, 0, 1<<62))
+	}
+	if u.err == nil {
+		u.err = u.AtomicFile.Commit()
+	} else {
+		u.AtomicFile.Abort()
+	}
+	if u.err != nil {
+		if u.reserved {
+			// release the reserved name
+			os.Remove(u.path)
+		}
+		return u.err
+	}
+
+	if !u.mtime.IsZero() {
+		os.Chtimes(u.path, u.atime, u.mtime)
+	}
+	if info, err := os.Stat(u.path); err == nil {
+		hashCache.Put(u.path, info, fmt.Sprintf("%x", u.hash.Sum(nil)))
+	}
+	return nil
+}
+
+// sftpListen serves SFTP on the listener.
+func sftpListen(ln net.Listener, config *ssh.ServerConfig) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go sftpServe(conn, config)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"localfs/util/fsutil"
+	"localfs/util/sshutil"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// setupSFTP serves SFTP on a loopback address and returns the address.
+func setupSFTP(t *testing.T, config *ssh.ServerConfig) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	t.Cleanup(func() { ln.Close() })
+	go sftpListen(ln, config)
+	return ln.Addr().String()
+}
+
+// sftpDial connects an SFTP client to the server.
+func sftpDial(t *testing.T, addr string, auth ssh.AuthMethod) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "localfs",
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() { c.Close(); conn.Close() })
+	return c, nil
+}
+
+func TestSFTP(t *testing.T) {
+	root := setupStorage(t)
+	setupAuth(t, "")
+	os.MkdirAll(filepath.Join(root, "photos"), 0755)
+	if err := os.WriteFile(filepath.Join(root, "photos", "test_file.txt"), []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	os.WriteFile(filepath.Join(root, "photos", fsutil.TempFilePrefix+"hidden.part"), []byte("Haiyaa!!"), 0644)
+
+	hostKey, _, err := sshutil.LoadOrCreateHostKey(filepath.Join(t.TempDir(), "host_key"))
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	clientKey, _, _ := sshutil.LoadOrCreateHostKey(filepath.Join(t.TempDir(), "client_key"))
+	addr := setupSFTP(t, sftpConfig(hostKey, []ssh.PublicKey{clientKey.PublicKey()}))
+
+	clients := map[role]*sftp.Client{}
+	for _, ro := range roles {
+		c, err := sftpDial(t, addr, ssh.Password(authn.secretOf(ro)))
+		if err != nil {
+			t.Errorf("\nTest Data: (%s)\nError: %s", ro, err)
+			t.FailNow()
+		}
+		clients[ro] = c
+	}
+
+	upload := func(c *sftp.Client, name, content string) error {
+		f, err := c.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write([]byte(content)); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	t.Run("Sign In", func(t *testing.T) {
+		if _, err := sftpDial(t, addr, ssh.Password("Haiyaa!!")); err == nil {
+			t.Errorf("\nExpected: invalid password rejected")
+		}
+		c, err := sftpDial(t, addr, ssh.PublicKeys(clientKey))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		// an authorized key is the admin
+		if err := c.Remove("photos/" + "missing_file"); err == nil || os.IsPermission(err) {
+			t.Errorf("\nExpected: not exist\nActual: %v", err)
+		}
+		if _, err := sftpDial(t, addr, ssh.PublicKeys(hostKey)); err == nil {
+			t.Errorf("\nExpected: unauthorized key rejected")
+		}
+	})
+
+	t.Run("Upload Keeps Existing Files", func(t *testing.T) {
+		if err := upload(clients[roleUploader], "photos/test_file.txt", "Haiyaa!!"); err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if b, _ := os.ReadFile(filepath.Join(root, "photos", "test_file.txt")); string(b) != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!", b)
+		}
+		stored := filepath.Join(root, "photos", "test_file(1).txt")
+		if b, _ := os.ReadFile(stored); string(b) != "Haiyaa!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Haiyaa!!", b)
+		}
+		// the hash is computed while uploading
+		info, _ := os.Stat(stored)
+		hash, ok := hashCache.Get(stored, info)
+		if expected := fmt.Sprintf("%x", sha256.Sum256([]byte("Haiyaa!!"))); !ok || hash != expected {
+			t.Errorf("\nExpected: %s\nActual: %s", expected, hash)
+		}
+
+		// overlapping uploads of a new name keep each other
+		second, err := sftpDial(t, addr, ssh.Password(authn.secretOf(roleUploader)))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		files := []*sftp.File{}
+		for _, c := range []*sftp.Client{clients[roleUploader], second} {
+			f, err := c.Create("new_file.txt")
+			if err != nil {
+				t.Errorf("\nError: %s", err)
+				t.FailNow()
+			}
+			files = append(files, f)
+		}
+		for i, f := range files {
+			f.Write([]byte(fmt.Sprintf("upload %d", i)))
+			if err := f.Close(); err != nil {
+				t.Errorf("\nError: %s", err)
+			}
+		}
+		for i, name := range []string{"new_file.txt", "new_file(1).txt"} {
+			if b, _ := os.ReadFile(filepath.Join(root, name)); string(b) != fmt.Sprintf("upload %d", i) {
+				t.Errorf("\nTest Data: (%s)\nExpected: upload %d\nActual: %s", name, i, b)
+			}
+		}
+
+		// the admin replaces the file
+		if err := upload(clients[roleAdmin], "photos/test_file.txt", "Haiyaa!!"); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+		if b, _ := os.ReadFile(filepath.Join(root, "photos", "test_file.txt")); string(b) != "Haiyaa!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Haiyaa!!", b)
+		}
+	})
+
+	t.Run("Write In Place", func(t *testing.T) {
+		// as scp, the file is written without truncating, then truncated
+		f, err := clients[roleAdmin].OpenFile("photos/test_file(1).txt", os.O_WRONLY)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		f.WriteAt([]byte("Fuiy"), 0)
+		if err := f.Truncate(6); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+
+		stored := filepath.Join(root, "photos", "test_file(1).txt")
+		if b, _ := os.ReadFile(stored); string(b) != "Fuiyaa" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Fuiyaa", b)
+		}
+		info, _ := os.Stat(stored)
+		hash, _ := hashCache.Get(stored, info)
+		if expected := fmt.Sprintf("%x", sha256.Sum256([]byte("Fuiyaa"))); hash != expected {
+			t.Errorf("\nExpected: %s\nActual: %s", expected, hash)
+		}
+	})
+
+	t.Run("Listing Hides Temporary Files", func(t *testing.T) {
+		infos, err := clients[roleReader].ReadDir("photos")
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, []string{"test_file(1).txt", "test_file.txt"}) {
+			t.Errorf("\nExpected: %s\nActual: %s", []string{"test_file(1).txt", "test_file.txt"}, names)
+		}
+
+		f, err := clients[roleReader].Open("photos/test_file.txt")
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		defer f.Close()
+		if b, _ := io.ReadAll(f); string(b) != "Haiyaa!!" {
+			t.Errorf("\nExpected: %s\nActual: %s", "Haiyaa!!", b)
+		}
+	})
+
+	t.Run("Permissions Of The Roles", func(t *testing.T) {
+		tcs := []struct {
+			title string
+			ro    role
+			op    func(c *sftp.Client) error
+			err   bool
+		}{
+			{"Uploader Can Not Read", roleUploader, func(c *sftp.Client) error { _, err := c.Open("photos/test_file.txt"); return err }, true},
+			{"Uploader Can Not List", roleUploader, func(c *sftp.Client) error { _, err := c.ReadDir("photos"); return err }, true},
+			{"Uploader Can Not Remove", roleUploader, func(c *sftp.Client) error { return c.Remove("photos/test_file.txt") }, true},
+			{"Uploader Can Not Stat Files", roleUploader, func(c *sftp.Client) error { _, err := c.Stat("photos/test_file.txt"); return err }, true},
+			{"Uploader Stats Folders", roleUploader, func(c *sftp.Client) error { _, err := c.Stat("photos"); return err }, false},
+			{"Uploader Can Not Change Times", roleUploader, func(c *sftp.Client) error {
+				return c.Chtimes("photos/test_file.txt", time.Now(), time.Unix(0, 0))
+			}, true},
+			{"Uploader Creates Folders", roleUploader, func(c *sftp.Client) error { return c.Mkdir("photos/2024") }, false},
+			{"Reader Can Not Upload", roleReader, func(c *sftp.Client) error { return upload(c, "photos/reader_file", "Haiyaa!!") }, true},
+			{"Reader Can Not Rename", roleReader, func(c *sftp.Client) error { return c.Rename("photos/test_file.txt", "photos/renamed_file") }, true},
+			{"Hidden Names Are Rejected", roleAdmin, func(c *sftp.Client) error {
+				_, err := c.Open("photos/" + fsutil.TempFilePrefix + "hidden.part")
+				return err
+			}, true},
+			// paths are cleaned into the storage
+			{"Paths Stay In The Storage", roleAdmin, func(c *sftp.Client) error { return upload(c, "/../escaped_file", "Haiyaa!!") }, false},
+			{"Storage Can Not Be Removed", roleAdmin, func(c *sftp.Client) error { return c.RemoveDirectory("/") }, true},
+			{"Rename Keeps The Target", roleAdmin, func(c *sftp.Client) error { return c.Rename("photos/test_file.txt", "photos/test_file(1).txt") }, true},
+			{"Admin Changes Times", roleAdmin, func(c *sftp.Client) error {
+				return c.Chtimes("photos/test_file.txt", time.Now(), time.Now().Add(-time.Hour))
+			}, false},
+			{"Admin Removes", roleAdmin, func(c *sftp.Client) error { return c.Remove("photos/test_file(1).txt") }, false},
+		}
+
+		for _, tc := range tcs {
+			t.Run(tc.title, func(t *testing.T) {
+				if err := tc.op(clients[tc.ro]); (err != nil) != tc.err {
+					t.Errorf("\nTest Data: (%s)\nExpected: error %t\nActual: %v", tc.ro, tc.err, err)
+				}
+			})
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escaped_file")); err == nil {
+			t.Errorf("\nExpected: %s\nActual: %s", filepath.Join(root, "escaped_file"), "escaped_file")
+		}
+	})
+
+	t.Run("Interrupted Upload Is Discarded", func(t *testing.T) {
+		c, err := sftpDial(t, addr, ssh.PublicKeys(clientKey))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		f, err := c.Create("photos/partial_file")
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		f.Write([]byte("Haiyaa!!"))
+		c.Close()
+
+		// the temporary file is removed once the server notices
+		for i := 0; i < 100; i++ {
+			entries, _ := os.ReadDir(filepath.Join(root, "photos"))
+			if !slices.ContainsFunc(entries, func(entry os.DirEntry) bool {
+				return strings.HasPrefix(entry.Name(), fsutil.TempFilePrefix) && entry.Name() != fsutil.TempFilePrefix+"hidden.part"
+			}) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, err := os.Stat(filepath.Join(root, "photos", "partial_file")); err == nil {
+			t.Errorf("\nExpected: discarded\nActual: %s", "partial_file")
+		}
+	})
+
+	t.Run("Read-Only Storage", func(t *testing.T) {
+		appCache[ckey_readonly] = "true"
+		t.Cleanup(func() { delete(appCache, ckey_readonly) })
+		if err := upload(clients[roleAdmin], "photos/readonly_file", "Haiyaa!!"); err == nil {
+			t.Errorf("\nExpected: read-only storage rejects uploads")
+		}
+		if _, err := clients[roleAdmin].Stat("photos/test_file.txt"); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+	})
+}
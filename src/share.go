@@ -0,0 +1,372 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"localfs/share"
+	"localfs/util/fsutil"
+	"localfs/view"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// sharePrefix is the route of the share links handed to visitors,
+// which carry their own token and require no session.
+const sharePrefix = "/s/"
+
+// shareCookie proves the password of a protected link,
+// it is scoped to the path of the link.
+const shareCookie = "localfs_share"
+
+// shares is initialized by server.initShares.
+var shares *share.Store
+
+func shareRoutes(mux *http.ServeMux) {
+	// managed by the admin
+	mux.HandleFunc("GET /upload/share", allow(permModify, shareFormHandler, errorHandler))
+	mux.HandleFunc("POST /upload/share", allow(permModify, shareCreateHandler, errorHandler))
+	mux.HandleFunc("GET /upload/shares", allow(permModify, shareListHandler, errorHandler))
+	mux.HandleFunc("GET /upload/share/{token}", allow(permModify, shareLinkHandler, errorHandler))
+	mux.HandleFunc("POST /upload/share/{token}/revoke", allow(permModify, shareRevokeHandler, errorHandler))
+	// opened by the visitor
+	mux.HandleFunc("GET "+sharePrefix+"{token}", sharedFileHandler)
+	mux.HandleFunc("POST "+sharePrefix+"{token}", sharedUnlockHandler)
+	mux.HandleFunc("GET "+sharePrefix+"{token}/download", sharedDownloadHandler)
+}
+
+// shareFormHandler renders the options of a new link of the file "name".
+func shareFormHandler(w http.ResponseWriter, r *http.Request) {
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	name := r.URL.Query().Get("name")
+	if _, err := shareTarget(dir, name); err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+	renderSharePage(w, http.StatusOK, view.SharePageViewModel{
+		NavBar: shareNavBar(dir, "Share"),
+		Dir:    dir,
+		Name:   name,
+	})
+}
+
+func shareCreateHandler(w http.ResponseWriter, r *http.Request) {
+	dir := cleanDir(r.URL.Query().Get("dir"))
+	name := r.PostFormValue("name")
+	rel, err := shareTarget(dir, name)
+	if err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	opts, err := shareOptions(r)
+	if err != nil {
+		renderSharePage(w, http.StatusBadRequest, view.SharePageViewModel{
+			NavBar:  shareNavBar(dir, "Share"),
+			Dir:     dir,
+			Name:    name,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	shares.Purge()
+	l, err := shares.Create(rel, opts)
+	if err != nil {
+		errorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/upload/share/"+l.Token, http.StatusSeeOther)
+}
+
+// shareOptions parses the "expiry" duration, e.g. "24h", the maximum
+// "downloads" and the "password" of the form, empty values are unlimited.
+func shareOptions(r *http.Request) (share.Options, error) {
+	opts := share.Options{Password: r.PostFormValue("password")}
+	if v := r.PostFormValue("expiry"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return opts, errors.New("invalid expiry, e.g. '24h'.")
+		}
+		opts.Expiry = d
+	}
+	if v := r.PostFormValue("downloads"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("invalid number of downloads.")
+		}
+		opts.MaxDownloads = n
+	}
+	return opts, nil
+}
+
+func shareListHandler(w http.ResponseWriter, _ *http.Request) {
+	shares.Purge()
+	items := []view.ShareItem{}
+	for _, l := range shares.List() {
+		items = append(items, shareItem(l))
+	}
+	renderSharePage(w, http.StatusOK, view.SharePageViewModel{
+		NavBar: shareNavBar("", "Share Links"),
+		Shares: items,
+	})
+}
+
+// shareLinkHandler renders the link and its QR code.
+func shareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	l, err := shares.Get(r.PathValue("token"))
+	if err != nil {
+		errorHandler(w, err.Error()+".", shareErrorStatus(err))
+		return
+	}
+
+	item := shareItem(l)
+	item.Base64QRImage, err = qrImage(item.URL)
+	if err != nil {
+		errorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderSharePage(w, http.StatusOK, view.SharePageViewModel{
+		NavBar: shareNavBar(path.Dir(l.Path), "Share"),
+		Link:   item,
+	})
+}
+
+func shareRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := shares.Revoke(r.PathValue("token")); err != nil {
+		errorHandler(w, err.Error()+".", shareErrorStatus(err))
+		return
+	}
+	http.Redirect(w, r, "/upload/shares", http.StatusSeeOther)
+}
+
+// sharedFileHandler renders the shared file to the visitor, the download
+// is a separate request so link previews do not use up the downloads.
+func sharedFileHandler(w http.ResponseWriter, r *http.Request) {
+	renderSharedFile(w, r.PathValue("token"), "", http.StatusOK)
+}
+
+// sharedUnlockHandler verifies the password of the link
+// and continues with the download.
+func sharedUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	value, err := shares.Unlock(token, r.PostFormValue("password"))
+	if err != nil {
+		code := shareErrorStatus(err)
+		if errors.Is(err, share.ErrPassword) {
+			code = http.StatusUnauthorized
+		}
+		renderSharedFile(w, token, err.Error()+".", code)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    value,
+		Path:     sharePrefix + token,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, sharePrefix+token+"/download", http.StatusSeeOther)
+}
+
+// sharedDownloadHandler serves the file of the link, a download is
+// reserved before the file is served.
+func sharedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	l, err := shares.Get(token)
+	if err != nil {
+		renderSharedFile(w, token, "", http.StatusOK)
+		return
+	}
+	value := ""
+	if cookie, err := r.Cookie(shareCookie); err == nil {
+		value = cookie.Value
+	}
+	if !shares.Unlocked(l, value) {
+		http.Redirect(w, r, sharePrefix+token, http.StatusSeeOther)
+		return
+	}
+
+	file, info, err := openStorageFile(l.Path)
+	if err != nil || info.IsDir() {
+		if err == nil {
+			file.Close()
+		}
+		renderSharedFile(w, token, "", http.StatusOK)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	w.Header().Set("Cache-Control", "no-store")
+	if _, _, err = serveLink(shares, token, w, r, info, file); err != nil {
+		renderSharedFile(w, token, "", http.StatusOK)
+	}
+}
+
+// serveLink serves the file of the link, a download is reserved before
+// the file is served, so concurrent requests never exceed the limit. A
+// link with a limit serves the whole file only, as a range would serve
+// the file without using a download. Ranges of a link without a limit
+// are served and not counted. It reports whether the whole file was sent,
+// and the error of a link which is no longer usable, then nothing is sent.
+func serveLink(store *share.Store, token string, w http.ResponseWriter, r *http.Request, info os.FileInfo, content io.ReadSeeker) (share.Link, bool, error) {
+	l, err := store.Get(token)
+	if err != nil {
+		return l, false, err
+	}
+	if l.MaxDownloads > 0 {
+		for _, h := range []string{"Range", "If-Range", "If-Modified-Since", "If-None-Match"} {
+			r.Header.Del(h)
+		}
+	}
+	if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+		if l, err = store.Reserve(token); err != nil {
+			return l, false, err
+		}
+	}
+
+	sw := &sendWriter{ResponseWriter: w}
+	http.ServeContent(sw, r, info.Name(), info.ModTime(), content)
+	return l, r.Method == http.MethodGet && sw.code == http.StatusOK && sw.n == info.Size(), nil
+}
+
+// renderSharedFile renders the page of the link, the message and status
+// code are replaced with the error of a link which is no longer usable.
+func renderSharedFile(w http.ResponseWriter, token, message string, code int) {
+	vm := view.SharedFilePageViewModel{Token: token, Message: message}
+	l, err := shares.Get(token)
+	var info os.FileInfo
+	if err == nil {
+		info, err = sharedFile(l)
+	}
+	if err != nil {
+		vm.Message = err.Error() + "."
+		code = shareErrorStatus(err)
+	} else {
+		vm.Filename = info.Name()
+		vm.Size = view.FormatSize(info.Size())
+		vm.Password = l.Protected
+		vm.Available = true
+		if !l.Expires.IsZero() {
+			vm.Expires = l.Expires.Local().Format("2006-01-02 15:04")
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+
+	t, err := template.New("sharedFilePage").Parse(view.SharedFilePageTmpl)
+	if err != nil {
+		errorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	t.Execute(w, vm)
+}
+
+// sharedFile returns the file info of the link, a removed file
+// or a folder in its place is not found.
+func sharedFile(l share.Link) (os.FileInfo, error) {
+	file, info, err := openStorageFile(l.Path)
+	if err != nil {
+		return nil, share.ErrNotFound
+	}
+	file.Close()
+	if info.IsDir() {
+		return nil, share.ErrNotFound
+	}
+	return info, nil
+}
+
+func renderSharePage(w http.ResponseWriter, code int, vm view.SharePageViewModel) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+
+	t, err := template.New("sharePage").Parse(view.SharePageTmpl)
+	if err != nil {
+		errorHandler(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	t.Execute(w, vm)
+}
+
+// shareTarget returns the path of the file relative to the storage,
+// folders are not shared.
+func shareTarget(dir, name string) (string, error) {
+	if !fsutil.ValidFileName(name) {
+		return "", fsutil.ErrInvalidFileName
+	}
+	rel := path.Join(dir, name)
+	file, info, err := openStorageFile(rel)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	if info.IsDir() {
+		return "", fsutil.ErrInvalidFileName
+	}
+	return rel, nil
+}
+
+// shareNavBar returns the navigation bar of the folder with the page
+// as the active item.
+func shareNavBar(dir, page string) view.NavBar {
+	if dir == "." {
+		dir = ""
+	}
+	navBar := view.Breadcrumbs(dir, view.NavItem{Name: "Home", Link: "/"})
+	navBar.NavItem = append(navBar.NavItem, view.NavItem{Name: navBar.ActiveItem, Link: view.FolderLink(dir)})
+	navBar.ActiveItem = page
+	return navBar
+}
+
+func shareItem(l share.Link) view.ShareItem {
+	item := view.ShareItem{
+		Token:     l.Token,
+		Path:      l.Path,
+		URL:       shareURL(l.Token),
+		Expires:   "never",
+		Downloads: "unlimited",
+		Protected: l.Protected,
+	}
+	if !l.Expires.IsZero() {
+		item.Expires = l.Expires.Local().Format("2006-01-02 15:04")
+	}
+	if n := l.Remaining(); n >= 0 {
+		item.Downloads = strconv.Itoa(n) + " of " + strconv.Itoa(l.MaxDownloads) + " left"
+	}
+	return item
+}
+
+// shareURL returns the link handed to the visitor.
+func shareURL(token string) string {
+	u := serverURL(sharePrefix + token)
+	return u.String()
+}
+
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, share.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, share.ErrExpired), errors.Is(err, share.ErrExhausted):
+		return http.StatusGone
+	case errors.Is(err, share.ErrLocked):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package share keeps the share links of files. A link carries a random
+// token and may expire, limit its downloads or require a password.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("share link not found")
+	ErrExpired   = errors.New("share link has expired")
+	ErrExhausted = errors.New("share link has no downloads left")
+	ErrPassword  = errors.New("incorrect password")
+	// ErrLocked is returned once the password attempts of a link
+	// are exceeded, the link is then unusable.
+	ErrLocked = errors.New("too many password attempts")
+)
+
+// password attempts allowed per link, which limits guessing it
+const maxAttempts = 10
+
+// Options of a new link, zero values are unlimited.
+type Options struct {
+	Expiry       time.Duration
+	MaxDownloads int
+	Password     string
+}
+
+// Link is a share link of the file at the path.
+type Link struct {
+	Token   string
+	Path    string
+	Created time.Time
+	// zero when the link never expires
+	Expires time.Time
+	// zero when the downloads are unlimited
+	MaxDownloads int
+	Downloads    int
+	Protected    bool
+
+	salt     []byte
+	hash     []byte
+	failures int
+}
+
+// Remaining returns the downloads left, or -1 when unlimited.
+func (l Link) Remaining() int {
+	if l.MaxDownloads == 0 {
+		return -1
+	}
+	return max(l.MaxDownloads-l.Downloads, 0)
+}
+
+// valid returns the error of an expired, exhausted or locked link.
+func (l *Link) valid(now time.Time) error {
+	switch {
+	case !l.Expires.IsZero() && !now.Before(l.Expires):
+		return ErrExpired
+	case l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads:
+		return ErrExhausted
+	case l.failures >= maxAttempts:
+		return ErrLocked
+	}
+	return nil
+}
+
+// Store is a concurrency-safe in-memory store of links,
+// the links end when the server stops.
+type Store struct {
+	mu    sync.Mutex
+	links map[string]*Link
+	// signs the unlock values of password-protected links
+	key []byte
+}
+
+func NewStore() (*Store, error) {
+	key, err := random(32)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{links: map[string]*Link{}, key: key}, nil
+}
+
+// Create returns a new link of the path.
+func (s *Store) Create(path string, opts Options) (Link, error) {
+	if opts.Expiry < 0 || opts.MaxDownloads < 0 {
+		return Link{}, errors.New("expiry and downloads must not be negative")
+	}
+	rnd, err := random(18)
+	if err != nil {
+		return Link{}, err
+	}
+
+	now := time.Now()
+	l := &Link{
+		Token:        base64.RawURLEncoding.EncodeToString(rnd),
+		Path:         path,
+		Created:      now,
+		MaxDownloads: opts.MaxDownloads,
+	}
+	if opts.Expiry > 0 {
+		l.Expires = now.Add(opts.Expiry)
+	}
+	if opts.Password != "" {
+		if l.salt, err = random(16); err != nil {
+			return Link{}, err
+		}
+		l.hash = hashPassword(l.salt, opts.Password)
+		l.Protected = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[l.Token] = l
+	return *l, nil
+}
+
+// Get returns the link of the token, expired, exhausted and locked
+// links are returned with their error.
+func (s *Store) Get(token string) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[token]
+	if !ok {
+		return Link{}, ErrNotFound
+	}
+	return *l, l.valid(time.Now())
+}
+
+// Reserve counts a download of the link before it is served, it fails
+// once the link is no longer valid, so concurrent downloads never exceed
+// the limit.
+func (s *Store) Reserve(token string) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[token]
+	if !ok {
+		return Link{}, ErrNotFound
+	}
+	if err := l.valid(time.Now()); err != nil {
+		return *l, err
+	}
+	l.Downloads++
+	return *l, nil
+}
+
+// Unlock verifies the password of the link and returns the value proving
+// it, e.g. for a cookie. Failed attempts are counted and the link locks
+// once the attempts are exceeded.
+func (s *Store) Unlock(token, password string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[token]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if err := l.valid(time.Now()); err != nil {
+		return "", err
+	}
+	if l.Protected && subtle.ConstantTimeCompare(hashPassword(l.salt, password), l.hash) != 1 {
+		l.failures++
+		return "", ErrPassword
+	}
+	return s.sign(token), nil
+}
+
+// Unlocked reports whether the link needs no password,
+// or the value was returned by Unlock.
+func (s *Store) Unlocked(l Link, value string) bool {
+	return !l.Protected || hmac.Equal([]byte(value), []byte(s.sign(l.Token)))
+}
+
+// Revoke removes the link.
+func (s *Store) Revoke(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.links[token]; !ok {
+		return ErrNotFound
+	}
+	delete(s.links, token)
+	return nil
+}
+
+// List returns the valid links, the newest first.
+func (s *Store) List() []Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	links := []Link{}
+	for _, l := range s.links {
+		if l.valid(now) == nil {
+			links = append(links, *l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Created.After(links[j].Created)
+	})
+	return links
+}
+
+// Purge removes the links which are no longer valid,
+// it returns the number of removed links.
+func (s *Store) Purge() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	purged := 0
+	for token, l := range s.links {
+		if l.valid(now) != nil {
+			delete(s.links, token)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (s *Store) sign(token string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hashPassword(salt []byte, password string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
+
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
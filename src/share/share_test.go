@@ -0,0 +1,117 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package share_test
+
+import (
+	"errors"
+	"localfs/share"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStore(t *testing.T) *share.Store {
+	s, err := share.NewStore()
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	t.Run("Link Expires", func(t *testing.T) {
+		s := newStore(t)
+		l, _ := s.Create("test_file", share.Options{Expiry: 100 * time.Millisecond})
+		if _, err := s.Reserve(l.Token); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if _, err := s.Reserve(l.Token); !errors.Is(err, share.ErrExpired) {
+			t.Errorf("\nExpected: %s\nActual: %v", share.ErrExpired, err)
+		}
+		if actual := s.Purge(); actual != 1 {
+			t.Errorf("\nExpected: %d\nActual: %d", 1, actual)
+		}
+		if _, err := s.Get(l.Token); !errors.Is(err, share.ErrNotFound) {
+			t.Errorf("\nExpected: %s\nActual: %v", share.ErrNotFound, err)
+		}
+	})
+
+	t.Run("One-Time Link Is Used Once", func(t *testing.T) {
+		s := newStore(t)
+		l, _ := s.Create("test_file", share.Options{MaxDownloads: 1})
+
+		// concurrent downloads may not exceed the limit
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		used := 0
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.Reserve(l.Token); err == nil {
+					mu.Lock()
+					used++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if used != 1 {
+			t.Errorf("\nExpected: %d\nActual: %d", 1, used)
+		}
+		if _, err := s.Get(l.Token); !errors.Is(err, share.ErrExhausted) {
+			t.Errorf("\nExpected: %s\nActual: %v", share.ErrExhausted, err)
+		}
+	})
+
+	t.Run("Password Unlocks The Link", func(t *testing.T) {
+		s := newStore(t)
+		l, _ := s.Create("test_file", share.Options{Password: "Fuiyoh!!"})
+		other, _ := s.Create("test_file", share.Options{Password: "Fuiyoh!!"})
+
+		if s.Unlocked(l, "") {
+			t.Errorf("\nExpected the link to be locked.")
+		}
+		if _, err := s.Unlock(l.Token, "Haiyaa!!"); !errors.Is(err, share.ErrPassword) {
+			t.Errorf("\nExpected: %s\nActual: %v", share.ErrPassword, err)
+		}
+		value, err := s.Unlock(l.Token, "Fuiyoh!!")
+		if err != nil || !s.Unlocked(l, value) {
+			t.Errorf("\nExpected the link to be unlocked, but got %v.", err)
+		}
+		// the value is bound to the token
+		if s.Unlocked(other, value) {
+			t.Errorf("\nExpected another link to stay locked.")
+		}
+	})
+
+	t.Run("Password Attempts Are Limited", func(t *testing.T) {
+		s := newStore(t)
+		l, _ := s.Create("test_file", share.Options{Password: "Fuiyoh!!"})
+		for range 10 {
+			s.Unlock(l.Token, "Haiyaa!!")
+		}
+		if _, err := s.Unlock(l.Token, "Fuiyoh!!"); !errors.Is(err, share.ErrLocked) {
+			t.Errorf("\nExpected: %s\nActual: %v", share.ErrLocked, err)
+		}
+	})
+
+	t.Run("Revoked Link Is Not Found", func(t *testing.T) {
+		s := newStore(t)
+		l, _ := s.Create("test_file", share.Options{})
+		if actual := len(s.List()); actual != 1 {
+			t.Errorf("\nExpected: %d\nActual: %d", 1, actual)
+		}
+		if err := s.Revoke(l.Token); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+		if _, err := s.Reserve(l.Token); !errors.Is(err, share.ErrNotFound) {
+			t.Errorf("\nExpected: %s\nActual: %v", share.ErrNotFound, err)
+		}
+	})
+}
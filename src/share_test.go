@@ -0,0 +1,210 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"localfs/share"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setupShares initializes the share links store.
+func setupShares(t *testing.T) {
+	s, err := share.NewStore()
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	shares = s
+	t.Cleanup(func() { shares = nil })
+}
+
+func TestShareLink(t *testing.T) {
+	root := setupStorage(t)
+	setupShares(t)
+	setupAuth(t, "")
+	os.Mkdir(filepath.Join(root, "photos"), 0755)
+	if err := os.WriteFile(filepath.Join(root, "photos", "test_file"), []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	mux := http.NewServeMux()
+	shareRoutes(mux)
+	h := authHandler(mux)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	// create returns the token of a new link, created by the owner
+	create := func(form url.Values) string {
+		r := httptest.NewRequest(http.MethodPost, "/upload/share?dir=photos", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+authn.secret)
+		w := serve(r)
+		token, ok := strings.CutPrefix(w.Header().Get("Location"), "/upload/share/")
+		if w.Code != http.StatusSeeOther || !ok {
+			t.Errorf("\nExpected: %d /upload/share/<token>\nActual: %d %s",
+				http.StatusSeeOther, w.Code, w.Header().Get("Location"))
+			t.FailNow()
+		}
+		return token
+	}
+
+	t.Run("Owner Requires A Session", func(t *testing.T) {
+		w := serve(httptest.NewRequest(http.MethodGet, "/upload/share?dir=photos&name=test_file", nil))
+		if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/login") {
+			t.Errorf("\nExpected: %d /login\nActual: %d %s",
+				http.StatusSeeOther, w.Code, w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("One-Time Link Is Downloaded Once", func(t *testing.T) {
+		token := create(url.Values{"name": {"test_file"}, "downloads": {"1"}})
+
+		// the page does not use up the download
+		for range 2 {
+			if w := serve(httptest.NewRequest(http.MethodGet, "/s/"+token, nil)); w.Code != http.StatusOK {
+				t.Errorf("\nExpected: %d\nActual: %d", http.StatusOK, w.Code)
+			}
+		}
+
+		w := serve(httptest.NewRequest(http.MethodGet, "/s/"+token+"/download", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %d %s\nActual: %d %s", http.StatusOK, "Fuiyoh!!", w.Code, w.Body.String())
+		}
+		w = serve(httptest.NewRequest(http.MethodGet, "/s/"+token+"/download", nil))
+		if w.Code != http.StatusGone {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusGone, w.Code)
+		}
+	})
+
+	t.Run("Limited Link Serves The Whole File", func(t *testing.T) {
+		limited := create(url.Values{"name": {"test_file"}, "downloads": {"2"}})
+		unlimited := create(url.Values{"name": {"test_file"}})
+		download := func(token, rng string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/s/"+token+"/download", nil)
+			r.Header.Set("Range", rng)
+			return serve(r)
+		}
+
+		tcs := []struct {
+			title string
+			token string
+			rng   string
+			code  int
+			body  string
+		}{
+			{"Suffix Range Uses A Download", limited, "bytes=-4", http.StatusOK, "Fuiyoh!!"},
+			{"Partial Range Uses A Download", limited, "bytes=0-6", http.StatusOK, "Fuiyoh!!"},
+			{"Downloads Are Used Up", limited, "bytes=-1", http.StatusGone, ""},
+			{"Unlimited Link Serves Ranges", unlimited, "bytes=-4", http.StatusPartialContent, "oh!!"},
+		}
+		for _, tc := range tcs {
+			w := download(tc.token, tc.rng)
+			if w.Code != tc.code || (tc.body != "" && w.Body.String() != tc.body) {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d %s\nActual: %d %s", tc.title, tc.code, tc.body, w.Code, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("Concurrent Downloads Do Not Exceed The Limit", func(t *testing.T) {
+		token := create(url.Values{"name": {"test_file"}, "downloads": {"1"}})
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		served := 0
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := serve(httptest.NewRequest(http.MethodGet, "/s/"+token+"/download", nil))
+				if w.Code == http.StatusOK && w.Body.String() == "Fuiyoh!!" {
+					mu.Lock()
+					served++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if served != 1 {
+			t.Errorf("\nExpected: %d\nActual: %d", 1, served)
+		}
+	})
+
+	t.Run("Password Protected Link", func(t *testing.T) {
+		token := create(url.Values{"name": {"test_file"}, "password": {"Haiyaa!!"}})
+
+		w := serve(httptest.NewRequest(http.MethodGet, "/s/"+token+"/download", nil))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/s/"+token {
+			t.Errorf("\nExpected: %d /s/%s\nActual: %d %s", http.StatusSeeOther, token, w.Code, w.Header().Get("Location"))
+		}
+
+		unlock := func(password string) *httptest.ResponseRecorder {
+			form := url.Values{"password": {password}}
+			r := httptest.NewRequest(http.MethodPost, "/s/"+token, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return serve(r)
+		}
+		if w := unlock("wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusUnauthorized, w.Code)
+		}
+
+		w = unlock("Haiyaa!!")
+		cookies := w.Result().Cookies()
+		if w.Code != http.StatusSeeOther || len(cookies) != 1 {
+			t.Errorf("\nExpected: %d with a cookie\nActual: %d %v", http.StatusSeeOther, w.Code, cookies)
+			t.FailNow()
+		}
+		r := httptest.NewRequest(http.MethodGet, w.Header().Get("Location"), nil)
+		r.AddCookie(cookies[0])
+		if w := serve(r); w.Code != http.StatusOK || w.Body.String() != "Fuiyoh!!" {
+			t.Errorf("\nExpected: %d %s\nActual: %d %s", http.StatusOK, "Fuiyoh!!", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Revoked Link Is Not Found", func(t *testing.T) {
+		token := create(url.Values{"name": {"test_file"}})
+
+		r := httptest.NewRequest(http.MethodPost, "/upload/share/"+token+"/revoke", nil)
+		r.Header.Set("Authorization", "Bearer "+authn.secret)
+		if w := serve(r); w.Code != http.StatusSeeOther {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusSeeOther, w.Code)
+		}
+		if w := serve(httptest.NewRequest(http.MethodGet, "/s/"+token+"/download", nil)); w.Code != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Folders And Invalid Options Are Rejected", func(t *testing.T) {
+		for _, form := range []url.Values{
+			{"name": {"missing"}},
+			{"name": {"../test_file"}},
+			{"name": {"test_file"}, "expiry": {"tomorrow"}},
+			{"name": {"test_file"}, "downloads": {"-1"}},
+		} {
+			r := httptest.NewRequest(http.MethodPost, "/upload/share?dir=photos", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Authorization", "Bearer "+authn.secret)
+			if w := serve(r); w.Code == http.StatusSeeOther {
+				t.Errorf("\nTest Data: (%v)\nExpected: rejected\nActual: %d", form, w.Code)
+			}
+		}
+		r := httptest.NewRequest(http.MethodPost, "/upload/share", strings.NewReader("name=photos"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+authn.secret)
+		if w := serve(r); w.Code != http.StatusBadRequest {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
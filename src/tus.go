@@ -0,0 +1,302 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"localfs/tus"
+	"localfs/util/fsutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const tusPrefix = "/upload/tus/"
+
+const (
+	// hidden folder of the in-progress uploads, inside the storage
+	// so complete uploads are moved by rename
+	tusUploadsDir string = fsutil.TempFilePrefix + "uploads"
+	// uploads not resumed within the expiry are removed
+	tusExpiry time.Duration = 24 * time.Hour
+	// a stalled chunk fails after the idle timeout,
+	// which releases the upload for the resuming client
+	tusIdleTimeout time.Duration = 30 * time.Second
+)
+
+// status code of the checksum extension
+const statusChecksumMismatch = 460
+
+// tusStore is nil when the storage is read-only,
+// it is initialized by server.initResumable.
+var tusStore *tus.Store
+
+// tusRoutes registers the tus 1.0 resumable upload protocol with the
+// creation, termination and checksum extensions, uploads are created at
+// the prefix with the "filename", "dir" and optional "relativePath" metadata.
+func tusRoutes(mux *http.ServeMux) {
+	mux.Handle("OPTIONS "+tusPrefix, tusHandler(tusOptionsHandler))
+	mux.Handle("POST "+tusPrefix+"{$}", tusHandler(allow(permUpload, writable(tusCreateHandler, http.Error), http.Error)))
+	mux.Handle("HEAD "+tusPrefix+"{id}", tusHandler(allow(permUpload, tusHeadHandler, http.Error)))
+	mux.Handle("PATCH "+tusPrefix+"{id}", tusHandler(allow(permUpload, writable(tusPatchHandler, http.Error), http.Error)))
+	mux.Handle("DELETE "+tusPrefix+"{id}", tusHandler(allow(permUpload, writable(tusTerminateHandler, http.Error), http.Error)))
+}
+
+// tusHandler sets the protocol version and rejects requests of another
+// version, the OPTIONS request is exempt.
+func tusHandler(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tus.Version)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tus.Version {
+			w.Header().Set("Tus-Version", tus.Version)
+			http.Error(w, "unsupported tus version.", http.StatusPreconditionFailed)
+			return
+		}
+		if tusStore == nil && r.Method != http.MethodOptions {
+			http.Error(w, "the storage is read-only.", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	})
+}
+
+func tusOptionsHandler(w http.ResponseWriter, _ *http.Request) {
+	h := w.Header()
+	h.Set("Tus-Version", tus.Version)
+	h.Set("Tus-Extension", tus.Extensions)
+	h.Set("Tus-Checksum-Algorithm", tus.ChecksumAlgorithms)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tusCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred upload length is not supported.", http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "invalid upload length.", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := tus.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err = tusTarget(metadata); err != nil {
+		storageErrorHandler(w, err)
+		return
+	}
+
+	tusStore.Purge(tusExpiry)
+	info, err := tusStore.Create(size, metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// an empty file is complete on creation
+	if info.Complete() {
+		info, err = tusStore.Finish(info.ID, tusMover(info))
+		if err != nil {
+			tusStore.Terminate(info.ID)
+			storageErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("X-Upload-Status", info.Result)
+	}
+
+	w.Header().Set("Location", tusPrefix+info.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func tusHeadHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := tusStore.Get(r.PathValue("id"))
+	if err != nil {
+		tusErrorHandler(w, err)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	if len(info.Metadata) > 0 {
+		h.Set("Upload-Metadata", tus.EncodeMetadata(info.Metadata))
+	}
+	if info.Result != "" {
+		h.Set("X-Upload-Status", info.Result)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func tusPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "content type must be application/offset+octet-stream.", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid upload offset.", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	unlock, err := tusStore.Lock(id)
+	if err != nil {
+		tusErrorHandler(w, err)
+		return
+	}
+	defer unlock()
+
+	rc := http.NewResponseController(w)
+	body := &idleReader{r: r.Body, rc: rc}
+	// keep-alive connections have no deadline
+	defer rc.SetReadDeadline(time.Time{})
+	info, err := tusStore.Write(id, offset, body, r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		tusErrorHandler(w, err)
+		return
+	}
+
+	if info.Complete() {
+		info, err = tusStore.Finish(id, tusMover(info))
+		if err != nil {
+			// the client may retry with an empty chunk at the final offset
+			storageErrorHandler(w, err)
+			return
+		}
+		w.Header().Set("X-Upload-Status", info.Result)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tusTerminateHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	unlock, err := tusStore.Lock(id)
+	if err != nil {
+		tusErrorHandler(w, err)
+		return
+	}
+	defer unlock()
+
+	if err = tusStore.Terminate(id); err != nil {
+		tusErrorHandler(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusTarget returns the folder and the file name of the upload metadata,
+// the "relativePath" of folder uploads, e.g. "photos/2024/a.jpg", is kept
+// as the name.
+func tusTarget(metadata map[string]string) (dir, name string, err error) {
+	name = metadata["relativePath"]
+	if name == "" {
+		name = metadata["filename"]
+	}
+	if name == "" {
+		name = metadata["name"]
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if !fsutil.ValidFileName(segment) {
+			return "", "", fsutil.ErrInvalidFileName
+		}
+	}
+
+	dir = cleanDir(metadata["dir"])
+	_, err = storageDir(dir)
+	return dir, name, err
+}
+
+// tusMover returns the function moving the complete upload into its folder,
+// which returns the upload status link.
+func tusMover(info tus.Info) func(string) (string, error) {
+	return func(data string) (string, error) {
+		dir, name, err := tusTarget(info.Metadata)
+		if err != nil {
+			return "", err
+		}
+		fpath, err := storageDir(dir)
+		if err != nil {
+			return "", err
+		}
+		sub, fname := path.Split(name)
+		if sub != "" {
+			if fpath, err = fsutil.CreateDirAll(fpath, sub); err != nil {
+				return "", err
+			}
+			dir = path.Join(dir, cleanDir(sub))
+		}
+
+		file, err := os.Open(data)
+		if err != nil {
+			return "", err
+		}
+		hash, err := fsutil.Sha256sum(file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+
+		fname, err = fsutil.MoveAs(data, fpath, fname)
+		if err != nil {
+			return "", err
+		}
+		stored := filepath.Join(fpath, fname)
+		if stat, err := os.Stat(stored); err == nil {
+			hashCache.Put(stored, stat, hash)
+		}
+
+		uid := prgCache.Put([]fileInfo{{
+			dir:  dir,
+			name: fname,
+			size: info.Size,
+			hash: hash,
+		}})
+		return "/upload/status?uid=" + uid, nil
+	}
+}
+
+func tusErrorHandler(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tus.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, tus.ErrOffsetMismatch), errors.Is(err, tus.ErrComplete):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, tus.ErrLocked):
+		http.Error(w, err.Error(), http.StatusLocked)
+	case errors.Is(err, tus.ErrChecksumMismatch):
+		http.Error(w, err.Error(), statusChecksumMismatch)
+	case errors.Is(err, tus.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, tus.ErrUnsupportedChecksum), errors.Is(err, tus.ErrInvalidChecksum):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// idleReader extends the read deadline of the connection on every read,
+// so a stalled client, e.g. a phone with a locked screen, fails the
+// request after the idle timeout instead of holding the upload lock.
+type idleReader struct {
+	r  io.Reader
+	rc *http.ResponseController
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	// not supported by every ResponseWriter, e.g. in tests
+	ir.rc.SetReadDeadline(time.Now().Add(tusIdleTimeout))
+	return ir.r.Read(p)
+}
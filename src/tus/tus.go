@@ -0,0 +1,379 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+// Package tus persists the state of resumable uploads of the tus protocol,
+// see https://tus.io/protocols/resumable-upload.
+package tus
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Version = "1.0.0"
+	// Extensions supported by the store
+	Extensions = "creation,termination,checksum"
+	// ChecksumAlgorithms supported by the checksum extension
+	ChecksumAlgorithms = "md5,sha1,sha256"
+)
+
+var (
+	ErrNotFound         = errors.New("upload not found")
+	ErrOffsetMismatch   = errors.New("upload offset does not match")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrLocked           = errors.New("upload is in progress")
+	ErrComplete         = errors.New("upload is complete")
+	ErrTooLarge         = errors.New("chunk exceeds the upload length")
+)
+
+// Info is the persisted state of an upload.
+type Info struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Created  time.Time         `json:"created"`
+	Updated  time.Time         `json:"updated"`
+	// Result is recorded once the upload is complete and moved,
+	// e.g. the location of the stored file.
+	Result string `json:"result,omitempty"`
+}
+
+// Complete reports whether every byte of the upload has been received.
+func (i Info) Complete() bool {
+	return i.Offset == i.Size
+}
+
+// Store keeps each upload as a data file and a JSON info file in the
+// directory, offsets survive client disconnects and server restarts.
+type Store struct {
+	dir string
+
+	mu    sync.Mutex
+	locks map[string]bool
+}
+
+// NewStore returns the store of the directory, creating it when missing.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, locks: map[string]bool{}}, nil
+}
+
+// Create creates an empty upload of the size.
+func (s *Store) Create(size int64, metadata map[string]string) (Info, error) {
+	rnd := make([]byte, 16)
+	if _, err := rand.Read(rnd); err != nil {
+		return Info{}, err
+	}
+
+	now := time.Now().UTC()
+	info := Info{
+		ID:       hex.EncodeToString(rnd),
+		Size:     size,
+		Metadata: metadata,
+		Created:  now,
+		Updated:  now,
+	}
+
+	f, err := os.OpenFile(s.DataPath(info.ID), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return Info{}, err
+	}
+	f.Close()
+
+	if err = s.save(info); err != nil {
+		os.Remove(s.DataPath(info.ID))
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// Get returns the upload, the offset is reconciled with the data file
+// as a crash may lose the data written since the last sync.
+func (s *Store) Get(id string) (Info, error) {
+	if !validID(id) {
+		return Info{}, ErrNotFound
+	}
+
+	byt, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	var info Info
+	if err = json.Unmarshal(byt, &info); err != nil {
+		return Info{}, err
+	}
+	if info.Result != "" {
+		// the data file has been moved
+		return info, nil
+	}
+
+	stat, err := os.Stat(s.DataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	if stat.Size() < info.Offset {
+		info.Offset = stat.Size()
+	}
+	return info, nil
+}
+
+// Lock locks the upload against concurrent writes, it fails with
+// ErrLocked while another request holds the lock.
+func (s *Store) Lock(id string) (unlock func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[id] {
+		return nil, ErrLocked
+	}
+	s.locks[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.locks, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+// Write appends the chunk at the offset to the upload of a locked id. Data
+// received before the chunk fails is kept, unless a checksum is given, e.g.
+// "sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=", as then the whole chunk is verified.
+func (s *Store) Write(id string, offset int64, chunk io.Reader, checksum string) (Info, error) {
+	info, err := s.Get(id)
+	if err != nil {
+		return info, err
+	}
+	if info.Result != "" {
+		return info, ErrComplete
+	}
+	if offset != info.Offset {
+		return info, ErrOffsetMismatch
+	}
+
+	var h hash.Hash
+	var sum []byte
+	if checksum != "" {
+		h, sum, err = parseChecksum(checksum)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	f, err := os.OpenFile(s.DataPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	// discard data beyond the last persisted offset
+	if err = f.Truncate(info.Offset); err != nil {
+		return info, err
+	}
+	if _, err = f.Seek(info.Offset, io.SeekStart); err != nil {
+		return info, err
+	}
+
+	// read one more byte to detect chunks exceeding the size
+	remaining := info.Size - info.Offset
+	w := io.Writer(f)
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	n, copyErr := io.Copy(w, io.LimitReader(chunk, remaining+1))
+	if n > remaining {
+		copyErr = ErrTooLarge
+	}
+	if copyErr == nil && h != nil && !bytes.Equal(h.Sum(nil), sum) {
+		copyErr = ErrChecksumMismatch
+	}
+
+	if copyErr == nil || (h == nil && !errors.Is(copyErr, ErrTooLarge)) {
+		info.Offset += n
+	}
+	if err = f.Truncate(info.Offset); err != nil {
+		return info, err
+	}
+	if err = f.Sync(); err != nil {
+		return info, err
+	}
+
+	info.Updated = time.Now().UTC()
+	if err = s.save(info); err != nil {
+		return info, err
+	}
+	return info, copyErr
+}
+
+// Finish moves the data of a complete upload of a locked id with the
+// move function, and records its result, e.g. the location of the file.
+func (s *Store) Finish(id string, move func(path string) (string, error)) (Info, error) {
+	info, err := s.Get(id)
+	if err != nil || info.Result != "" {
+		return info, err
+	}
+	if !info.Complete() {
+		return info, ErrOffsetMismatch
+	}
+
+	result, err := move(s.DataPath(id))
+	if err != nil {
+		return info, err
+	}
+	info.Result = result
+	info.Updated = time.Now().UTC()
+	return info, s.save(info)
+}
+
+// Terminate removes the upload and its data.
+func (s *Store) Terminate(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	os.Remove(s.DataPath(id))
+	return os.Remove(s.infoPath(id))
+}
+
+// Purge removes the uploads not updated within the duration,
+// it returns the number of removed uploads.
+func (s *Store) Purge(age time.Duration) int {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			// data without info, e.g. interrupted creation
+			id, ok = strings.CutSuffix(entry.Name(), ".bin")
+			if _, err := os.Stat(s.infoPath(id)); ok && errors.Is(err, fs.ErrNotExist) {
+				if stat, err := entry.Info(); err == nil && time.Since(stat.ModTime()) >= age {
+					os.Remove(s.DataPath(id))
+				}
+			}
+			continue
+		}
+		info, err := s.Get(id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err == nil && time.Since(info.Updated) < age {
+			continue
+		}
+		os.Remove(s.DataPath(id))
+		os.Remove(s.infoPath(id))
+		purged++
+	}
+	return purged
+}
+
+// DataPath returns the path of the uploaded data.
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// save writes the info atomically.
+func (s *Store) save(info Info) error {
+	byt, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	temp := s.infoPath(info.ID) + ".tmp"
+	if err = os.WriteFile(temp, byt, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.infoPath(info.ID))
+}
+
+// ParseMetadata parses the Upload-Metadata header, comma separated
+// key and base64 encoded value pairs.
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		byt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil || key == "" {
+			return nil, errors.New("invalid upload metadata")
+		}
+		metadata[key] = string(byt)
+	}
+	return metadata, nil
+}
+
+// EncodeMetadata encodes the metadata as the Upload-Metadata header.
+func EncodeMetadata(metadata map[string]string) string {
+	pairs := []string{}
+	for key, value := range metadata {
+		// keys without value are allowed
+		if value == "" {
+			pairs = append(pairs, key)
+			continue
+		}
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+var (
+	// ErrUnsupportedChecksum is returned for checksums
+	// of an algorithm not in ChecksumAlgorithms.
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	ErrInvalidChecksum     = errors.New("invalid upload checksum")
+)
+
+func parseChecksum(checksum string) (hash.Hash, []byte, error) {
+	algorithm, value, _ := strings.Cut(checksum, " ")
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, nil, ErrInvalidChecksum
+	}
+
+	switch algorithm {
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	}
+	return nil, nil, ErrUnsupportedChecksum
+}
+
+func validID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package tus_test
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"localfs/tus"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader fails after reading the data, as a client disconnect.
+type failingReader struct {
+	r io.Reader
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := tus.NewStore(dir)
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	info, err := store.Create(16, map[string]string{"filename": "test_file"})
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	t.Run("Partial Chunk Is Kept", func(t *testing.T) {
+		info, err := store.Write(info.ID, 0, &failingReader{strings.NewReader("Fuiyoh!!")}, "")
+		if !errors.Is(err, io.ErrUnexpectedEOF) || info.Offset != 8 {
+			t.Errorf("\nExpected: 8 %v\nActual: %d %v", io.ErrUnexpectedEOF, info.Offset, err)
+		}
+	})
+
+	t.Run("Offset Survives Restart", func(t *testing.T) {
+		restarted, err := tus.NewStore(dir)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		actual, err := restarted.Get(info.ID)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if actual.Offset != 8 || !reflect.DeepEqual(actual.Metadata, info.Metadata) {
+			t.Errorf("\nExpected: 8 %v\nActual: %d %v", info.Metadata, actual.Offset, actual.Metadata)
+		}
+	})
+
+	t.Run("Offset Mismatch Is Rejected", func(t *testing.T) {
+		_, err := store.Write(info.ID, 0, strings.NewReader("Haiyaa!!"), "")
+		if !errors.Is(err, tus.ErrOffsetMismatch) {
+			t.Errorf("\nExpected: %v\nActual: %v", tus.ErrOffsetMismatch, err)
+		}
+	})
+
+	t.Run("Checksum Mismatch Discards Chunk", func(t *testing.T) {
+		sum := sha1.Sum([]byte("Fuiyoh!!"))
+		checksum := "sha1 " + base64.StdEncoding.EncodeToString(sum[:])
+		actual, err := store.Write(info.ID, 8, strings.NewReader("Haiyaa!!"), checksum)
+		if !errors.Is(err, tus.ErrChecksumMismatch) || actual.Offset != 8 {
+			t.Errorf("\nExpected: 8 %v\nActual: %d %v", tus.ErrChecksumMismatch, actual.Offset, err)
+		}
+
+		actual, err = store.Write(info.ID, 8, strings.NewReader("Fuiyoh!!"), checksum)
+		if err != nil || !actual.Complete() {
+			t.Errorf("\nExpected: complete\nActual: %d %v", actual.Offset, err)
+		}
+	})
+
+	t.Run("Finish Moves Data", func(t *testing.T) {
+		actual, err := store.Finish(info.ID, func(path string) (string, error) {
+			byt, err := os.ReadFile(path)
+			if string(byt) != "Fuiyoh!!Fuiyoh!!" {
+				t.Errorf("\nExpected: Fuiyoh!!Fuiyoh!!\nActual: %s", byt)
+			}
+			return "stored", errors.Join(err, os.Remove(path))
+		})
+		if err != nil || actual.Result != "stored" {
+			t.Errorf("\nExpected: stored\nActual: %s %v", actual.Result, err)
+		}
+		if actual, err = store.Get(info.ID); err != nil || actual.Result != "stored" {
+			t.Errorf("\nExpected: stored\nActual: %s %v", actual.Result, err)
+		}
+	})
+
+	t.Run("Chunk Exceeding Size Is Rejected", func(t *testing.T) {
+		info, _ := store.Create(4, nil)
+		_, err := store.Write(info.ID, 0, strings.NewReader("Fuiyoh!!"), "")
+		if !errors.Is(err, tus.ErrTooLarge) {
+			t.Errorf("\nExpected: %v\nActual: %v", tus.ErrTooLarge, err)
+		}
+	})
+
+	t.Run("Concurrent Writes Are Locked", func(t *testing.T) {
+		unlock, err := store.Lock(info.ID)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if _, err = store.Lock(info.ID); !errors.Is(err, tus.ErrLocked) {
+			t.Errorf("\nExpected: %v\nActual: %v", tus.ErrLocked, err)
+		}
+		unlock()
+	})
+
+	t.Run("Expired Uploads Are Purged", func(t *testing.T) {
+		if n := store.Purge(time.Hour); n != 0 {
+			t.Errorf("\nExpected: 0\nActual: %d", n)
+		}
+		store.Purge(0)
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("\nExpected: empty store\nActual: %d entries", len(entries))
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	// initialize testcases
+	tcs := []struct {
+		header   string
+		expected map[string]string
+	}{
+		{"", map[string]string{}},
+		{"filename dGVzdF9maWxl,dir", map[string]string{"filename": "test_file", "dir": ""}},
+		{"filename 8J+Ygi50eHQ=", map[string]string{"filename": "😂.txt"}},
+		{"filename !!!", nil},
+	}
+
+	for _, tc := range tcs {
+		actual, err := tus.ParseMetadata(tc.header)
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("\nTest Data: (%s)\nExpected: error\nActual: %v", tc.header, actual)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("\nTest Data: (%s)\nExpected: %v\nActual: %v %v", tc.header, tc.expected, actual, err)
+		}
+		if roundtrip, _ := tus.ParseMetadata(tus.EncodeMetadata(actual)); !reflect.DeepEqual(roundtrip, actual) {
+			t.Errorf("\nTest Data: (%s)\nExpected: %v\nActual: %v", tc.header, actual, roundtrip)
+		}
+	}
+}
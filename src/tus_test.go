@@ -0,0 +1,177 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"localfs/tus"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupResumable initializes the resumable uploads of the storage.
+func setupResumable(t *testing.T, root string) {
+	store, err := tus.NewStore(filepath.Join(root, tusUploadsDir))
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	tusStore = store
+	t.Cleanup(func() { tusStore = nil })
+}
+
+func TestResumableUpload(t *testing.T) {
+	root := setupStorage(t)
+	setupResumable(t, root)
+	os.Mkdir(filepath.Join(root, "photos"), 0700)
+
+	mux := http.NewServeMux()
+	tusRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	request := func(method, target string, headers map[string]string, body string) *http.Response {
+		r, _ := http.NewRequest(method, srv.URL+target, strings.NewReader(body))
+		r.Header.Set("Tus-Resumable", tus.Version)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		res.Body.Close()
+		return res
+	}
+	patch := func(location, offset, body string) *http.Response {
+		return request(http.MethodPatch, location, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": offset,
+		}, body)
+	}
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("test_file")) +
+		",dir " + base64.StdEncoding.EncodeToString([]byte("photos"))
+	res := request(http.MethodPost, tusPrefix, map[string]string{
+		"Upload-Length":   "16",
+		"Upload-Metadata": metadata,
+	}, "")
+	location := res.Header.Get("Location")
+	if res.StatusCode != http.StatusCreated || !strings.HasPrefix(location, tusPrefix) {
+		t.Errorf("\nExpected: %d %s...\nActual: %d %s", http.StatusCreated, tusPrefix, res.StatusCode, location)
+		t.FailNow()
+	}
+
+	t.Run("Resume From Server Offset", func(t *testing.T) {
+		res := patch(location, "0", "Fuiyoh!!")
+		if res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Offset") != "8" {
+			t.Errorf("\nExpected: %d 8\nActual: %d %s", http.StatusNoContent, res.StatusCode, res.Header.Get("Upload-Offset"))
+		}
+
+		res = request(http.MethodHead, location, nil, "")
+		if res.Header.Get("Upload-Offset") != "8" || res.Header.Get("Upload-Length") != "16" {
+			t.Errorf("\nExpected: 8/16\nActual: %s/%s", res.Header.Get("Upload-Offset"), res.Header.Get("Upload-Length"))
+		}
+
+		if res := patch(location, "0", "Fuiyoh!!"); res.StatusCode != http.StatusConflict {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusConflict, res.StatusCode)
+		}
+	})
+
+	t.Run("Complete Upload Is Stored", func(t *testing.T) {
+		res := patch(location, "8", "Haiyaa!!")
+		status := res.Header.Get("X-Upload-Status")
+		if res.StatusCode != http.StatusNoContent || !strings.HasPrefix(status, "/upload/status?uid=") {
+			t.Errorf("\nExpected: %d /upload/status?uid=...\nActual: %d %s", http.StatusNoContent, res.StatusCode, status)
+		}
+
+		byt, err := os.ReadFile(filepath.Join(root, "photos", "test_file"))
+		if err != nil || string(byt) != "Fuiyoh!!Haiyaa!!" {
+			t.Errorf("\nExpected: Fuiyoh!!Haiyaa!!\nActual: %s %v", byt, err)
+		}
+
+		// a client losing the last response learns the status
+		res = request(http.MethodHead, location, nil, "")
+		if res.Header.Get("X-Upload-Status") != status {
+			t.Errorf("\nExpected: %s\nActual: %s", status, res.Header.Get("X-Upload-Status"))
+		}
+	})
+
+	t.Run("Relative Path Of Folder Uploads", func(t *testing.T) {
+		metadata := "filename YS5qcGc=,relativePath " + base64.StdEncoding.EncodeToString([]byte("album/2024/a.jpg"))
+		res := request(http.MethodPost, tusPrefix, map[string]string{
+			"Upload-Length":   "8",
+			"Upload-Metadata": metadata,
+		}, "")
+		patch(res.Header.Get("Location"), "0", "Fuiyoh!!")
+
+		byt, err := os.ReadFile(filepath.Join(root, "album", "2024", "a.jpg"))
+		if err != nil || string(byt) != "Fuiyoh!!" {
+			t.Errorf("\nExpected: Fuiyoh!!\nActual: %s %v", byt, err)
+		}
+	})
+
+	t.Run("Termination", func(t *testing.T) {
+		if res := request(http.MethodDelete, location, nil, ""); res.StatusCode != http.StatusNoContent {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNoContent, res.StatusCode)
+		}
+		if res := request(http.MethodHead, location, nil, ""); res.StatusCode != http.StatusNotFound {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusNotFound, res.StatusCode)
+		}
+	})
+
+	// initialize testcases
+	tcs := []struct {
+		title    string
+		headers  map[string]string
+		expected int
+	}{
+		{"Missing Length", map[string]string{}, http.StatusBadRequest},
+		{"Invalid File Name", map[string]string{
+			"Upload-Length":   "8",
+			"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("..")),
+		}, http.StatusBadRequest},
+		{"Path Traversal", map[string]string{
+			"Upload-Length": "8",
+			"Upload-Metadata": "filename dGVzdF9maWxl,dir " +
+				base64.StdEncoding.EncodeToString([]byte("../..")),
+		}, http.StatusBadRequest},
+		{"Relative Path Traversal", map[string]string{
+			"Upload-Length": "8",
+			"Upload-Metadata": "filename dGVzdF9maWxl,relativePath " +
+				base64.StdEncoding.EncodeToString([]byte("../escape")),
+		}, http.StatusBadRequest},
+		{"Missing Folder", map[string]string{
+			"Upload-Length": "8",
+			"Upload-Metadata": "filename dGVzdF9maWxl,dir " +
+				base64.StdEncoding.EncodeToString([]byte("missing")),
+		}, http.StatusNotFound},
+	}
+
+	t.Run("Invalid Creation Is Rejected", func(t *testing.T) {
+		for _, tc := range tcs {
+			if res := request(http.MethodPost, tusPrefix, tc.headers, ""); res.StatusCode != tc.expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %d\nActual: %d", tc.title, tc.expected, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Version Is Required", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodHead, srv.URL+location, nil)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if res.StatusCode != http.StatusPreconditionFailed || res.Header.Get("Tus-Version") != tus.Version {
+			t.Errorf("\nExpected: %d\nActual: %d", http.StatusPreconditionFailed, res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package certutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// validity of the self-signed certificate
+const Validity time.Duration = 365 * 24 * time.Hour
+
+// hosts every self-signed certificate is valid for
+var localHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// Load loads the certificate and key pair, e.g. supplied by the user.
+func Load(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+// LoadOrCreate loads the self-signed certificate and key pair, the
+// certificate is issued again when it is missing, expires within a day
+// or is not valid for every host. The persisted key is kept, so the
+// public key fingerprint stays the same when the addresses change.
+// It reports whether the certificate was issued.
+func LoadOrCreate(certFile, keyFile string, hosts []string) (tls.Certificate, bool, error) {
+	hosts = append(slices.Clone(localHosts), hosts...)
+
+	cert, err := Load(certFile, keyFile)
+	if err == nil && validFor(cert.Leaf, hosts) {
+		return cert, false, nil
+	}
+
+	key, err := loadKey(keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		key, err = createKey(keyFile)
+	}
+	if err != nil {
+		return cert, false, err
+	}
+	certPEM, err := SelfSigned(key, hosts)
+	if err != nil {
+		return cert, false, err
+	}
+	if err = os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return cert, false, err
+	}
+	if err = os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return cert, false, err
+	}
+
+	cert, err = Load(certFile, keyFile)
+	return cert, err == nil, err
+}
+
+// loadKey loads the persisted ECDSA private key.
+func loadKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM encoded key", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ECDSA key", keyFile)
+	}
+	return key, nil
+}
+
+// createKey creates and persists an ECDSA P-256 private key.
+func createKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, err
+	}
+	// the private key is readable by the owner only
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return key, os.WriteFile(keyFile, keyPEM, 0600)
+}
+
+// SelfSigned returns a PEM encoded self-signed certificate of the key,
+// with the hosts as subject alternative names.
+func SelfSigned(key *ecdsa.PrivateKey, hosts []string) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"localFS"}, CommonName: "localFS"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			if !slices.ContainsFunc(template.IPAddresses, ip.Equal) {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if !slices.Contains(template.DNSNames, host) {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the public key of the
+// certificate (SPKI), as colon separated uppercase hex, e.g. "AB:CD:...".
+// It stays the same when the certificate is issued again with the key.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// validFor reports whether the certificate is valid for every host
+// for at least another day.
+func validFor(cert *x509.Certificate, hosts []string) bool {
+	if time.Now().Add(24 * time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
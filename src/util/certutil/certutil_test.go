@@ -0,0 +1,89 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package certutil_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"localfs/util/certutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestLoadOrCreate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls", "cert.pem")
+	keyFile := filepath.Join(dir, "tls", "key.pem")
+
+	cert, created, err := certutil.LoadOrCreate(certFile, keyFile, []string{"192.168.1.7"})
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	t.Run("Create Self-Signed Certificate", func(t *testing.T) {
+		if !created {
+			t.Errorf("\nExpected the certificate to be created.")
+		}
+		if _, ok := cert.Leaf.PublicKey.(*ecdsa.PublicKey); !ok {
+			t.Errorf("\nExpected: ECDSA key\nActual: %T", cert.Leaf.PublicKey)
+		}
+		for _, host := range []string{"192.168.1.7", "localhost", "127.0.0.1", "::1"} {
+			if err := cert.Leaf.VerifyHostname(host); err != nil {
+				t.Errorf("\nTest Data: (%s)\nError: %s", host, err)
+			}
+		}
+
+		info, err := os.Stat(keyFile)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("\nExpected: %v\nActual: %v", os.FileMode(0600), info.Mode().Perm())
+		}
+	})
+
+	t.Run("Load Persisted Certificate", func(t *testing.T) {
+		loaded, created, err := certutil.LoadOrCreate(certFile, keyFile, []string{"192.168.1.7"})
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if created || !bytes.Equal(loaded.Leaf.Raw, cert.Leaf.Raw) {
+			t.Errorf("\nExpected the persisted certificate to be loaded.")
+		}
+	})
+
+	t.Run("Reissue Certificate For New Address With The Key", func(t *testing.T) {
+		renewed, created, err := certutil.LoadOrCreate(certFile, keyFile, []string{"10.0.0.2"})
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if !created || renewed.Leaf.VerifyHostname("10.0.0.2") != nil {
+			t.Errorf("\nExpected the certificate to be issued for the new address.")
+		}
+		if expected, actual := certutil.Fingerprint(cert.Leaf), certutil.Fingerprint(renewed.Leaf); actual != expected {
+			t.Errorf("\nExpected: %s\nActual: %s", expected, actual)
+		}
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	cert, _, err := certutil.LoadOrCreate(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), nil)
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	actual := certutil.Fingerprint(cert.Leaf)
+	if !regexp.MustCompile(`^([0-9A-F]{2}:){31}[0-9A-F]{2}$`).MatchString(actual) {
+		t.Errorf("\nExpected: 32 colon separated hex bytes\nActual: %s", actual)
+	}
+}
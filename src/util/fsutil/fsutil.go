@@ -5,15 +5,38 @@
 package fsutil
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
+// TempFilePrefix prefixes the hidden temporary files of in-progress
+// writes, such files are excluded from the listing.
+const TempFilePrefix = ".localfs-"
+
+var (
+	ErrInvalidFileName = errors.New("invalid file name")
+	ErrInvalidPath     = errors.New("invalid path")
+)
+
+// Entry describes a listed file or directory.
+type Entry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
 func Mkdir(path string) error {
 	perm := os.FileMode(0700)
 	err := os.MkdirAll(path, perm)
@@ -39,6 +62,10 @@ func FilesListing(path string) ([]string, error) {
 
 	list := []string{}
 	for _, entry := range entries {
+		// skip in-progress writes
+		if strings.HasPrefix(entry.Name(), TempFilePrefix) {
+			continue
+		}
 		if !entry.IsDir() {
 			list = append(list, entry.Name())
 		}
@@ -46,20 +73,460 @@ func FilesListing(path string) ([]string, error) {
 	return list, nil
 }
 
+// Listing returns the directories sorted by name, followed by the files
+// sorted descending by modified time.
+func Listing(path string) ([]Entry, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, files := []Entry{}, []Entry{}
+	for _, entry := range entries {
+		// skip in-progress writes
+		if strings.HasPrefix(entry.Name(), TempFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// removed after reading the directory
+			continue
+		}
+
+		e := Entry{
+			Name:    entry.Name(),
+			IsDir:   entry.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if e.IsDir {
+			dirs = append(dirs, e)
+		} else {
+			files = append(files, e)
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.ToLower(dirs[i].Name) < strings.ToLower(dirs[j].Name)
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+	return append(dirs, files...), nil
+}
+
+// CreateDir creates the named directory inside the path, it returns
+// an error satisfying errors.Is(err, fs.ErrExist) when the name is taken.
+func CreateDir(path, name string) error {
+	if !ValidFileName(name) {
+		return ErrInvalidFileName
+	}
+	return os.Mkdir(filepath.Join(path, name), os.FileMode(0700))
+}
+
+// CreateDirAll creates the slash-separated relative path of directories
+// inside the root, along with any missing parents, existing directories
+// are reused. As SecureJoin, paths resolving outside the root are rejected
+// with ErrInvalidPath. It returns the absolute path.
+func CreateDirAll(root, rel string) (string, error) {
+	path := root
+	walked := ""
+	for _, segment := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if segment == "" && walked == "" {
+			// the root itself
+			continue
+		}
+		walked += "/" + segment
+
+		var err error
+		path, err = SecureJoin(root, walked)
+		if err != nil {
+			return "", err
+		}
+		err = os.Mkdir(path, os.FileMode(0700))
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s: %w", filepath.Base(path), fs.ErrExist)
+	}
+	return path, nil
+}
+
+// SecureJoin joins the slash-separated relative path to the root and
+// returns the absolute path. Paths with "..", empty or hidden temporary
+// segments, and paths resolving outside the root through symbolic links
+// are rejected with ErrInvalidPath. The path itself may not exist, but
+// its parent directory must.
+func SecureJoin(root, rel string) (string, error) {
+	rel = strings.Trim(rel, "/")
+	if rel == "" {
+		return root, nil
+	}
+	for _, segment := range strings.Split(rel, "/") {
+		if !ValidFileName(segment) {
+			return "", ErrInvalidPath
+		}
+	}
+	path := filepath.Join(root, filepath.FromSlash(rel))
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// check the parent of a new file or directory
+		real, err = filepath.EvalSymlinks(filepath.Dir(path))
+	}
+	if err != nil {
+		return "", err
+	}
+	if !IsWithin(realRoot, real) {
+		return "", ErrInvalidPath
+	}
+	return path, nil
+}
+
+// IsWithin reports whether the path is the root or inside the root.
+func IsWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// WriteStreamToFile writes the stream to the file, replacing any existing
+// file only after the whole stream has been written.
 func WriteStreamToFile(path, filename string, stream io.Reader) error {
-	file, err := os.Create(filepath.Join(path, filename))
+	_, _, err := WriteStreamToFileSha256(path, filename, stream)
+	return err
+}
+
+// WriteStreamToFileSha256 writes the stream to the file and computes the
+// SHA-256 checksum in the same pass, so the stream is read only once.
+// It returns the number of bytes written and the hex encoded checksum.
+func WriteStreamToFileSha256(path, filename string, stream io.Reader) (int64, string, error) {
+	if !ValidFileName(filename) {
+		return 0, "", ErrInvalidFileName
+	}
+	return writeAtomic(path, filename, stream)
+}
+
+// SaveStream writes the stream to a new file without replacing any
+// existing file, the name is reserved atomically and resolved to the next
+// available "name(n).ext" on conflict. Partial data is removed when the
+// stream fails, e.g. when the client disconnects. It returns the stored
+// file name, the number of bytes written and the SHA-256 checksum.
+func SaveStream(path, filename string, stream io.Reader) (string, int64, string, error) {
+	fname, err := ReserveFile(path, filename)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return saveReserved(path, fname, stream)
+}
+
+// SaveStreamExclusive is SaveStream without conflict resolution, it fails
+// with an error satisfying errors.Is(err, fs.ErrExist) when the name is
+// taken.
+func SaveStreamExclusive(path, filename string, stream io.Reader) (int64, string, error) {
+	if !ValidFileName(filename) {
+		return 0, "", ErrInvalidFileName
+	}
+
+	f, err := os.OpenFile(filepath.Join(path, filename), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
 	if err != nil {
+		return 0, "", err
+	}
+	f.Close()
+
+	_, size, hash, err := saveReserved(path, filename, stream)
+	return size, hash, err
+}
+
+// saveReserved writes the stream to the reserved file name.
+func saveReserved(path, fname string, stream io.Reader) (string, int64, string, error) {
+	size, hash, err := writeAtomic(path, fname, stream)
+	if err != nil {
+		// release the reserved name
+		os.Remove(filepath.Join(path, fname))
+		return "", size, "", err
+	}
+	return fname, size, hash, nil
+}
+
+// ReserveFile atomically creates an empty file with the file name, or with
+// the next available "name(n).ext" when the name is taken, and returns the
+// reserved name. Unlike ResolveFileConflict, concurrent callers never
+// receive the same name.
+func ReserveFile(path, file string) (string, error) {
+	return reserve(path, file, func(name string) error {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// reserveDir is the ReserveFile of directories.
+func reserveDir(path, dir string) (string, error) {
+	return reserve(path, dir, func(name string) error {
+		return os.Mkdir(name, os.FileMode(0700))
+	})
+}
+
+// reserve calls create with the absolute path of the file name, or of the
+// next "name(n).ext" for as long as create fails with fs.ErrExist.
+func reserve(path, file string, create func(string) error) (string, error) {
+	if !ValidFileName(file) {
+		return "", ErrInvalidFileName
+	}
+
+	ext := filepath.Ext(file)
+	name := strings.TrimSuffix(file, ext)
+	fname := file
+	for next := 1; ; next++ {
+		err := create(filepath.Join(path, fname))
+		if err == nil {
+			return fname, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		fname = fmt.Sprintf("%s(%d)%s", name, next, ext)
+	}
+}
+
+// Remove removes the file, or the directory and everything it contains.
+func Remove(path string) error {
+	// os.RemoveAll does not report missing paths
+	if _, err := os.Lstat(path); err != nil {
 		return err
 	}
-	defer file.Close()
+	return os.RemoveAll(path)
+}
 
-	_, err = io.Copy(file, stream)
+// Rename renames the file or directory inside the path without replacing
+// any existing file, the new name is resolved to the next available
+// "name(n).ext" on conflict. It returns the resolved name.
+func Rename(path, oldname, newname string) (string, error) {
+	if !ValidFileName(oldname) || !ValidFileName(newname) {
+		return "", ErrInvalidFileName
+	}
+	if oldname == newname {
+		return oldname, nil
+	}
+	return moveTo(filepath.Join(path, oldname), path, newname)
+}
+
+// Move moves the file or directory into the directory without replacing
+// any existing file, the name is resolved to the next available
+// "name(n).ext" on conflict. It returns the resolved name. Moving a
+// directory into itself is rejected with ErrInvalidPath.
+func Move(src, dir string) (string, error) {
+	name := filepath.Base(src)
+	if filepath.Dir(src) == filepath.Clean(dir) {
+		return name, nil
+	}
+	if IsWithin(src, dir) {
+		return "", ErrInvalidPath
+	}
+	return moveTo(src, dir, name)
+}
+
+// MoveAs moves the file or directory into the directory as the name,
+// resolved to the next available "name(n).ext" on conflict. It returns
+// the resolved name.
+func MoveAs(src, dir, name string) (string, error) {
+	if !ValidFileName(name) {
+		return "", ErrInvalidFileName
+	}
+	return moveTo(src, dir, name)
+}
+
+func moveTo(src, dir, name string) (string, error) {
+	info, err := os.Lstat(src)
 	if err != nil {
+		return "", err
+	}
+
+	// reserve the name, then replace the reservation by the source
+	var fname string
+	if info.IsDir() {
+		fname, err = reserveDir(dir, name)
+	} else {
+		fname, err = ReserveFile(dir, name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(dir, fname)
+	err = os.Rename(src, dst)
+	if err != nil && info.IsDir() {
+		// directories can not replace an empty directory on all platforms
+		os.Remove(dst)
+		err = os.Rename(src, dst)
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return fname, nil
+}
+
+// writeAtomic writes the stream to a hidden temporary file in the same
+// directory, syncs it to disk and renames it to the file name.
+func writeAtomic(path, filename string, stream io.Reader) (int64, string, error) {
+	f, err := CreateAtomic(path, filename)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), stream)
+	if err != nil {
+		f.Abort()
+		return size, "", err
+	}
+	if err = f.Commit(); err != nil {
+		return size, "", err
+	}
+
+	return size, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// AtomicFile is written through a hidden temporary file, which replaces
+// the file of the name on Commit, or is removed on Abort.
+type AtomicFile struct {
+	*os.File
+	path     string
+	filename string
+}
+
+// CreateAtomic creates the hidden temporary file of the file name
+// inside the path.
+func CreateAtomic(path, filename string) (*AtomicFile, error) {
+	if !ValidFileName(filename) {
+		return nil, ErrInvalidFileName
+	}
+	temp, err := createTempFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: temp, path: path, filename: filename}, nil
+}
+
+// Commit syncs the temporary file to disk and renames it to the file name.
+func (f *AtomicFile) Commit() error {
+	// remove the temporary file on failure, no-op after rename
+	defer os.Remove(f.Name())
+
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(f.path, f.filename)); err != nil {
+		return err
+	}
+	syncDir(f.path)
+	return nil
+}
+
+// CommitNoReplace is Commit without replacing a file of the name, e.g.
+// created since the upload started. It fails with an error satisfying
+// errors.Is(err, fs.ErrExist) when the name is taken.
+func (f *AtomicFile) CommitNoReplace() error {
+	// remove the temporary file, the file of the name is a link of it
+	defer os.Remove(f.Name())
+
+	if err := f.Sync(); err != nil {
+		f.File.Close()
 		return err
 	}
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	target := filepath.Join(f.path, f.filename)
+	err := os.Link(f.Name(), target)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		// file systems without hard links, e.g. FAT, reserve the name
+		var reserved *os.File
+		if reserved, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666); err == nil {
+			reserved.Close()
+			err = os.Rename(f.Name(), target)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	syncDir(f.path)
 	return nil
 }
 
+// Abort removes the temporary file, the file of the name is unchanged.
+func (f *AtomicFile) Abort() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+func createTempFile(path string) (*os.File, error) {
+	for {
+		rnd := make([]byte, 8)
+		if _, err := rand.Read(rnd); err != nil {
+			return nil, err
+		}
+		// unlike os.CreateTemp, keep the default permission of os.Create
+		name := filepath.Join(path, fmt.Sprintf("%s%x.part", TempFilePrefix, rnd))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+}
+
+// CheckWritable reports an error when files can not be created in the path.
+func CheckWritable(path string) error {
+	f, err := createTempFile(path)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// syncDir persists the directory entries after rename, it is best effort
+// as directories can not be synced on all platforms.
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// ValidFileName reports whether the file name is a single path segment
+// without control characters, e.g. a newline breaking the lines of a
+// listing, and not a hidden temporary file (TempFilePrefix).
+func ValidFileName(file string) bool {
+	return file != "" && file != "." && file != ".." &&
+		!strings.ContainsAny(file, `/\`) && !strings.HasPrefix(file, TempFilePrefix) &&
+		strings.IndexFunc(file, unicode.IsControl) < 0
+}
+
 func Sha256sum(stream io.Reader) (string, error) {
 	hash := sha256.New()
 	_, err := io.Copy(hash, stream)
@@ -69,6 +536,9 @@ func Sha256sum(stream io.Reader) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
+// ResolveFileConflict returns the file name, or the next available
+// "name(n).ext" when the file exists. The name is not reserved, use
+// ReserveFile or SaveStream when writing concurrently.
 func ResolveFileConflict(path, file string) string {
 	exists := func(file string) bool {
 		_, err := os.Stat(file)
@@ -93,3 +563,96 @@ func ResolveFileConflict(path, file string) string {
 		next++
 	}
 }
+
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// minimum entries of the hash cache before it is pruned
+const hashCachePrune = 1024
+
+// HashCache caches the SHA-256 checksums of files, an entry is valid for
+// as long as the size and modified time of the file are unchanged. The
+// entries of files deleted, renamed or changed since are dropped once the
+// cache doubles, so it stays within twice the cached files.
+type HashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashEntry
+	// size of the cache after it was last pruned
+	pruned int
+}
+
+func NewHashCache() *HashCache {
+	return &HashCache{entries: map[string]hashEntry{}}
+}
+
+// Sha256sum returns the cached checksum of the file, or computes it.
+func (c *HashCache) Sha256sum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if hash, ok := c.Get(path, info); ok {
+		return hash, nil
+	}
+
+	hash, err := Sha256sum(file)
+	if err != nil {
+		return "", err
+	}
+	c.Put(path, info, hash)
+	return hash, nil
+}
+
+// Get returns the cached checksum of the file, unless it changed since.
+func (c *HashCache) Get(path string, info fs.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if !ok {
+		return "", false
+	}
+	if e.size != info.Size() || !e.modTime.Equal(info.ModTime()) {
+		delete(c.entries, path)
+		return "", false
+	}
+	return e.hash, true
+}
+
+// Put caches the checksum of the file, e.g. computed while writing it.
+func (c *HashCache) Put(path string, info fs.FileInfo, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = hashEntry{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	if len(c.entries) < max(2*c.pruned, hashCachePrune) {
+		return
+	}
+	for p, e := range c.entries {
+		info, err := os.Stat(p)
+		if err != nil || e.size != info.Size() || !e.modTime.Equal(info.ModTime()) {
+			delete(c.entries, p)
+		}
+	}
+	c.pruned = len(c.entries)
+}
+
+// Remove drops the cached checksums of the path and of the files below it,
+// e.g. once it is deleted.
+func (c *HashCache) Remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.entries {
+		if IsWithin(path, p) {
+			delete(c.entries, p)
+		}
+	}
+}
@@ -6,11 +6,16 @@ package fsutil_test
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/fs"
 	"localfs/util/fsutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -166,6 +171,48 @@ func TestWriteStreamToFile(t *testing.T) {
 	})
 }
 
+func TestWriteStreamToFileSha256(t *testing.T) {
+	// t.TempDir returns a temporary directory for the test to use.
+	// The directory is automatically removed when the test and
+	// all its subtests complete.
+	tempDir := t.TempDir()
+	// initialize testcases
+	tcs := []struct {
+		data     []byte
+		expected []string
+	}{
+		{
+			data: []byte("1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ"),
+			expected: []string{"1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+				"2a3dfe6fbe56133c3254e7c3db3f70e3f706e8e9030ef82d416d77a18c904633"},
+		},
+	}
+
+	t.Run("Write And Hash In One Pass", func(t *testing.T) {
+		// setup test data
+		tdata := tcs[0].data
+		expected := tcs[0].expected
+
+		size, hash, err := fsutil.WriteStreamToFileSha256(tempDir, "tempfile", bytes.NewReader(tdata))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+
+		inbyte, err := os.ReadFile(filepath.Join(tempDir, "tempfile"))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+
+		actual := []string{string(inbyte), hash}
+		if !reflect.DeepEqual(expected, actual) || size != int64(len(tdata)) {
+			t.Errorf("\nTest Data: (%v)\nExpected: %v (size %d)\nActual: %v (size %d)",
+				tdata, expected, len(tdata), actual, size)
+		}
+	})
+}
+
 func TestSha256sum(t *testing.T) {
 	// t.TempDir returns a temporary directory for the test to use.
 	// The directory is automatically removed when the test and
@@ -318,3 +365,421 @@ func TestResolveFileConflict(t *testing.T) {
 		}
 	})
 }
+
+func TestReserveFile(t *testing.T) {
+	// t.TempDir returns a temporary directory for the test to use.
+	// The directory is automatically removed when the test and
+	// all its subtests complete.
+	tempDir := t.TempDir()
+	// initialize testcases
+	tcs := []struct {
+		data     []string
+		expected int
+	}{
+		{
+			data:     []string{"IMG_0001.jpg", "..", "a/b.txt", ".localfs-x.part"},
+			expected: 20,
+		},
+	}
+
+	t.Run("Concurrent Reservations Never Share A Name", func(t *testing.T) {
+		tdata := tcs[0].data
+		expected := tcs[0].expected
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		names := map[string]bool{}
+		for i := 0; i < expected; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name, err := fsutil.ReserveFile(tempDir, tdata[0])
+				if err != nil {
+					t.Errorf("\nError: %s", err)
+					return
+				}
+				mu.Lock()
+				names[name] = true
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		if len(names) != expected || !names[tdata[0]] || !names["IMG_0001(19).jpg"] {
+			t.Errorf("\nTest Data: (%s)\nExpected: %d unique names\nActual: %v",
+				tdata[0], expected, names)
+		}
+	})
+
+	t.Run("Invalid File Names", func(t *testing.T) {
+		for _, data := range tcs[0].data[1:] {
+			_, err := fsutil.ReserveFile(tempDir, data)
+			if !errors.Is(err, fsutil.ErrInvalidFileName) {
+				t.Errorf("\nTest Data: (%s)\nExpected: %v\nActual: %v",
+					data, fsutil.ErrInvalidFileName, err)
+			}
+		}
+	})
+}
+
+// failingReader returns an error after the data has been read,
+// as a client disconnecting in the middle of an upload does.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestSaveStream(t *testing.T) {
+	// initialize testcases
+	tcs := []struct {
+		data     []byte
+		expected []string
+	}{
+		{
+			data:     []byte("Fuiyoh!!"),
+			expected: []string{"tempfile", "tempfile(1)"},
+		},
+	}
+
+	t.Run("Existing File Is Not Replaced", func(t *testing.T) {
+		tempDir := t.TempDir()
+		tdata := tcs[0].data
+		expected := tcs[0].expected
+
+		actual := []string{}
+		for range expected {
+			name, size, _, err := fsutil.SaveStream(tempDir, "tempfile", bytes.NewReader(tdata))
+			if err != nil {
+				t.Errorf("\nError: %s", err)
+				t.FailNow()
+			}
+			if size != int64(len(tdata)) {
+				t.Errorf("\nExpected: %d\nActual: %d", len(tdata), size)
+			}
+			actual = append(actual, name)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("\nTest Data: (%v)\nExpected: %v\nActual: %v",
+				tdata, expected, actual)
+		}
+	})
+
+	t.Run("Failed Stream Leaves No Partial File", func(t *testing.T) {
+		tempDir := t.TempDir()
+		tdata := tcs[0].data
+
+		_, _, _, err := fsutil.SaveStream(tempDir, "tempfile", &failingReader{data: tdata})
+		if err == nil {
+			t.Errorf("\nExpected stream error, but no error was thrown.")
+		}
+
+		entries, err := os.ReadDir(tempDir)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if len(entries) != 0 {
+			t.Errorf("\nExpected empty directory, but found %d entries.", len(entries))
+		}
+	})
+}
+
+func TestListing(t *testing.T) {
+	tempDir := t.TempDir()
+	// initialize testcases
+	tcs := []struct {
+		data     []string
+		expected []string
+	}{
+		{
+			data:     []string{"b_dir/", "A_dir/", "test_file_1", "test_file_2", ".localfs-x.part"},
+			expected: []string{"A_dir", "b_dir", "test_file_2", "test_file_1"},
+		},
+	}
+
+	t.Run("Folders First Then Latest Files", func(t *testing.T) {
+		tdata := tcs[0].data
+		expected := tcs[0].expected
+		for _, data := range tdata {
+			var err error
+			if strings.HasSuffix(data, "/") {
+				err = os.Mkdir(filepath.Join(tempDir, data), 0700)
+			} else {
+				err = os.WriteFile(filepath.Join(tempDir, data), nil, 0644)
+			}
+			if err != nil {
+				t.Errorf("\nError: %s", err)
+				t.FailNow()
+			}
+			// delay creation
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		entries, err := fsutil.Listing(tempDir)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+
+		actual := []string{}
+		for _, entry := range entries {
+			actual = append(actual, entry.Name)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("\nTest Data: (%v)\nExpected: %v\nActual: %v", tdata, expected, actual)
+		}
+	})
+}
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "photos"), 0700); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	// initialize testcases
+	tcs := []struct {
+		data     string
+		expected string
+	}{
+		{data: "", expected: root},
+		{data: "/photos/", expected: filepath.Join(root, "photos")},
+		{data: "photos/new_file", expected: filepath.Join(root, "photos", "new_file")},
+	}
+
+	t.Run("Paths Inside The Root", func(t *testing.T) {
+		for _, tc := range tcs {
+			actual, err := fsutil.SecureJoin(root, tc.data)
+			if err != nil || actual != tc.expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %s\nActual: %s (%v)",
+					tc.data, tc.expected, actual, err)
+			}
+		}
+	})
+
+	t.Run("Paths Escaping The Root", func(t *testing.T) {
+		for _, data := range []string{"..", "photos/../..", "photos//x", "escape",
+			"escape/new_file", ".localfs-x.part", `..\..`} {
+			_, err := fsutil.SecureJoin(root, data)
+			if !errors.Is(err, fsutil.ErrInvalidPath) {
+				t.Errorf("\nTest Data: (%s)\nExpected: %v\nActual: %v",
+					data, fsutil.ErrInvalidPath, err)
+			}
+		}
+	})
+}
+
+func TestCreateDirAll(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	if err := os.WriteFile(filepath.Join(root, "test_file"), []byte("Fuiyoh!!"), 0644); err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	t.Run("Create Nested Directories", func(t *testing.T) {
+		for _, data := range []string{"photos/2024/", "photos/2024", "photos/2025", ""} {
+			actual, err := fsutil.CreateDirAll(root, data)
+			expected := filepath.Join(root, filepath.FromSlash(strings.Trim(data, "/")))
+			if err != nil || actual != expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %s\nActual: %s (%v)", data, expected, actual, err)
+			}
+		}
+	})
+
+	t.Run("Paths Escaping The Root", func(t *testing.T) {
+		for _, data := range []string{"..", "photos/../../x", "escape/x", "a//b", ".localfs-x"} {
+			_, err := fsutil.CreateDirAll(root, data)
+			if !errors.Is(err, fsutil.ErrInvalidPath) {
+				t.Errorf("\nTest Data: (%s)\nExpected: %v\nActual: %v", data, fsutil.ErrInvalidPath, err)
+			}
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Errorf("\nExpected no directory outside of the root, but found %d.", len(entries))
+		}
+	})
+
+	t.Run("File In The Path", func(t *testing.T) {
+		for _, data := range []string{"test_file", "test_file/x"} {
+			if _, err := fsutil.CreateDirAll(root, data); err == nil {
+				t.Errorf("\nTest Data: (%s)\nExpected: error\nActual: nil", data)
+			}
+		}
+	})
+}
+
+func TestRenameMoveRemove(t *testing.T) {
+	// setup test data, a folder with a file and a conflicting
+	// file of the same name in the storage root
+	setup := func(t *testing.T) string {
+		tempDir := t.TempDir()
+		for _, data := range []string{"photos/", "photos/test_file.pdf", "test_file.pdf"} {
+			var err error
+			if strings.HasSuffix(data, "/") {
+				err = os.Mkdir(filepath.Join(tempDir, data), 0700)
+			} else {
+				err = os.WriteFile(filepath.Join(tempDir, data), []byte(data), 0644)
+			}
+			if err != nil {
+				t.Errorf("\nError: %s", err)
+				t.FailNow()
+			}
+		}
+		return tempDir
+	}
+
+	t.Run("Rename Resolves Conflict", func(t *testing.T) {
+		tempDir := setup(t)
+		expected := "test_file(1).pdf"
+
+		actual, err := fsutil.Rename(filepath.Join(tempDir, "photos"), "test_file.pdf", "test_file.pdf")
+		if err != nil || actual != "test_file.pdf" {
+			t.Errorf("\nExpected: %s\nActual: %s (%v)", "test_file.pdf", actual, err)
+		}
+
+		os.WriteFile(filepath.Join(tempDir, "other.pdf"), nil, 0644)
+		actual, err = fsutil.Rename(tempDir, "other.pdf", "test_file.pdf")
+		if err != nil || actual != expected {
+			t.Errorf("\nExpected: %s\nActual: %s (%v)", expected, actual, err)
+		}
+	})
+
+	t.Run("Move File And Folder Resolves Conflict", func(t *testing.T) {
+		tempDir := setup(t)
+		expected := []string{"test_file(1).pdf", "photos(1)"}
+
+		actual := []string{}
+		name, err := fsutil.Move(filepath.Join(tempDir, "photos", "test_file.pdf"), tempDir)
+		actual = append(actual, name)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+
+		os.Mkdir(filepath.Join(tempDir, "archive"), 0700)
+		os.Mkdir(filepath.Join(tempDir, "archive", "photos"), 0700)
+		name, err = fsutil.Move(filepath.Join(tempDir, "photos"), filepath.Join(tempDir, "archive"))
+		actual = append(actual, name)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("\nExpected: %v\nActual: %v", expected, actual)
+		}
+		inbyte, _ := os.ReadFile(filepath.Join(tempDir, "test_file.pdf"))
+		if string(inbyte) != "test_file.pdf" {
+			t.Errorf("\nExpected existing file not to be replaced, but found '%s'.", inbyte)
+		}
+	})
+
+	t.Run("Move Folder Into Itself", func(t *testing.T) {
+		tempDir := setup(t)
+		src := filepath.Join(tempDir, "photos")
+
+		_, err := fsutil.Move(src, src)
+		if !errors.Is(err, fsutil.ErrInvalidPath) {
+			t.Errorf("\nExpected: %v\nActual: %v", fsutil.ErrInvalidPath, err)
+		}
+	})
+
+	t.Run("Remove Folder And Missing File", func(t *testing.T) {
+		tempDir := setup(t)
+
+		err := fsutil.Remove(filepath.Join(tempDir, "photos"))
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "photos")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("\nExpected folder to be removed, but found it.")
+		}
+
+		err = fsutil.Remove(filepath.Join(tempDir, "photos"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("\nExpected: %v\nActual: %v", fs.ErrNotExist, err)
+		}
+	})
+}
+
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "photos"), 0755)
+	files := []string{filepath.Join(dir, "test_file"), filepath.Join(dir, "photos", "test_file")}
+	for _, file := range files {
+		os.WriteFile(file, []byte("1234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ"), 0644)
+	}
+	c := fsutil.NewHashCache()
+	expected := "2a3dfe6fbe56133c3254e7c3db3f70e3f706e8e9030ef82d416d77a18c904633"
+
+	t.Run("Checksum Is Cached", func(t *testing.T) {
+		for _, file := range files {
+			if actual, err := c.Sha256sum(file); err != nil || actual != expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %s\nActual: %s %v", file, expected, actual, err)
+			}
+			info, _ := os.Stat(file)
+			if actual, ok := c.Get(file, info); !ok || actual != expected {
+				t.Errorf("\nTest Data: (%s)\nExpected: %s\nActual: %s", file, expected, actual)
+			}
+		}
+	})
+
+	t.Run("Changed File Is Not Cached", func(t *testing.T) {
+		os.WriteFile(files[0], []byte("Fuiyoh!!"), 0644)
+		info, _ := os.Stat(files[0])
+		if actual, ok := c.Get(files[0], info); ok {
+			t.Errorf("\nExpected: not cached\nActual: %s", actual)
+		}
+	})
+
+	t.Run("Removed Folder Is Dropped", func(t *testing.T) {
+		info, _ := os.Stat(files[1])
+		c.Remove(filepath.Join(dir, "photos"))
+		if actual, ok := c.Get(files[1], info); ok {
+			t.Errorf("\nExpected: not cached\nActual: %s", actual)
+		}
+	})
+}
+
+func TestCommitNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	commit := func(name, content string) error {
+		f, err := fsutil.CreateAtomic(dir, name)
+		if err != nil {
+			return err
+		}
+		f.Write([]byte(content))
+		return f.CommitNoReplace()
+	}
+
+	if err := commit("test_file", "Fuiyoh!!"); err != nil {
+		t.Errorf("\nError: %s", err)
+	}
+	// the file created meanwhile is kept
+	if err := commit("test_file", "Haiyaa!!"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("\nExpected: %s\nActual: %v", fs.ErrExist, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "test_file")); string(b) != "Fuiyoh!!" {
+		t.Errorf("\nExpected: %s\nActual: %s", "Fuiyoh!!", b)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("\nExpected: [test_file]\nActual: %v", entries)
+	}
+}
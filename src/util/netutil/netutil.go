@@ -7,32 +7,86 @@ package netutil
 import (
 	"errors"
 	"net"
+	"path"
 	"runtime"
+	"slices"
 	"strings"
 )
 
-// implementation based-on chatgpt response
-func IPv4Address() ([]string, error) {
+// DefaultExclude are the name patterns of the loopback, docker, SIM data,
+// virtualbox and other virtual interfaces, see path.Match.
+var DefaultExclude = []string{"lo", "*docker*", "*rmnet*", "*dummy*", "*veth*", "*vboxnet*"}
+
+// DefaultFilter selects the interfaces not of the default exclude patterns.
+var DefaultFilter = Filter{Exclude: DefaultExclude}
+
+// Filter selects the network interfaces by name patterns, see path.Match.
+type Filter struct {
+	// Include selects the interfaces of the patterns only,
+	// the exclude patterns are then ignored
+	Include []string
+	// Exclude the interfaces of the patterns
+	Exclude []string
+}
+
+// Validate returns an error of a malformed pattern.
+func (f Filter) Validate() error {
+	for _, pattern := range slices.Concat(f.Include, f.Exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("invalid interface pattern '" + pattern + "'")
+		}
+	}
+	return nil
+}
+
+// Match reports whether the filter selects the interface name.
+func (f Filter) Match(name string) bool {
+	if len(f.Include) > 0 {
+		return matchAny(f.Include, name)
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Interfaces returns the network interfaces selected by the filter which
+// are up, loopback interfaces are only returned when included by name.
+func (f Filter) Interfaces() ([]net.Interface, error) {
 	// get network interfaces
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
 
-	ipAddrs := []string{}
-	// loop interfaces
+	filtered := []net.Interface{}
 	for _, iface := range ifaces {
-		// filter-out loopback, docker, SIM data, virtualbox and no-up interfaces
-		if iface.Name == "lo" ||
-			iface.Flags&net.FlagUp == 0 ||
-			strings.Contains(iface.Name, "docker") ||
-			strings.Contains(iface.Name, "rmnet") ||
-			strings.Contains(iface.Name, "dummy") ||
-			strings.Contains(iface.Name, "veth") ||
-			strings.Contains(iface.Name, "vboxnet") {
+		if iface.Flags&net.FlagUp == 0 || !f.Match(iface.Name) ||
+			(len(f.Include) == 0 && iface.Flags&net.FlagLoopback != 0) {
 			continue
 		}
+		filtered = append(filtered, iface)
+	}
+	return filtered, nil
+}
 
+// IPv4Address returns the IPv4 addresses of the interfaces selected by the filter.
+// implementation based-on chatgpt response
+func (f Filter) IPv4Address() ([]string, error) {
+	ifaces, err := f.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	ipAddrs := []string{}
+	// loop interfaces
+	for _, iface := range ifaces {
 		// get interface associated ip addrs
 		addrs, err := iface.Addrs()
 		if err != nil {
@@ -48,7 +102,7 @@ func IPv4Address() ([]string, error) {
 				// on Windows platform by checking IP 192.168.56.0/24
 				// This is not an ideal solution, but it serves as
 				// a workaround to address the problem.
-				if runtime.GOOS == "windows" &&
+				if runtime.GOOS == "windows" && len(f.Include) == 0 &&
 					strings.HasPrefix(ip.String(), "192.168.56") {
 					continue
 				}
@@ -63,3 +117,53 @@ func IPv4Address() ([]string, error) {
 
 	return ipAddrs, nil
 }
+
+// IPAddresses returns the IPv4 addresses, followed by the global and the
+// link-local IPv6 addresses, of the interfaces selected by the filter.
+// A link-local address carries the zone of its interface, e.g.
+// fe80::1%wlan0, as it is only unique on the link.
+func (f Filter) IPAddresses() ([]string, error) {
+	ipv6Addrs, linkLocalAddrs := []string{}, []string{}
+	ifaces, err := f.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ip := addr.(*net.IPNet).IP
+			switch {
+			case ip.To4() != nil:
+				// listed by IPv4Address
+			case ip.IsGlobalUnicast():
+				ipv6Addrs = append(ipv6Addrs, ip.String())
+			case ip.IsLinkLocalUnicast():
+				linkLocalAddrs = append(linkLocalAddrs, ip.String()+"%"+iface.Name)
+			}
+		}
+	}
+
+	ipAddrs, err := f.IPv4Address()
+	if err != nil && len(ipv6Addrs)+len(linkLocalAddrs) == 0 {
+		return nil, err
+	}
+	return slices.Concat(ipAddrs, ipv6Addrs, linkLocalAddrs), nil
+}
+
+// Interfaces returns the interfaces selected by the default filter.
+func Interfaces() ([]net.Interface, error) {
+	return DefaultFilter.Interfaces()
+}
+
+// IPv4Address returns the IPv4 addresses of the default filter.
+func IPv4Address() ([]string, error) {
+	return DefaultFilter.IPv4Address()
+}
+
+// IPAddresses returns the IPv4 and IPv6 addresses of the default filter.
+func IPAddresses() ([]string, error) {
+	return DefaultFilter.IPAddresses()
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package netutil_test
+
+import (
+	"localfs/util/netutil"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	// initialize testcases
+	tcs := []struct {
+		filter   netutil.Filter
+		name     string
+		expected bool
+	}{
+		{netutil.DefaultFilter, "eth0", true},
+		{netutil.DefaultFilter, "wlan0", true},
+		{netutil.DefaultFilter, "lo", false},
+		{netutil.DefaultFilter, "docker0", false},
+		{netutil.DefaultFilter, "br-docker1", false},
+		{netutil.DefaultFilter, "veth12ab", false},
+		{netutil.DefaultFilter, "vboxnet0", false},
+		{netutil.Filter{Exclude: append(netutil.DefaultExclude, "tun*")}, "tun0", false},
+		{netutil.Filter{Include: []string{"wlan0"}}, "wlan0", true},
+		{netutil.Filter{Include: []string{"wlan0"}}, "eth0", false},
+		{netutil.Filter{Include: []string{"docker*"}, Exclude: netutil.DefaultExclude}, "docker0", true},
+	}
+
+	for _, tc := range tcs {
+		if actual := tc.filter.Match(tc.name); actual != tc.expected {
+			t.Errorf("\nTest Data: (%v, %s)\nExpected: %t\nActual: %t", tc.filter, tc.name, tc.expected, actual)
+		}
+	}
+
+	t.Run("Invalid Pattern", func(t *testing.T) {
+		if err := (netutil.Filter{Exclude: []string{"eth["}}).Validate(); err == nil {
+			t.Errorf("\nExpected: error\nActual: %v", err)
+		}
+		if err := netutil.DefaultFilter.Validate(); err != nil {
+			t.Errorf("\nError: %s", err)
+		}
+	})
+}
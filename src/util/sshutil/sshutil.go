@@ -0,0 +1,84 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package sshutil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadOrCreateHostKey loads the host key of the OpenSSH private key file,
+// a new Ed25519 key is created and persisted when it is missing, so that
+// clients recognize the server across restarts. It reports whether the
+// key was created.
+func LoadOrCreateHostKey(keyFile string) (ssh.Signer, bool, error) {
+	byt, err := os.ReadFile(keyFile)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(byt)
+		return signer, false, err
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "localfs")
+	if err != nil {
+		return nil, false, err
+	}
+	if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, false, err
+	}
+	// the private key is readable by the owner only
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, false, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	return signer, err == nil, err
+}
+
+// LoadAuthorizedKeys loads the public keys of the authorized_keys file,
+// the options of the keys are ignored.
+func LoadAuthorizedKeys(file string) ([]ssh.PublicKey, error) {
+	byt, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []ssh.PublicKey{}
+	for i, line := range bytes.Split(byt, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", file, i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Authorized reports whether the key is one of the keys.
+func Authorized(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
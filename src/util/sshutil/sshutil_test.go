@@ -0,0 +1,88 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package sshutil_test
+
+import (
+	"bytes"
+	"localfs/util/sshutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLoadOrCreateHostKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "ssh", "host_ed25519_key")
+
+	signer, created, err := sshutil.LoadOrCreateHostKey(keyFile)
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+
+	t.Run("Create Host Key", func(t *testing.T) {
+		if !created {
+			t.Errorf("\nExpected the host key to be created.")
+		}
+		if signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+			t.Errorf("\nExpected: %s\nActual: %s", ssh.KeyAlgoED25519, signer.PublicKey().Type())
+		}
+		info, err := os.Stat(keyFile)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("\nExpected: %v\nActual: %v", os.FileMode(0600), info.Mode().Perm())
+		}
+	})
+
+	t.Run("Load Persisted Host Key", func(t *testing.T) {
+		loaded, created, err := sshutil.LoadOrCreateHostKey(keyFile)
+		if err != nil {
+			t.Errorf("\nError: %s", err)
+			t.FailNow()
+		}
+		if created || !bytes.Equal(loaded.PublicKey().Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("\nExpected the persisted host key to be loaded.")
+		}
+	})
+}
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	signer, _, err := sshutil.LoadOrCreateHostKey(filepath.Join(t.TempDir(), "key"))
+	if err != nil {
+		t.Errorf("\nError: %s", err)
+		t.FailNow()
+	}
+	other, _, _ := sshutil.LoadOrCreateHostKey(filepath.Join(t.TempDir(), "key"))
+	line := ssh.MarshalAuthorizedKey(signer.PublicKey())
+
+	tcs := []struct {
+		title   string
+		content string
+		keys    int
+		err     bool
+	}{
+		{"Keys With Comments", "# laptop\n\n" + string(line) + "\n", 1, false},
+		{"Key With Options", `from="192.168.1.0/24" ` + string(line), 1, false},
+		{"Invalid Key", string(line) + "ssh-ed25519 Fuiyoh!!\n", 0, true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.title, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "authorized_keys")
+			os.WriteFile(file, []byte(tc.content), 0600)
+			keys, err := sshutil.LoadAuthorizedKeys(file)
+			if (err != nil) != tc.err || len(keys) != tc.keys {
+				t.Errorf("\nExpected: %d keys, error %t\nActual: %d keys, %v", tc.keys, tc.err, len(keys), err)
+			}
+			if !tc.err && (!sshutil.Authorized(keys, signer.PublicKey()) || sshutil.Authorized(keys, other.PublicKey())) {
+				t.Errorf("\nExpected only the listed key to be authorized.")
+			}
+		})
+	}
+}
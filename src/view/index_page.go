@@ -7,6 +7,26 @@ package view
 type IndexPageViewModel struct {
 	Base64QRImage string
 	Address       string
+	// addresses of the host to switch the QR codes to
+	Addresses []IndexAddressItem
+	Pin       string
+	// QR codes of the roles, replacing the QR code above
+	Roles       []IndexRoleItem
+	Fingerprint string
+}
+
+// IndexAddressItem is a network address of the host.
+type IndexAddressItem struct {
+	Address  string
+	Link     string
+	Selected bool
+}
+
+// IndexRoleItem is the QR code pairing a device with a role.
+type IndexRoleItem struct {
+	Title         string
+	Base64QRImage string
+	Pin           string
 }
 
 const IndexPageTmpl string = `<!DOCTYPE html>
@@ -52,17 +72,68 @@ const IndexPageTmpl string = `<!DOCTYPE html>
       margin-block-end: 0rem !important;
       line-break: anywhere;
     }
+    div.addresses {
+      margin-bottom: 1.5rem;
+      line-height: 1.8rem;
+    }
+    div.addresses a {
+      color: #607d8b;
+      margin: 0 .4rem;
+      font-size: .85rem;
+      white-space: nowrap;
+    }
+    div.addresses a.selected {
+      color: #000;
+      font-weight: 600;
+      text-decoration: none;
+    }
+    p.fingerprint {
+      margin-top: 1rem;
+      font-family: monospace;
+      font-size: .8rem;
+      line-height: 1.2rem;
+    }
     img {
       width: 240px;
       height: 240px;
     }
+    div.roles {
+      display: flex;
+      flex-wrap: wrap;
+      justify-content: center;
+      gap: 1.5rem;
+    }
+    div.roles img {
+      width: 200px;
+      height: 200px;
+    }
   </style>
 </head>
 <body>
   <div class="center">
+    {{if gt (len .Addresses) 1}}
+    <div class="addresses">
+      {{range .Addresses}}<a href="{{.Link}}"{{if .Selected}} class="selected"{{end}}>{{.Address}}</a>{{end}}
+    </div>
+    {{end}}
+    {{if .Roles}}
+    <div class="roles">
+      {{range .Roles}}
+      <div>
+        <p>{{.Title}}</p>
+        <img src="data:image/png;base64, {{.Base64QRImage}}">
+        <p>{{if .Pin}}PIN {{.Pin}}{{else}}Password{{end}}</p>
+      </div>
+      {{end}}
+    </div>
+    <p>{{.Address}}</p>
+    {{else}}
     <p>Scan To Upload</p>
     <img src="data:image/png;base64, {{.Base64QRImage}}">
     <p>{{.Address}}</p>
+    {{if .Pin}}<p>PIN {{.Pin}}</p>{{end}}
+    {{end}}
+    {{if .Fingerprint}}<p class="fingerprint">Public key SHA-256<br>{{.Fingerprint}}</p>{{end}}
   </div>
 </body>
 </html>
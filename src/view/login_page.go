@@ -0,0 +1,93 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package view
+
+type LoginPageViewModel struct {
+	Password bool
+	Message  string
+	Next     string
+}
+
+const LoginPageTmpl string = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <meta http-equiv="X-UA-Compatible" content="ie=edge">
+  <title>localFS</title>
+  <style>
+    @media only screen and (max-width: 480px) {
+      body {
+        width: 86% !important;
+        padding: .85rem !important;
+      }
+      div.center {
+        padding: 1rem !important;
+      }
+    }
+    body {
+      margin: auto;
+      width: 60%;
+      padding: 1.5rem;
+      font-weight: 400;
+      font-size: 1rem;
+      line-height: 1rem;
+      font-family: sans-serif;
+    }
+    div.center {
+      display: block;
+      border-radius: .75rem;
+      padding: 1.5rem 2.5rem;
+      background-color: #e2e7ea;
+      text-align: center;
+    }
+    p {
+      color: #607d8b;
+      font-weight: 500;
+      margin-bottom: 1rem;
+      margin-block-start: 0rem !important;
+      line-break: anywhere;
+    }
+    p.error {
+      color: #b71c1c;
+      font-size: .95rem;
+    }
+    input {
+      font-size: 1rem;
+    }
+    input[type="password"] {
+      display: block;
+      width: 100%;
+      box-sizing: border-box;
+      border: 1px solid #cfd8dc;
+      border-radius: .75rem;
+      padding: .375rem .75rem;
+      margin-bottom: 1rem;
+      color: #607d8b;
+      text-align: center;
+    }
+    input[type="submit"] {
+      color: #fff;
+      background-color: #28a745;
+      border: 1px solid transparent;
+      padding: .375rem .75rem;
+      line-height: 1.2rem;
+      border-radius: .75rem;
+    }
+  </style>
+</head>
+<body>
+  <div class="center">
+    <p>{{if .Password}}Enter Password{{else}}Enter PIN{{end}}</p>
+    {{if .Message}}<p class="error">{{.Message}}</p>{{end}}
+    <form method="post" action="/login">
+      <input type="hidden" name="next" value="{{.Next}}" />
+      <input type="password" name="secret" {{if not .Password}}inputmode="numeric" autocomplete="one-time-code"{{end}} autofocus required />
+      <input type="submit" value="Sign In">
+    </form>
+  </div>
+</body>
+</html>
+`
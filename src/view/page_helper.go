@@ -4,6 +4,12 @@
 
 package view
 
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
 var ListingIndex = func(idx int) int {
 	return idx + 1
 }
@@ -12,11 +18,73 @@ var ListingZebraCss = func(idx int) bool {
 	return idx%2 != 0
 }
 
+// DownloadLink returns the escaped download URL path of the file.
+var DownloadLink = func(name string) string {
+	return "/download/" + EscapePath(name)
+}
+
+// EscapePath escapes each segment of the slash-separated path,
+// so names such as "a#b?.txt" stay intact in URLs.
+func EscapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// FormatSize returns the size in bytes as a human readable text.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// FolderLink returns the upload page URL of the folder.
+var FolderLink = func(dir string) string {
+	if dir == "" {
+		return "/upload"
+	}
+	return "/upload?dir=" + url.QueryEscape(dir)
+}
+
+// Breadcrumbs returns the navigation bar of the folder, each parent
+// folder links to its upload page and the folder is the active item.
+func Breadcrumbs(dir string, items ...NavItem) NavBar {
+	navBar := NavBar{NavItem: items}
+	if dir == "" {
+		navBar.ActiveItem = "Upload"
+		return navBar
+	}
+
+	navBar.NavItem = append(navBar.NavItem, NavItem{Name: "Upload", Link: FolderLink("")})
+	segments := strings.Split(dir, "/")
+	for i, segment := range segments[:len(segments)-1] {
+		link := FolderLink(strings.Join(segments[:i+1], "/"))
+		navBar.NavItem = append(navBar.NavItem, NavItem{Name: segment, Link: link})
+	}
+	navBar.ActiveItem = segments[len(segments)-1]
+	return navBar
+}
+
 type NavItem struct {
 	Name string
 	Link string
 }
 
+type ListingItem struct {
+	Name  string
+	Path  string
+	IsDir bool
+}
+
 type NavBar struct {
 	NavItem    []NavItem
 	ActiveItem string
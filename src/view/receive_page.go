@@ -0,0 +1,103 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package view
+
+// ReceivePageViewModel is the page of 'localfs receive', the Files are
+// the names received by the last upload, and the form is shown while
+// Remaining files are expected.
+type ReceivePageViewModel struct {
+	Token     string
+	Files     []string
+	Message   string
+	Remaining int
+}
+
+const ReceivePageTmpl string = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <meta http-equiv="X-UA-Compatible" content="ie=edge">
+  <meta name="robots" content="noindex">
+  <title>localFS</title>
+  <style>
+    @media only screen and (max-width: 480px) {
+      body {
+        width: 86% !important;
+        padding: .85rem !important;
+      }
+      div.center {
+        padding: 1rem !important;
+      }
+    }
+    body {
+      margin: auto;
+      width: 60%;
+      padding: 1.5rem;
+      font-weight: 400;
+      font-size: 1rem;
+      line-height: 1rem;
+      font-family: sans-serif;
+    }
+    div.center {
+      display: block;
+      border-radius: .75rem;
+      padding: 1.5rem 2.5rem;
+      background-color: #e2e7ea;
+      text-align: center;
+    }
+    p {
+      color: #607d8b;
+      font-weight: 500;
+      margin-bottom: 1rem;
+      margin-block-start: 0rem !important;
+      line-break: anywhere;
+    }
+    p.detail {
+      font-weight: 400;
+      font-size: .9rem;
+    }
+    p.error {
+      color: #b71c1c;
+      font-size: .95rem;
+    }
+    input {
+      font-size: 1rem;
+    }
+    input[type="file"] {
+      display: block;
+      width: 100%;
+      box-sizing: border-box;
+      margin-bottom: 1rem;
+      color: #607d8b;
+    }
+    input[type="submit"] {
+      display: inline-block;
+      color: #fff;
+      background-color: #28a745;
+      border: 1px solid transparent;
+      padding: .375rem .75rem;
+      line-height: 1.2rem;
+      border-radius: .75rem;
+    }
+  </style>
+</head>
+<body>
+  <div class="center">
+    {{range .Files}}<p>{{.}} &check;</p>{{end}}
+    {{if .Message}}<p class="error">{{.Message}}</p>{{end}}
+    {{if gt .Remaining 0}}
+    <p class="detail">{{.Remaining}} file(s) expected</p>
+    <form method="post" enctype="multipart/form-data" action="/receive/{{.Token}}">
+      <input type="file" name="file" {{if gt .Remaining 1}}multiple{{end}} required />
+      <input type="submit" value="Send">
+    </form>
+    {{else}}
+    <p class="detail">All files are received.</p>
+    {{end}}
+  </div>
+</body>
+</html>
+`
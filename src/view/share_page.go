@@ -0,0 +1,215 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package view
+
+// SharePageViewModel renders the form sharing the file Name, the
+// share Link once created, or else the list of Shares.
+type SharePageViewModel struct {
+	NavBar  NavBar
+	Dir     string
+	Name    string
+	Message string
+	Link    ShareItem
+	Shares  []ShareItem
+}
+
+// ShareItem is a share link of a file.
+type ShareItem struct {
+	Token         string
+	Path          string
+	URL           string
+	Expires       string
+	Downloads     string
+	Protected     bool
+	Base64QRImage string
+}
+
+const SharePageTmpl string = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <meta http-equiv="X-UA-Compatible" content="ie=edge">
+  <title>localFS</title>
+  <style>
+    @media only screen and (max-width: 480px) {
+      body {
+        width: 86% !important;
+        padding: .85rem !important;
+      }
+      div.info {
+        padding: 1rem !important;
+      }
+      img {
+        width: 200px !important;
+        height: 200px !important;
+      }
+    }
+    body {
+      margin: auto;
+      width: 60%;
+      padding: 1.5rem;
+      font-weight: 400;
+      font-size: 1rem;
+      line-height: 1rem;
+      font-family: sans-serif;
+    }
+    div.navbar {
+      display: block;
+      margin-bottom: 1.5rem;
+    }
+    ul {
+      list-style-type: none;
+      margin: 0;
+      padding: 0;
+    }
+    li {
+      display: inline;
+      font-size: .9rem;
+      color: #607d8b;
+    }
+    li > a {
+      color: #607d8b;
+    }
+    li+::before {
+      content: " / ";
+      margin: 0rem .15rem;
+    }
+    div.info {
+      display: block;
+      border-radius: .75rem;
+      padding: 1.5rem;
+      background-color: #eceff1;
+      margin: 1rem 0rem;
+    }
+    div.center {
+      text-align: center;
+    }
+    p.lead {
+      color: #607d8b;
+      font-size: 1.1rem;
+      font-weight: 500;
+      margin-block-start: 0rem;
+      line-break: anywhere;
+    }
+    p.info {
+      color: #607D8B;
+      font-size: 1rem;
+      margin-block-start: 0rem;
+      margin-block-end: 0rem;
+      padding: .5rem;
+      line-break: anywhere;
+    }
+    p.info > a {
+      color: #607d8b;
+    }
+    p.error {
+      color: #b71c1c;
+      font-size: .95rem;
+    }
+    label {
+      display: block;
+      color: #607d8b;
+      font-size: .95rem;
+      margin-bottom: 1rem;
+    }
+    select, input[type="number"], input[type="password"] {
+      display: block;
+      width: 100%;
+      box-sizing: border-box;
+      border: 1px solid #cfd8dc;
+      border-radius: .75rem;
+      padding: .375rem .75rem;
+      margin-top: .35rem;
+      color: #607d8b;
+      font-size: 1rem;
+      background-color: #fff;
+    }
+    input[type="submit"] {
+      color: #fff;
+      background-color: #28a745;
+      border: 1px solid transparent;
+      padding: .375rem .75rem;
+      line-height: 1.2rem;
+      border-radius: .75rem;
+      font-size: 1rem;
+    }
+    input[type="submit"].revoke {
+      color: #b71c1c;
+      background-color: #eceff1;
+      border: 1px solid #cfd8dc;
+      margin-top: .5rem;
+    }
+    img {
+      width: 240px;
+      height: 240px;
+    }
+  </style>
+</head>
+<body>
+  <div class="navbar">
+    <ul>
+    {{range $idx, $item := .NavBar.NavItem}}
+      <li><a href="{{$item.Link}}">{{$item.Name}}</a></li>
+    {{end}}
+    <li>{{.NavBar.ActiveItem}}</li>
+    </ul>
+  </div>
+  {{if .Link.Token}}
+  <div class="info center">
+    <p class="lead">Scan To Download</p>
+    <img src="data:image/png;base64, {{.Link.Base64QRImage}}">
+    <p class="info"><a href="{{.Link.URL}}">{{.Link.URL}}</a></p>
+  </div>
+  <div class="info">
+    <p class="info">file: {{.Link.Path}}</p>
+    <p class="info">expires: {{.Link.Expires}}</p>
+    <p class="info">downloads: {{.Link.Downloads}}</p>
+    {{if .Link.Protected}}<p class="info">password protected</p>{{end}}
+    <form method="post" action="/upload/share/{{.Link.Token}}/revoke">
+      <input class="revoke" type="submit" value="Revoke Link">
+    </form>
+  </div>
+  {{else if .Name}}
+  <div class="info">
+    <p class="lead">Share '{{.Name}}'</p>
+    {{if .Message}}<p class="error">{{.Message}}</p>{{end}}
+    <form method="post" action="/upload/share{{if .Dir}}?dir={{.Dir}}{{end}}">
+      <input type="hidden" name="name" value="{{.Name}}" />
+      <label>Expires
+        <select name="expiry">
+          <option value="1h">in 1 hour</option>
+          <option value="24h" selected>in 1 day</option>
+          <option value="168h">in 7 days</option>
+          <option value="">never</option>
+        </select>
+      </label>
+      <label>Downloads, 0 for unlimited
+        <input type="number" name="downloads" min="0" value="1" />
+      </label>
+      <label>Password, optional
+        <input type="password" name="password" autocomplete="new-password" />
+      </label>
+      <input type="submit" value="Create Link">
+    </form>
+  </div>
+  {{else}}
+  {{range .Shares}}
+  <div class="info">
+    <p class="info">file: <a href="/upload/share/{{.Token}}">{{.Path}}</a></p>
+    <p class="info">expires: {{.Expires}}</p>
+    <p class="info">downloads: {{.Downloads}}</p>
+    {{if .Protected}}<p class="info">password protected</p>{{end}}
+    <form method="post" action="/upload/share/{{.Token}}/revoke">
+      <input class="revoke" type="submit" value="Revoke Link">
+    </form>
+  </div>
+  {{else}}
+  <p class="info">No share links. Share a file from its row on the upload page.</p>
+  {{end}}
+  {{end}}
+</body>
+</html>
+`
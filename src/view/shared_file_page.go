@@ -0,0 +1,112 @@
+// Copyright 2024 The localFS Authors.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package view
+
+// SharedFilePageViewModel is the page of a share link shown to the
+// visitor, the Message replaces the download of an unusable link.
+type SharedFilePageViewModel struct {
+	Token     string
+	Filename  string
+	Size      string
+	Expires   string
+	Password  bool
+	Message   string
+	Available bool
+}
+
+const SharedFilePageTmpl string = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <meta http-equiv="X-UA-Compatible" content="ie=edge">
+  <meta name="robots" content="noindex">
+  <title>localFS</title>
+  <style>
+    @media only screen and (max-width: 480px) {
+      body {
+        width: 86% !important;
+        padding: .85rem !important;
+      }
+      div.center {
+        padding: 1rem !important;
+      }
+    }
+    body {
+      margin: auto;
+      width: 60%;
+      padding: 1.5rem;
+      font-weight: 400;
+      font-size: 1rem;
+      line-height: 1rem;
+      font-family: sans-serif;
+    }
+    div.center {
+      display: block;
+      border-radius: .75rem;
+      padding: 1.5rem 2.5rem;
+      background-color: #e2e7ea;
+      text-align: center;
+    }
+    p {
+      color: #607d8b;
+      font-weight: 500;
+      margin-bottom: 1rem;
+      margin-block-start: 0rem !important;
+      line-break: anywhere;
+    }
+    p.detail {
+      font-weight: 400;
+      font-size: .9rem;
+    }
+    p.error {
+      color: #b71c1c;
+      font-size: .95rem;
+    }
+    input {
+      font-size: 1rem;
+    }
+    input[type="password"] {
+      display: block;
+      width: 100%;
+      box-sizing: border-box;
+      border: 1px solid #cfd8dc;
+      border-radius: .75rem;
+      padding: .375rem .75rem;
+      margin-bottom: 1rem;
+      color: #607d8b;
+      text-align: center;
+    }
+    input[type="submit"], a.download {
+      display: inline-block;
+      color: #fff;
+      background-color: #28a745;
+      border: 1px solid transparent;
+      padding: .375rem .75rem;
+      line-height: 1.2rem;
+      border-radius: .75rem;
+      text-decoration: none;
+    }
+  </style>
+</head>
+<body>
+  <div class="center">
+    {{if .Filename}}<p>{{.Filename}}</p>{{end}}
+    {{if .Size}}<p class="detail">{{.Size}}{{if .Expires}} &middot; expires {{.Expires}}{{end}}</p>{{end}}
+    {{if .Message}}<p class="error">{{.Message}}</p>{{end}}
+    {{if .Available}}
+    {{if .Password}}
+    <form method="post" action="/s/{{.Token}}">
+      <input type="password" name="password" placeholder="Password" autofocus required />
+      <input type="submit" value="Download">
+    </form>
+    {{else}}
+    <a class="download" href="/s/{{.Token}}/download">Download</a>
+    {{end}}
+    {{end}}
+  </div>
+</body>
+</html>
+`
@@ -5,8 +5,14 @@
 package view
 
 type UploadPageViewModel struct {
-	Build  string
-	Files  []string
+	Build    string
+	Dir      string
+	ReadOnly bool
+	// DropBox hides the listing, for the upload-only role
+	DropBox bool
+	// Share shows the share links of the files
+	Share  bool
+	Files  []ListingItem
 	NavBar NavBar
 }
 
@@ -38,7 +44,7 @@ const UploadPageTmpl string = `<!DOCTYPE html>
         order: 1 !important;
       } 
       */
-      span.download {
+      span.actions {
         text-align: left !important;
         margin-right: 0rem !important;
       }
@@ -101,6 +107,66 @@ const UploadPageTmpl string = `<!DOCTYPE html>
       text-align: center;
       margin-bottom: 2.5rem;
     }
+    button.cancel {
+      display: none;
+      color: #b71c1c;
+      background-color: #eceff1;
+      border: 1px solid #cfd8dc;
+      padding: .375rem .75rem;
+      line-height: 1.2rem;
+      border-radius: .75rem;
+      font-size: 1rem;
+      margin-left: .5rem;
+    }
+    div.queue {
+      text-align: left;
+    }
+    div.queue > div {
+      margin-top: 1rem;
+    }
+    div.queue p {
+      display: flex;
+      color: #607d8b;
+      font-size: .85rem;
+      margin: 0rem 0rem .35rem 0rem;
+    }
+    div.queue span.name {
+      flex: 1 1 auto;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+      font-weight: 500;
+    }
+    div.queue span.stat {
+      flex: 0 0 auto;
+      margin-left: .5rem;
+    }
+    div.queue a {
+      color: #b71c1c;
+      margin-left: .5rem;
+      text-decoration: none;
+      cursor: pointer;
+    }
+    div.queue div.bar {
+      height: .5rem;
+      border-radius: .25rem;
+      background-color: #cfd8dc;
+      overflow: hidden;
+    }
+    div.queue div.bar > div {
+      width: 0%;
+      height: 100%;
+      background-color: #0288d1;
+    }
+    div.queue div.done div.bar > div {
+      background-color: #28a745;
+    }
+    div.queue div.failed span.stat {
+      color: #b71c1c;
+    }
+    div.queue div.failed div.bar > div {
+      background-color: #e57373;
+    }
     span.progress {
       color: #006064;
       background-color: #e2e7ea;
@@ -119,6 +185,12 @@ const UploadPageTmpl string = `<!DOCTYPE html>
       font-weight: 500;
       margin-bottom: .5rem;
     }
+    p.lead > a.shares {
+      float: right;
+      color: #607d8b;
+      font-size: .9rem;
+      font-weight: 400;
+    }
     div.flex-container {
       display: flex;
       flex-direction: row;
@@ -140,21 +212,27 @@ const UploadPageTmpl string = `<!DOCTYPE html>
     }  
     div.flex-container .flex-right {
       order: 2;
-      flex: 8%; 
+      flex: 0 0 auto;
       padding: 1rem .25rem;
     } 
     span.index {
       margin-right: .75rem;
     }
-    span.download {
+    span.actions {
       font-size: 1.25rem;
       display: block;
       text-align: right;
       margin-right: .65rem;
+      white-space: nowrap;
     }
-    span.download > a {
+    span.actions > a {
       text-decoration: none;
-    } 
+      margin-left: .5rem;
+    }
+    input.select {
+      margin: 0rem .75rem 0rem 0rem;
+      vertical-align: middle;
+    }
     i.fa-error::before {
       /* Font Awesome Free 6.7.2 by @fontawesome - https://fontawesome.com License - https://fontawesome.com/license/free Copyright 2025 Fonticons, Inc. */
       content: url('data:image/svg+xml;utf8,<svg viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg"><path d="m23.999 2.9988c1.3313 0 2.5596 0.70318 3.2346 1.8564l20.251 34.502c0.68442 1.1626 0.68442 2.5971 0.01875 3.7596-0.66567 1.1626-1.9126 1.8845-3.2534 1.8845h-40.503c-1.3407 0-2.5877-0.72193-3.2534-1.8845-0.66567-1.1626-0.6563-2.6064 0.018752-3.7596l20.251-34.502c0.67505-1.1532 1.9033-1.8564 3.2346-1.8564zm0 12.001c-1.247 0-2.2502 1.0032-2.2502 2.2502v10.501c0 1.247 1.0032 2.2502 2.2502 2.2502s2.2502-1.0032 2.2502-2.2502v-10.501c0-1.247-1.0032-2.2502-2.2502-2.2502zm3.0002 21.002a3.0002 3.0002 0 1 0-6.0004 0 3.0002 3.0002 0 1 0 6.0004 0z" fill="%23b71c1c" stroke-width=".093757"/></svg>');
@@ -172,6 +250,71 @@ const UploadPageTmpl string = `<!DOCTYPE html>
       width: 22px;
       vertical-align: middle;
     }
+    i.fa-folder::before {
+      content: url('data:image/svg+xml;utf8,<svg viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg"><path d="m6 42h36c3.3094 0 6-2.6906 6-6v-21c0-3.3094-2.6906-6-6-6h-15l-4.5-4.5c-0.9375-0.9375-2.2125-1.5-3.5438-1.5h-12.956c-3.3094 0-6 2.6906-6 6v27c0 3.3094 2.6906 6 6 6z" fill="%2390a4ae"/></svg>');
+    }
+    i.fa-folder {
+      width: 20px;
+      margin-right: .5rem;
+      vertical-align: middle;
+    }
+    a.folder {
+      color: #607d8b;
+      text-decoration: none;
+      font-weight: 500;
+    }
+    div.folder {
+      display: flex;
+      justify-content: flex-end;
+      flex-wrap: wrap;
+      row-gap: .5rem;
+      margin-bottom: .75rem;
+    }
+    div.folder input[type="text"] {
+      border: 1px solid #cfd8dc;
+      border-radius: .75rem;
+      padding: .375rem .75rem;
+      margin-right: .5rem;
+      color: #607d8b;
+    }
+    div.folder input[type="submit"] {
+      background-color: #607d8b;
+    }
+    div.folder button {
+      color: #607d8b;
+      background-color: #eceff1;
+      border: 1px solid #cfd8dc;
+      padding: .375rem .75rem;
+      line-height: 1.2rem;
+      border-radius: .75rem;
+      font-size: 1rem;
+      margin-right: .5rem;
+    }
+    div.folder button.delete {
+      color: #b71c1c;
+    }
+    div.folder form {
+      margin-left: auto;
+    }
+    div.folder form#dform {
+      margin-left: 0rem;
+    }
+    i.fa-rename::before {
+      content: url('data:image/svg+xml;utf8,<svg viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg"><path d="m33.879 4.2426c1.1716-1.1716 3.0711-1.1716 4.2426 0l5.6360 5.6360c1.1716 1.1716 1.1716 3.0711 0 4.2426l-25.758 25.758-11.999 2.1213 2.1213-11.999zm-23.364 25.121-1.0607 6.0104 6.0104-1.0607 18.071-18.071-4.9497-4.9497z" fill="%23607d8b"/></svg>');
+    }
+    i.fa-move::before {
+      content: url('data:image/svg+xml;utf8,<svg viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg"><path d="m6 42h36c3.3094 0 6-2.6906 6-6v-21c0-3.3094-2.6906-6-6-6h-15l-4.5-4.5c-0.9375-0.9375-2.2125-1.5-3.5438-1.5h-12.956c-3.3094 0-6 2.6906-6 6v27c0 3.3094 2.6906 6 6 6z" fill="%23cfd8dc"/><path d="m12 22.5h15v-6l10.5 8.25-10.5 8.25v-6h-15z" fill="%23607d8b"/></svg>');
+    }
+    i.fa-delete::before {
+      content: url('data:image/svg+xml;utf8,<svg viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg"><path d="m18 3h12c1.6594 0 3 1.3406 3 3v1.5h9c1.6594 0 3 1.3406 3 3s-1.3406 3-3 3h-36c-1.6594 0-3-1.3406-3-3s1.3406-3 3-3h9v-1.5c0-1.6594 1.3406-3 3-3zm-10.5 13.5h33l-1.8 25.2c-0.1125 1.8844-1.6781 3.3-3.5625 3.3h-22.275c-1.8844 0-3.45-1.4156-3.5625-3.3z" fill="%23b0bec5"/></svg>');
+    }
+    i.fa-share::before {
+      content: url('data:image/svg+xml;utf8,<svg viewBox="0 0 48 48" xmlns="http://www.w3.org/2000/svg"><path d="m15.5 21.2 16-8m-16 13.6 16 8" stroke="%23607d8b" stroke-width="3.5"/><circle cx="36" cy="10" r="6.5" fill="%23607d8b"/><circle cx="36" cy="38" r="6.5" fill="%23607d8b"/><circle cx="11" cy="24" r="6.5" fill="%23b0bec5"/></svg>');
+    }
+    i.fa-rename, i.fa-move, i.fa-delete, i.fa-share {
+      width: 20px;
+      vertical-align: middle;
+    }
     i {
       display: inline-block;
     }
@@ -194,6 +337,15 @@ const UploadPageTmpl string = `<!DOCTYPE html>
       margin-bottom: 2rem;
       border-radius: .75rem;
     }
+    form > label {
+      display: block;
+      color: #607d8b;
+      font-size: .9rem;
+      margin-bottom: .35rem;
+    }
+    input#ufile {
+      margin-bottom: 1rem;
+    }
     input::file-selector-button {
       color: #fff;
       background-color: #0288d1;
@@ -238,45 +390,78 @@ const UploadPageTmpl string = `<!DOCTYPE html>
   </div>
   <div class="build">build#{{.Build}}</div>
   <div id="error" class="error"><i class="fa-error"></i></div>
+  {{if not .ReadOnly}}
   <div class="center">
-    <form id="uform" method="post" enctype="multipart/form-data" action="/upload/file">
-      <input id="ufile" type="file" name="file" />
+    <form id="uform" method="post" enctype="multipart/form-data" action="/upload/file{{if .Dir}}?dir={{.Dir}}{{end}}">
+      <label for="ufile">Files</label>
+      <input id="ufile" type="file" name="file" multiple />
+      <label for="udir">Folder</label>
+      <input id="udir" type="file" name="file" webkitdirectory multiple />
       <span id="uprocess" class="process"></span>
-      <span id="uprocesslabel" class="uprocesslabel"</span>
+      <span id="uprocesslabel" class="uprocesslabel"></span>
       <input id="usubmit" type="submit" value="Upload File">
+      <button id="ucancel" type="button" class="cancel">Cancel</button>
     </form>
+    <div id="uqueue" class="queue"></div>
   </div>
+  {{end}}
+  {{if not .DropBox}}
   <div class="head">
-    <p class="lead">Uploaded File(s)</p>
+    <p class="lead">Uploaded File(s){{if .Share}}<a class="shares" href="/upload/shares">Share Links</a>{{end}}</p>
   </div>
-  <!-- Listing -->
-  {{if gt (len .Files) 0}} {{range $idx, $item := .Files}}{{if zebraCss $idx}}
-  <div class="flex-container even">
-    <div class="flex-left">
-      <span class="index">{{index $idx}}.</span>{{$item}}
-    </div>
-    <div class="flex-right">
-      <span class="download"><a href="/download/{{$item}}" download="{{$item}}"><i class="fa-download"></i></a></span>
-    </div>
+  <div class="folder">
+    <!-- the selected names, or else the whole folder -->
+    <form id="dform" method="get" action="/archive">
+      {{if .Dir}}<input type="hidden" name="dir" value="{{.Dir}}" />{{end}}
+      <button type="submit" name="format" value="zip" title="Download the selected items, or the whole folder">Download ZIP</button>
+      <button type="submit" name="format" value="tar.gz" title="Download the selected items, or the whole folder">Download TAR.GZ</button>
+    </form>
+    {{if not .ReadOnly}}
+    <button type="button" data-batch="move">Move Selected</button>
+    <button type="button" class="delete" data-batch="delete">Delete Selected</button>
+    <form method="post" action="/upload/folder{{if .Dir}}?dir={{.Dir}}{{end}}">
+      <input type="text" name="name" placeholder="Folder name" required />
+      <input type="submit" value="New Folder">
+    </form>
+    {{end}}
   </div>
-  {{else}}
-  <div class="flex-container">
+  {{if not .ReadOnly}}<form id="aform" method="post"></form>{{end}}
+  <!-- Listing -->
+  {{range $idx, $item := .Files}}
+  <div class="flex-container{{if zebraCss $idx}} even{{end}}">
     <div class="flex-left">
-      <span class="index">{{index $idx}}.</span>{{$item}}
+      <input class="select" type="checkbox" name="name" form="dform" value="{{$item.Name}}" />
+      <span class="index">{{index $idx}}.</span>{{if $item.IsDir}}<a class="folder" href="{{folderLink $item.Path}}"><i class="fa-folder"></i>{{$item.Name}}</a>{{else}}{{$item.Name}}{{end}}
     </div>
     <div class="flex-right">
-      <span class="download"><a href="/download/{{$item}}" download="{{$item}}"><i class="fa-download"></i></a></span>
+      <span class="actions">
+        {{if not $.ReadOnly}}
+        <a href="#" title="Rename" data-action="rename" data-name="{{$item.Name}}"><i class="fa-rename"></i></a>
+        <a href="#" title="Move" data-action="move" data-name="{{$item.Name}}"><i class="fa-move"></i></a>
+        <a href="#" title="Delete" data-action="delete" data-name="{{$item.Name}}"><i class="fa-delete"></i></a>
+        {{end}}
+        {{if and $.Share (not $item.IsDir)}}<a href="/upload/share?{{if $.Dir}}dir={{$.Dir}}&{{end}}name={{$item.Name}}" title="Share"><i class="fa-share"></i></a>{{end}}
+        {{if not $item.IsDir}}<a href="{{downloadLink $item.Path}}" title="Download" download="{{$item.Name}}"><i class="fa-download"></i></a>{{end}}
+      </span>
     </div>
   </div>
-  {{end}}{{end}}{{end}}
+  {{end}}
+  {{end}}
+  {{if not .ReadOnly}}
   <script>
     let errmsg = "No file selected. Please choose a file to upload."
+    let selectmsg = "No file selected. Please select the files first."
+    let dir = {{.Dir}};
+    let aform = document.getElementById("aform");
     let error = document.getElementById("error");
     let uform = document.getElementById("uform");
     let ufile = document.getElementById("ufile");
+    let udir = document.getElementById("udir");
     let usubmit = document.getElementById("usubmit");
     let uprocess = document.getElementById("uprocess");
     let uprocesslabel = document.getElementById("uprocesslabel");
+    let ucancel = document.getElementById("ucancel");
+    let uqueue = document.getElementById("uqueue");
 
     // https://stackoverflow.com/questions/8861181/8861236#8861236
     window.addEventListener("pageshow", () => {
@@ -298,7 +483,70 @@ const UploadPageTmpl string = `<!DOCTYPE html>
       }
     }
 
-    ufile.onchange = function () {
+    errorShow = function(message) {
+      // reset
+      errorReset()
+
+      error.style.display = "block"
+      error.appendChild(document.createTextNode(message));
+    }
+
+    // submit the file operation of the names to the server
+    actionSubmit = function(action, names, fields) {
+      aform.action = "/upload/" + action + (dir ? "?dir=" + encodeURIComponent(dir) : "");
+      aform.replaceChildren();
+      let append = (name, value) => {
+        let input = document.createElement("input");
+        input.type = "hidden";
+        input.name = name;
+        input.value = value;
+        aform.appendChild(input);
+      }
+      names.forEach((name) => append("name", name));
+      Object.entries(fields).forEach(([name, value]) => append(name, value));
+      aform.submit();
+    }
+
+    // confirm the file operation of the names
+    action = function(action, names) {
+      if (names.length === 0) {
+        errorShow(selectmsg);
+        return
+      }
+
+      let label = names.length === 1 ? "'" + names[0] + "'" : names.length + " items";
+      if (action === "delete") {
+        if (confirm("Delete " + label + "? This can not be undone.")) {
+          actionSubmit(action, names, {});
+        }
+      } else if (action === "rename") {
+        let newname = prompt("Rename " + label + " to", names[0]);
+        if (newname && newname !== names[0]) {
+          actionSubmit(action, names, {newname: newname});
+        }
+      } else if (action === "move") {
+        let target = prompt("Move " + label + " to folder", dir);
+        if (target !== null && target !== dir) {
+          actionSubmit(action, names, {target: target});
+        }
+      }
+    }
+
+    document.querySelectorAll("a[data-action]").forEach((a) => {
+      a.addEventListener("click", (e) => {
+        e.preventDefault();
+        action(a.dataset.action, [a.dataset.name]);
+      });
+    });
+
+    document.querySelectorAll("button[data-batch]").forEach((button) => {
+      button.addEventListener("click", () => {
+        let names = Array.from(document.querySelectorAll("input.select:checked"), (c) => c.value);
+        action(button.dataset.batch, names);
+      });
+    });
+
+    ufile.onchange = udir.onchange = function () {
       if (this.files[0]) {
         // reset
         error.style.display = "none"
@@ -306,32 +554,306 @@ const UploadPageTmpl string = `<!DOCTYPE html>
       }
     }
 
+    // resumable uploads of the tus protocol, an interrupted upload of the
+    // same file is resumed from the offset stored by the server
+    const tusEndpoint = "/upload/tus/";
+    const tusChunkSize = 8 * 1024 * 1024;
+    const tusRetryDelays = [1000, 2000, 4000, 8000, 15000];
+
+    let base64 = function(bytes) {
+      let binary = "";
+      new Uint8Array(bytes).forEach((b) => binary += String.fromCharCode(b));
+      return btoa(binary);
+    }
+
+    // tusRequest sends the request with XHR, which reports the upload
+    // progress, the request of the upload state is aborted on cancel
+    let tusRequest = function(upload, method, url, headers, body) {
+      return new Promise((resolve, reject) => {
+        let xhr = new XMLHttpRequest();
+        xhr.open(method, url);
+        xhr.setRequestHeader("Tus-Resumable", "1.0.0");
+        Object.entries(headers).forEach(([name, value]) => xhr.setRequestHeader(name, value));
+        xhr.upload.onprogress = (e) => upload.sent(e.loaded);
+        xhr.onload = () => resolve({
+          ok: xhr.status >= 200 && xhr.status < 300,
+          status: xhr.status,
+          header: (name) => xhr.getResponseHeader(name),
+          text: xhr.responseText || xhr.statusText,
+        });
+        xhr.onerror = () => reject(new Error("network error"));
+        xhr.onabort = () => reject(upload.cancelled);
+        upload.xhr = xhr;
+        xhr.send(body);
+      });
+    }
+
+    // tusError fails the upload, other errors are retried
+    let tusError = function(res) {
+      let error = new Error(res.text.trim());
+      error.fatal = res.status < 500 && ![409, 423, 460].includes(res.status);
+      return error;
+    }
+
+    // tusUpload uploads the file of the upload state and returns the
+    // upload status link, the name is relative, e.g. of a folder upload
+    let tusUpload = async function(upload) {
+      let file = upload.file;
+      let key = "tus:" + [dir, upload.name, file.size, file.lastModified].join(":");
+      let metadata = "filename " + base64(new TextEncoder().encode(file.name)) +
+        ",dir " + base64(new TextEncoder().encode(dir));
+      if (upload.name !== file.name) {
+        metadata += ",relativePath " + base64(new TextEncoder().encode(upload.name));
+      }
+      upload.url = localStorage.getItem(key);
+      let attempt = 0;
+
+      for (;;) {
+        try {
+          let res;
+          if (upload.url) {
+            res = await tusRequest(upload, "HEAD", upload.url, {});
+            if (res.status === 404) {
+              // expired or removed, start over
+              localStorage.removeItem(key);
+              upload.url = null;
+              continue;
+            }
+            if (!res.ok) throw tusError(res);
+            upload.offset = parseInt(res.header("Upload-Offset"), 10);
+          } else {
+            res = await tusRequest(upload, "POST", tusEndpoint, {
+              "Upload-Length": String(file.size),
+              "Upload-Metadata": metadata,
+            });
+            if (!res.ok) throw tusError(res);
+            upload.url = res.header("Location");
+            upload.offset = 0;
+            localStorage.setItem(key, upload.url);
+          }
+
+          let status = res.header("X-Upload-Status");
+          while (!status) {
+            upload.sent(0);
+            let chunk = file.slice(upload.offset, upload.offset + tusChunkSize);
+            let headers = {
+              "Content-Type": "application/offset+octet-stream",
+              "Upload-Offset": String(upload.offset),
+            };
+            // crypto.subtle is available in secure contexts only
+            if (window.crypto && crypto.subtle) {
+              let digest = await crypto.subtle.digest("SHA-256", await chunk.arrayBuffer());
+              headers["Upload-Checksum"] = "sha256 " + base64(digest);
+            }
+            res = await tusRequest(upload, "PATCH", upload.url, headers, chunk);
+            if (!res.ok) throw tusError(res);
+            upload.offset = parseInt(res.header("Upload-Offset"), 10);
+            status = res.header("X-Upload-Status");
+            attempt = 0;
+          }
+
+          localStorage.removeItem(key);
+          return status;
+        } catch (error) {
+          if (error === upload.cancelled) {
+            localStorage.removeItem(key);
+            throw error;
+          }
+          if (error.fatal) {
+            localStorage.removeItem(key);
+            throw error;
+          }
+          // network error, e.g. the screen locked, resume after a delay
+          upload.show("Connection lost, resuming...");
+          await new Promise((resolve) => {
+            upload.wake = resolve;
+            setTimeout(resolve, tusRetryDelays[Math.min(attempt++, tusRetryDelays.length - 1)]);
+          });
+          if (upload.cancel.done) throw upload.cancelled;
+        }
+      }
+    }
+
+    // tusTerminate removes the partial upload from the server,
+    // retried while the aborted chunk still holds the upload
+    let tusTerminate = async function(upload) {
+      for (let attempt = 0; upload.url && attempt < 5; attempt++) {
+        let res = await tusRequest(upload, "DELETE", upload.url, {}).catch(() => null);
+        if (res && res.status !== 423) return;
+        await new Promise((resolve) => setTimeout(resolve, 500));
+      }
+    }
+
+    let formatSize = function(bytes) {
+      let units = ["B", "KB", "MB", "GB", "TB"];
+      let i = 0;
+      for (; bytes >= 1024 && i < units.length - 1; i++) {
+        bytes /= 1024;
+      }
+      return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+    }
+
+    let formatTime = function(seconds) {
+      seconds = Math.ceil(seconds);
+      let h = Math.floor(seconds / 3600);
+      let m = Math.floor(seconds % 3600 / 60);
+      let s = String(seconds % 60).padStart(2, "0");
+      return h > 0 ? h + ":" + String(m).padStart(2, "0") + ":" + s : m + ":" + s;
+    }
+
+    // newUpload returns the upload state of the file, shown as a row
+    // of the queue with its progress, speed and remaining time
+    let newUpload = function(file) {
+      let row = document.createElement("div");
+      let p = document.createElement("p");
+      let name = document.createElement("span");
+      let stat = document.createElement("span");
+      let cancel = document.createElement("a");
+      let bar = document.createElement("div");
+      let fill = document.createElement("div");
+      name.className = "name";
+      stat.className = "stat";
+      bar.className = "bar";
+      cancel.textContent = "\u2715";
+      cancel.title = "Cancel";
+      bar.appendChild(fill);
+      p.append(name, stat, cancel);
+      row.append(p, bar);
+      uqueue.appendChild(row);
+
+      let upload = {
+        file: file,
+        name: file.webkitRelativePath || file.name,
+        offset: 0,
+        cancelled: new Error("cancelled"),
+        cancel: cancel,
+        speed: 0,
+        sample: null,
+      };
+      name.textContent = upload.name;
+      stat.textContent = "Waiting";
+
+      upload.show = (text) => stat.textContent = text;
+
+      // sent reports the bytes of the current chunk sent so far
+      upload.sent = (loaded) => {
+        let done = Math.min(upload.offset + loaded, file.size);
+        let percent = file.size ? done * 100 / file.size : 100;
+        fill.style.width = percent + "%";
+
+        // smoothed throughput of the samples at least half a second apart
+        let now = performance.now();
+        if (!upload.sample) {
+          upload.sample = {time: now, done: done};
+        } else if (now - upload.sample.time >= 500) {
+          let speed = (done - upload.sample.done) * 1000 / (now - upload.sample.time);
+          upload.speed = upload.speed ? upload.speed * 0.7 + speed * 0.3 : speed;
+          upload.sample = {time: now, done: done};
+        }
+
+        let text = Math.floor(percent) + "%";
+        if (upload.speed > 0) {
+          text += " \u00b7 " + formatSize(upload.speed) + "/s \u00b7 " +
+            formatTime((file.size - done) / upload.speed) + " left";
+        }
+        upload.show(text);
+      }
+
+      upload.finish = (state, text) => {
+        row.className = state;
+        cancel.remove();
+        if (state === "done") fill.style.width = "100%";
+        upload.show(text);
+      }
+
+      cancel.addEventListener("click", (e) => {
+        e.preventDefault();
+        if (cancel.done) return;
+        cancel.done = true;
+        if (upload.xhr) upload.xhr.abort();
+        if (upload.wake) upload.wake();
+      });
+      return upload;
+    }
+
     uform.addEventListener("submit", (e) => {
       e.preventDefault();
-      if (ufile.value === undefined || ufile.value === "") {
-        // reset
-        errorReset()
-
-        error.style.display = "block"
-        error.appendChild(document.createTextNode(errmsg));
+      let files = Array.from(ufile.files).concat(Array.from(udir.files));
+      if (files.length === 0) {
+        errorShow(errmsg);
         return
       }
 
-      uprocess.style.display = "inline-block";
-      uprocesslabel.style.display = "inline-block";
-      uprocesslabel.textContent = "Uploading...";
       usubmit.disabled = true;
       usubmit.style.display = "none";
-      uform.submit();
 
       // prevent choose new file during upload in progress
       // can not use disabled due to iOS will not upload  
       // ufile.disabled = true;
-      ufile.onclick = function() {
+      ufile.onclick = udir.onclick = function() {
         return false;
       }
+
+      // fallback to the form upload on browsers without XHR upload progress
+      if (!window.localStorage || !window.TextEncoder || !("upload" in new XMLHttpRequest())) {
+        uprocess.style.display = "inline-block";
+        uprocesslabel.style.display = "inline-block";
+        uprocesslabel.textContent = "Uploading...";
+        uform.submit();
+        return
+      }
+
+      uqueue.replaceChildren();
+      let uploads = files.map(newUpload);
+      ucancel.style.display = "inline-block";
+      ucancel.onclick = () => uploads.forEach((upload) => upload.cancel.click());
+
+      // upload one file after another, a failed file does not fail the others
+      (async () => {
+        let uids = [], failed = 0;
+        for (let upload of uploads) {
+          if (upload.cancel.done) {
+            upload.finish("failed", "Cancelled");
+            failed++;
+            continue;
+          }
+          try {
+            let status = await tusUpload(upload);
+            uids.push(new URL(status, location.href).searchParams.get("uid"));
+            upload.finish("done", "Completed");
+          } catch (error) {
+            failed++;
+            if (error === upload.cancelled) {
+              upload.finish("failed", "Cancelled");
+              await tusTerminate(upload);
+            } else {
+              upload.finish("failed", error.message);
+            }
+          }
+        }
+
+        let status = "/upload/status?" + uids.map((uid) => "uid=" + encodeURIComponent(uid)).join("&");
+        if (failed === 0) {
+          location.href = status;
+          return
+        }
+
+        ucancel.style.display = "none";
+        usubmit.disabled = false;
+        usubmit.style.display = "";
+        ufile.onclick = udir.onclick = null;
+        uform.reset();
+        errorShow(failed + " of " + uploads.length + " file(s) failed or cancelled.");
+        if (uids.length > 0) {
+          let a = document.createElement("a");
+          a.href = status;
+          a.textContent = " View the uploaded file(s).";
+          error.appendChild(a);
+        }
+      })();
     });
   </script>
+  {{end}}
 </body>
 </html>
 `
@@ -5,12 +5,18 @@
 package view
 
 type UploadStatusPageViewModel struct {
+	Files  []UploadStatusItem
+	Failed int
+	NavBar NavBar
+}
+
+// UploadStatusItem is the status of an uploaded file.
+type UploadStatusItem struct {
 	Error     bool
 	Message   string
 	Filename  string
 	Size      string
 	Sha256sum string
-	NavBar    NavBar
 }
 
 const UploadStatusPageTmpl string = `<!DOCTYPE html>
@@ -91,6 +97,13 @@ const UploadStatusPageTmpl string = `<!DOCTYPE html>
       background-color: #ffcdd2;
       color: #b71c1c;
     }
+    p.summary {
+      color: #607d8b;
+      font-weight: 500;
+    }
+    p.summary > span.failed {
+      color: #b71c1c;
+    }
     span.message {
       color: #b71c1c;
       font-size: .85rem;
@@ -123,6 +136,10 @@ const UploadStatusPageTmpl string = `<!DOCTYPE html>
     <li>{{.NavBar.ActiveItem}}</li>
     </ul>
   </div>
+  {{if gt (len .Files) 1}}
+  <p class="summary">{{len .Files}} files, {{if .Failed}}<span class="failed">{{.Failed}} failed</span>{{else}}all completed{{end}}</p>
+  {{end}}
+  {{range .Files}}
   <div class="info">
     {{if .Error}}
       <span class="status error">Error</span>
@@ -131,9 +148,12 @@ const UploadStatusPageTmpl string = `<!DOCTYPE html>
       <span class="status success"><i class="fa-success"></i>Completed</span>
     {{end}}
     <p class="info">file: {{.Filename}}</p>
+    {{if .Sha256sum}}
     <p class="info">size: {{.Size}}</p>
     <p class="info">hash: {{.Sha256sum}}</p>
+    {{end}}
   </div>
+  {{end}}
 </body>
 </html>
 `